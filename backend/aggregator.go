@@ -1,28 +1,163 @@
 package main
 
 import (
+	"net/url"
 	"sort"
+	"strings"
 )
 
 // deduplicateAndRank merges duplicate URLs and sorts by score descending.
+// Provenance (engines with their positions and generated queries) is unioned
+// across duplicates so the surviving result records every source that
+// returned it.
 func deduplicateAndRank(in []SearchResult) []SearchResult {
 	m := make(map[string]SearchResult)
+	var order []string
 	for _, r := range in {
-		if existing, ok := m[r.URL]; ok {
-			// keep the higher score & longer snippet
-			if r.Score > existing.Score {
-				m[r.URL] = r
-			}
-		} else {
+		existing, ok := m[r.URL]
+		if !ok {
 			m[r.URL] = r
+			order = append(order, r.URL)
+			continue
 		}
+		// keep the higher score & longer snippet
+		best, other := existing, r
+		if r.Score > existing.Score {
+			best, other = r, existing
+		}
+		best.Engines, best.Positions = unionEngines(best.Engines, best.Positions, other.Engines, other.Positions)
+		best.Queries = unionStrings(best.Queries, other.Queries)
+		if best.PublishedDate == nil {
+			best.PublishedDate = other.PublishedDate
+		}
+		if best.Category == "" {
+			best.Category = other.Category
+		}
+		m[r.URL] = best
 	}
-	var out []SearchResult
-	for _, v := range m {
-		out = append(out, v)
+	out := make([]SearchResult, 0, len(order))
+	for _, u := range order {
+		out = append(out, m[u])
 	}
-	sort.Slice(out, func(i, j int) bool {
+	sort.SliceStable(out, func(i, j int) bool {
 		return out[i].Score > out[j].Score
 	})
 	return out
 }
+
+// unionEngines unions two engine lists and keeps positions in step with
+// them, positions[i] being the rank engines[i] gave the result. An engine in
+// both lists keeps the position from a. Positions are dropped when either
+// side does not line up with its engines, since they could not be matched.
+func unionEngines(a []string, aPos []int, b []string, bPos []int) ([]string, []int) {
+	engines := unionStrings(a, b)
+	if len(aPos) != len(a) || len(bPos) != len(b) || len(engines) == 0 {
+		return engines, nil
+	}
+	rank := make(map[string]int, len(engines))
+	for i := len(b) - 1; i >= 0; i-- {
+		rank[b[i]] = bPos[i]
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		rank[a[i]] = aPos[i]
+	}
+	positions := make([]int, len(engines))
+	for i, e := range engines {
+		positions[i] = rank[e]
+	}
+	return engines, positions
+}
+
+// unionStrings returns a followed by the items of b not already present.
+func unionStrings(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	seen := make(map[string]bool, len(a)+len(b))
+	out := make([]string, 0, len(a)+len(b))
+	for _, s := range a {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	for _, s := range b {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// PartialResult is a result streamed before the search completes, with its
+// rank among everything found so far. The rank may change as more queries
+// finish.
+type PartialResult struct {
+	Rank int `json:"rank"`
+	SearchResult
+}
+
+// provisionalRanker accumulates results as queries complete and reports
+// the URLs that have not been streamed yet.
+type provisionalRanker struct {
+	all  []SearchResult
+	sent map[string]bool
+}
+
+func newProvisionalRanker() *provisionalRanker {
+	return &provisionalRanker{sent: make(map[string]bool)}
+}
+
+// add merges in and returns the newly seen results with their provisional
+// rank, plus the number of unique results found so far.
+func (p *provisionalRanker) add(in []SearchResult) ([]PartialResult, int) {
+	p.all = append(p.all, in...)
+	ranked := deduplicateAndRank(p.all)
+	var fresh []PartialResult
+	for i, r := range ranked {
+		if p.sent[r.URL] {
+			continue
+		}
+		p.sent[r.URL] = true
+		fresh = append(fresh, PartialResult{Rank: i + 1, SearchResult: r})
+	}
+	return fresh, len(ranked)
+}
+
+// trackingParams are query parameters that never change the page content.
+var trackingParams = map[string]bool{
+	"fbclid": true,
+	"gclid":  true,
+	"yclid":  true,
+	"mc_cid": true,
+	"mc_eid": true,
+}
+
+// canonicalURL returns a comparison key under which links to the same page
+// match across runs. The scheme is ignored and the host is lowercased
+// without "www." and default ports. Fragments, tracking parameters and
+// trailing slashes are dropped, and the remaining query parameters are
+// sorted. Unparsable URLs are returned unchanged.
+func canonicalURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	q := u.Query()
+	for name := range q {
+		if trackingParams[name] || strings.HasPrefix(strings.ToLower(name), "utm_") {
+			q.Del(name)
+		}
+	}
+	key := "//" + host + strings.TrimRight(u.EscapedPath(), "/")
+	if len(q) > 0 {
+		// Encode сортирует параметры по имени
+		key += "?" + q.Encode()
+	}
+	return key
+}
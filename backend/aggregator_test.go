@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestDeduplicateAndRank(t *testing.T) {
 	in := []SearchResult{
@@ -19,3 +22,59 @@ func TestDeduplicateAndRank(t *testing.T) {
 		t.Fatalf("expected deduplicated a.com with score 0.8")
 	}
 }
+
+func TestDeduplicateAndRankMergesProvenance(t *testing.T) {
+	in := []SearchResult{
+		{URL: "https://a.com", Score: 0.5, Engines: []string{"google", "brave"}, Positions: []int{3, 1}, Queries: []string{"q1"}},
+		{URL: "https://a.com", Score: 0.8, Engines: []string{"bing", "google"}, Positions: []int{2, 5}, Queries: []string{"q2"}},
+		{URL: "https://b.com", Score: 0.4, Engines: []string{"google"}, Positions: []int{4}},
+		{URL: "https://b.com", Score: 0.3, Engines: []string{"bing"}},
+	}
+	out := deduplicateAndRank(in)
+	if len(out) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(out))
+	}
+	// Позиции идут в паре с движками, у общего движка остается позиция лучшей копии
+	if !reflect.DeepEqual(out[0].Engines, []string{"bing", "google", "brave"}) || !reflect.DeepEqual(out[0].Positions, []int{2, 5, 1}) {
+		t.Fatalf("unexpected engines %v with positions %v", out[0].Engines, out[0].Positions)
+	}
+	if !reflect.DeepEqual(out[1].Engines, []string{"google", "bing"}) || out[1].Positions != nil {
+		t.Fatalf("expected positions to be dropped when a copy has none, got %v %v", out[1].Engines, out[1].Positions)
+	}
+	if len(out[0].Queries) != 2 || out[0].Queries[0] != "q2" || out[0].Queries[1] != "q1" {
+		t.Fatalf("unexpected queries: %v", out[0].Queries)
+	}
+}
+
+func TestProvisionalRankerStreamsOnlyNewResults(t *testing.T) {
+	p := newProvisionalRanker()
+
+	fresh, total := p.add([]SearchResult{{URL: "https://a.com", Score: 0.5}, {URL: "https://b.com", Score: 0.9}})
+	if total != 2 || len(fresh) != 2 || fresh[0].URL != "https://b.com" || fresh[0].Rank != 1 || fresh[1].Rank != 2 {
+		t.Fatalf("unexpected first batch: %+v (total %d)", fresh, total)
+	}
+
+	fresh, total = p.add([]SearchResult{{URL: "https://a.com", Score: 0.6}, {URL: "https://c.com", Score: 0.7}})
+	if total != 3 || len(fresh) != 1 || fresh[0].URL != "https://c.com" || fresh[0].Rank != 2 {
+		t.Fatalf("expected only c.com ranked second, got %+v (total %d)", fresh, total)
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	same := []string{
+		"https://www.Example.com/docs/?utm_source=rss&b=2&a=1#intro",
+		"http://example.com:80/docs?a=1&b=2&fbclid=x",
+		"https://example.com/docs?a=1&b=2",
+	}
+	for _, raw := range same {
+		if got := canonicalURL(raw); got != "//example.com/docs?a=1&b=2" {
+			t.Fatalf("canonicalURL(%q) = %q", raw, got)
+		}
+	}
+	if canonicalURL("https://example.com/Docs") == canonicalURL("https://example.com/docs") {
+		t.Fatal("expected paths to stay case-sensitive")
+	}
+	if got := canonicalURL("https://example.com:8443/"); got != "//example.com:8443" {
+		t.Fatalf("unexpected key for a custom port %q", got)
+	}
+}
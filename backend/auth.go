@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Области доступа API ключей
+const (
+	scopeAll    = "*"
+	scopeSearch = "search"
+)
+
+var knownScopes = map[string]bool{
+	scopeAll:    true,
+	scopeSearch: true,
+}
+
+const (
+	minAPIKeyLength = 16
+
+	apiKeyHeader = "X-API-Key"
+	apiKeyQuery  = "api_key"
+	// apiKeySubprotocolPrefix lets browsers, which cannot set headers on a
+	// WebSocket handshake, pass the key as "apikey.<key>"
+	apiKeySubprotocolPrefix = "apikey."
+)
+
+var (
+	ErrUnauthorized = &AppError{
+		Code:    "UNAUTHORIZED",
+		Message: "API key is missing or invalid",
+		Status:  http.StatusUnauthorized,
+	}
+	ErrForbidden = &AppError{
+		Code:    "FORBIDDEN",
+		Message: "API key is not allowed to use this endpoint",
+		Status:  http.StatusForbidden,
+	}
+	ErrQuotaExceeded = &AppError{
+		Code:    "QUOTA_EXCEEDED",
+		Message: "Daily quota for this API key is exhausted",
+		Status:  http.StatusTooManyRequests,
+	}
+	ErrAuthDisabled = &AppError{
+		Code:    "AUTH_DISABLED",
+		Message: "API key authentication is not enabled",
+		Status:  http.StatusNotFound,
+	}
+)
+
+// Principal is the authenticated caller behind an API key.
+type Principal struct {
+	Name          string
+	Scopes        []string
+	DailySearches int
+	DailyTokens   int64
+}
+
+// HasScope reports whether the key grants scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == scopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// principalName returns the key name, or "" when authentication is off.
+func principalName(p *Principal) string {
+	if p == nil {
+		return ""
+	}
+	return p.Name
+}
+
+// keyUsage counts what a key consumed on the given UTC day.
+type keyUsage struct {
+	day      string
+	searches int
+	tokens   int64
+}
+
+// QuotaStatus is the usage report returned by GET /api/quota.
+type QuotaStatus struct {
+	Name          string    `json:"name"`
+	Scopes        []string  `json:"scopes"`
+	SearchesUsed  int       `json:"searches_used"`
+	SearchesLimit int       `json:"searches_limit"`
+	TokensUsed    int64     `json:"tokens_used"`
+	TokensLimit   int64     `json:"tokens_limit"`
+	ResetsAt      time.Time `json:"resets_at"`
+}
+
+// Authenticator resolves API keys and enforces per-key daily quotas. Usage
+// is kept in memory by key name, so it survives config reloads but not a
+// restart.
+type Authenticator struct {
+	mu      sync.Mutex
+	enabled bool
+	keys    map[[sha256.Size]byte]*Principal
+	usage   map[string]*keyUsage
+	now     func() time.Time
+}
+
+func NewAuthenticator(cfg AuthConfig) *Authenticator {
+	a := &Authenticator{usage: make(map[string]*keyUsage), now: time.Now}
+	a.Update(cfg)
+	return a
+}
+
+// Update replaces the key set, e.g. after a config reload.
+func (a *Authenticator) Update(cfg AuthConfig) {
+	keys := make(map[[sha256.Size]byte]*Principal, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		keys[sha256.Sum256([]byte(k.Key))] = &Principal{
+			Name:          k.Name,
+			Scopes:        k.Scopes,
+			DailySearches: k.DailySearches,
+			DailyTokens:   k.DailyTokens,
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enabled = cfg.Enabled
+	a.keys = keys
+}
+
+// Enabled reports whether requests must carry an API key.
+func (a *Authenticator) Enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enabled
+}
+
+// Authenticate returns the principal for key and checks that it grants
+// scope. With authentication disabled it returns a nil principal and no
+// error.
+func (a *Authenticator) Authenticate(key, scope string) (*Principal, *AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled {
+		return nil, nil
+	}
+	if key == "" {
+		authRejectionsTotal.WithLabelValues(ErrUnauthorized.Code).Inc()
+		return nil, ErrUnauthorized
+	}
+	// Ключи сравниваем по хешу, чтобы поиск по map не зависел от префикса секрета
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		authRejectionsTotal.WithLabelValues(ErrUnauthorized.Code).Inc()
+		return nil, ErrUnauthorized
+	}
+	if scope != "" && !p.HasScope(scope) {
+		authRejectionsTotal.WithLabelValues(ErrForbidden.Code).Inc()
+		return nil, NewAppError(ErrForbidden.Code, ErrForbidden.Message,
+			fmt.Sprintf("key %q lacks scope %q", p.Name, scope), ErrForbidden.Status)
+	}
+	return p, nil
+}
+
+// Principal returns the key named name, for work done on its behalf outside
+// of a request. With authentication disabled it returns nil and true; ok is
+// false when no configured key has that name.
+func (a *Authenticator) Principal(name string) (p *Principal, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled {
+		return nil, true
+	}
+	for _, p := range a.keys {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// BeginSearch charges one search to p, refusing it when either the search
+// or the token quota is already used up. Tokens are only known once a
+// search finishes, so the search that crosses the token limit completes.
+func (a *Authenticator) BeginSearch(p *Principal) *AppError {
+	if p == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u := a.usageLocked(p.Name)
+	if p.DailySearches > 0 && u.searches >= p.DailySearches {
+		authRejectionsTotal.WithLabelValues(ErrQuotaExceeded.Code).Inc()
+		return NewAppError(ErrQuotaExceeded.Code, ErrQuotaExceeded.Message,
+			fmt.Sprintf("searches: %d of %d used, resets at %s", u.searches, p.DailySearches, a.resetAt().Format(time.RFC3339)),
+			ErrQuotaExceeded.Status)
+	}
+	if p.DailyTokens > 0 && u.tokens >= p.DailyTokens {
+		authRejectionsTotal.WithLabelValues(ErrQuotaExceeded.Code).Inc()
+		return NewAppError(ErrQuotaExceeded.Code, ErrQuotaExceeded.Message,
+			fmt.Sprintf("tokens: %d of %d used, resets at %s", u.tokens, p.DailyTokens, a.resetAt().Format(time.RFC3339)),
+			ErrQuotaExceeded.Status)
+	}
+	u.searches++
+	return nil
+}
+
+// AddTokens charges LLM tokens spent on behalf of p.
+func (a *Authenticator) AddTokens(p *Principal, tokens int64) {
+	if p == nil || tokens <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.usageLocked(p.Name).tokens += tokens
+}
+
+// Quota reports today's usage of p.
+func (a *Authenticator) Quota(p *Principal) QuotaStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.usageLocked(p.Name)
+	return QuotaStatus{
+		Name:          p.Name,
+		Scopes:        p.Scopes,
+		SearchesUsed:  u.searches,
+		SearchesLimit: p.DailySearches,
+		TokensUsed:    u.tokens,
+		TokensLimit:   p.DailyTokens,
+		ResetsAt:      a.resetAt(),
+	}
+}
+
+// usageLocked returns the counters for name, resetting them when the UTC
+// day has changed. a.mu must be held.
+func (a *Authenticator) usageLocked(name string) *keyUsage {
+	day := a.now().UTC().Format(time.DateOnly)
+	u, ok := a.usage[name]
+	if !ok || u.day != day {
+		u = &keyUsage{day: day}
+		a.usage[name] = u
+	}
+	return u
+}
+
+func (a *Authenticator) resetAt() time.Time {
+	now := a.now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// apiKeyFromRequest extracts the key from the X-API-Key header or an
+// "Authorization: Bearer" header. For WebSocket handshakes it also accepts
+// an "apikey.<key>" subprotocol and the api_key query parameter; the
+// matched subprotocol is returned so it can be echoed back to the client.
+func apiKeyFromRequest(r *http.Request, websocket bool) (key, subprotocol string) {
+	if key = strings.TrimSpace(r.Header.Get(apiKeyHeader)); key != "" {
+		return key, ""
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:]), ""
+	}
+	if !websocket {
+		return "", ""
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, proto := range strings.Split(header, ",") {
+			proto = strings.TrimSpace(proto)
+			if strings.HasPrefix(proto, apiKeySubprotocolPrefix) {
+				return strings.TrimPrefix(proto, apiKeySubprotocolPrefix), proto
+			}
+		}
+	}
+	return r.URL.Query().Get(apiKeyQuery), ""
+}
+
+type principalKey struct{}
+
+func contextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFromContext returns the caller set by AuthMiddleware, or nil when
+// authentication is disabled.
+func principalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// AuthMiddleware rejects requests without a valid API key granting scope.
+// An empty scope only requires a valid key.
+func AuthMiddleware(auth *Authenticator, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, _ := apiKeyFromRequest(r, false)
+			p, appErr := auth.Authenticate(key, scope)
+			if appErr != nil {
+				ErrorResponse(w, appErr)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// RequireScope rejects callers whose key lacks scope. It runs after
+// AuthMiddleware and lets everything through when authentication is off.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p := principalFromContext(r.Context()); p != nil && !p.HasScope(scope) {
+				authRejectionsTotal.WithLabelValues(ErrForbidden.Code).Inc()
+				ErrorResponse(w, NewAppError(ErrForbidden.Code, ErrForbidden.Message,
+					fmt.Sprintf("key %q lacks scope %q", p.Name, scope), ErrForbidden.Status))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// handleQuota reports the calling key's usage for today.
+func handleQuota(auth *Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFromContext(r.Context())
+		if p == nil {
+			ErrorResponse(w, ErrAuthDisabled)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(auth.Quota(p))
+	}
+}
+
+// tokenUsage accumulates LLM tokens and the cost OpenRouter reports within
+// one search. It travels in the context so every OpenRouter call can report
+// into it.
+type tokenUsage struct {
+	total      atomic.Int64
+	prompt     atomic.Int64
+	completion atomic.Int64
+	// costMicros is the reported cost in millionths of a credit, an integer
+	// so that it can be added atomically
+	costMicros atomic.Int64
+}
+
+func (u *tokenUsage) add(usage *openRouterUsage) {
+	u.prompt.Add(int64(usage.PromptTokens))
+	u.completion.Add(int64(usage.CompletionTokens))
+	u.total.Add(int64(usage.PromptTokens + usage.CompletionTokens))
+	u.costMicros.Add(int64(math.Round(usage.Cost * 1e6)))
+}
+
+func (u *tokenUsage) cost() float64 {
+	return float64(u.costMicros.Load()) / 1e6
+}
+
+type tokenUsageKey struct{}
+
+func contextWithTokenUsage(ctx context.Context, u *tokenUsage) context.Context {
+	return context.WithValue(ctx, tokenUsageKey{}, u)
+}
+
+func tokenUsageFromContext(ctx context.Context) *tokenUsage {
+	u, _ := ctx.Value(tokenUsageKey{}).(*tokenUsage)
+	return u
+}
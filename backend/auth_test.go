@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAuthConfig() AuthConfig {
+	return AuthConfig{
+		Enabled: true,
+		Keys: []APIKeyConfig{
+			{Name: "web", Key: "web-key-0123456789", Scopes: []string{scopeSearch}, DailySearches: 2, DailyTokens: 100},
+			{Name: "ops", Key: "ops-key-0123456789"},
+		},
+	}
+}
+
+func TestAuthenticateScopes(t *testing.T) {
+	auth := NewAuthenticator(testAuthConfig())
+
+	if _, err := auth.Authenticate("", scopeSearch); err == nil || err.Code != ErrUnauthorized.Code {
+		t.Fatalf("expected UNAUTHORIZED for missing key, got %v", err)
+	}
+	if _, err := auth.Authenticate("wrong-key-0123456789", scopeSearch); err == nil || err.Code != ErrUnauthorized.Code {
+		t.Fatalf("expected UNAUTHORIZED for unknown key, got %v", err)
+	}
+	if _, err := auth.Authenticate("ops-key-0123456789", scopeSearch); err == nil || err.Code != ErrForbidden.Code {
+		t.Fatalf("expected FORBIDDEN for key without scope, got %v", err)
+	}
+	p, err := auth.Authenticate("web-key-0123456789", scopeSearch)
+	if err != nil || p.Name != "web" {
+		t.Fatalf("expected web principal, got %+v, %v", p, err)
+	}
+
+	auth.Update(AuthConfig{})
+	if p, err := auth.Authenticate("", scopeSearch); p != nil || err != nil {
+		t.Fatalf("expected disabled auth to allow anonymous access, got %+v, %v", p, err)
+	}
+}
+
+func TestQuotaLimitsAndDailyReset(t *testing.T) {
+	auth := NewAuthenticator(testAuthConfig())
+	now := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	auth.now = func() time.Time { return now }
+	p, _ := auth.Authenticate("web-key-0123456789", scopeSearch)
+
+	for i := 0; i < 2; i++ {
+		if err := auth.BeginSearch(p); err != nil {
+			t.Fatalf("search %d rejected: %v", i+1, err)
+		}
+	}
+	err := auth.BeginSearch(p)
+	if err == nil || err.Code != ErrQuotaExceeded.Code || !strings.HasPrefix(err.Details, "searches:") {
+		t.Fatalf("expected search quota error, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := auth.BeginSearch(p); err != nil {
+		t.Fatalf("expected quota to reset on a new day, got %v", err)
+	}
+	auth.AddTokens(p, 150)
+	err = auth.BeginSearch(p)
+	if err == nil || !strings.HasPrefix(err.Details, "tokens:") {
+		t.Fatalf("expected token quota error, got %v", err)
+	}
+
+	q := auth.Quota(p)
+	if q.SearchesUsed != 1 || q.TokensUsed != 150 || !q.ResetsAt.Equal(time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected quota status: %+v", q)
+	}
+}
+
+func TestAPIKeyFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/ws/search?api_key=query-key", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", "search.v1, apikey.proto-key")
+	key, proto := apiKeyFromRequest(r, true)
+	if key != "proto-key" || proto != "apikey.proto-key" {
+		t.Fatalf("expected subprotocol key, got %q %q", key, proto)
+	}
+
+	r.Header.Del("Sec-WebSocket-Protocol")
+	if key, _ := apiKeyFromRequest(r, true); key != "query-key" {
+		t.Fatalf("expected query key for websocket, got %q", key)
+	}
+	if key, _ := apiKeyFromRequest(r, false); key != "" {
+		t.Fatalf("query key must be ignored outside websocket, got %q", key)
+	}
+
+	r.Header.Set("Authorization", "Bearer bearer-key")
+	if key, _ := apiKeyFromRequest(r, false); key != "bearer-key" {
+		t.Fatalf("expected bearer key, got %q", key)
+	}
+	r.Header.Set(apiKeyHeader, "header-key")
+	if key, _ := apiKeyFromRequest(r, false); key != "header-key" {
+		t.Fatalf("expected X-API-Key to win, got %q", key)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := NewAuthenticator(testAuthConfig())
+	handler := AuthMiddleware(auth, "")(handleQuota(auth))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/quota", nil))
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `"UNAUTHORIZED"`) {
+		t.Fatalf("expected 401 with error code, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/api/quota", nil)
+	req.Header.Set(apiKeyHeader, "ops-key-0123456789")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"ops"`) {
+		t.Fatalf("expected quota for ops key, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLoadConfigAuthKeysFile(t *testing.T) {
+	keysPath := writeConfigFile(t, "keys.yaml", `
+keys:
+  - name: cli
+    key: cli-key-0123456789
+    scopes: [search]
+    daily_tokens: 50000
+`)
+	t.Setenv("AUTH_ENABLED", "true")
+	t.Setenv("AUTH_KEYS_FILE", keysPath)
+
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Auth.Keys) != 1 || cfg.Auth.Keys[0].Name != "cli" || cfg.Auth.Keys[0].DailyTokens != 50000 {
+		t.Fatalf("unexpected keys: %+v", cfg.Auth.Keys)
+	}
+	if redacted := redactConfig(cfg); redacted.Auth.Keys[0].Key != redactedValue || cfg.Auth.Keys[0].Key != "cli-key-0123456789" {
+		t.Fatalf("expected redaction on a copy only")
+	}
+
+	bad := writeConfigFile(t, "keys.toml", `
+[[keys]]
+name = "cli"
+key = "short"
+scopes = ["serach"]
+`)
+	t.Setenv("AUTH_KEYS_FILE", bad)
+	_, err = loadConfig("")
+	if err == nil || !strings.Contains(err.Error(), "at least 16") || !strings.Contains(err.Error(), `unknown scope "serach"`) {
+		t.Fatalf("expected key validation errors, got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// BatchItem is one search of a batch. ID is optional and echoed back so
+// callers can match results to their own records.
+type BatchItem struct {
+	ID       string   `json:"id,omitempty"`
+	Prompt   string   `json:"prompt"`
+	Settings Settings `json:"settings"`
+	Locale   string   `json:"locale,omitempty"`
+}
+
+// BatchResult is one NDJSON line of the batch response. Exactly one of
+// Result and Error is set.
+type BatchResult struct {
+	Index  int             `json:"index"`
+	ID     string          `json:"id,omitempty"`
+	Result *WSSearchResult `json:"result,omitempty"`
+	Error  *WSError        `json:"error,omitempty"`
+}
+
+// parsedBatchItem keeps a line that failed to decode so it can be reported
+// in its place instead of aborting the batch.
+type parsedBatchItem struct {
+	item BatchItem
+	err  error
+}
+
+// resultCollector is a messageSink that keeps only the outcome of a search.
+type resultCollector struct {
+	mu     sync.Mutex
+	result *WSSearchResult
+	err    *WSError
+}
+
+func (c *resultCollector) Send(msgType string, data interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch v := data.(type) {
+	case WSSearchResult:
+		c.result = &v
+	case WSError:
+		c.err = &v
+	}
+	return nil
+}
+
+// BatchHandler serves POST /api/batch: it runs many searches and streams
+// one NDJSON line per finished item, in completion order.
+type BatchHandler struct {
+	store   *ConfigStore
+	auth    *Authenticator
+	limiter *RateLimiter
+	conns   *ConnManager
+	slots   *SearchSlots
+	history *SearchHistory
+	logger  *Logger
+}
+
+func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := h.store.Load()
+	principal := principalFromContext(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.Batch.MaxBodySize)
+	items, err := readBatchItems(r, cfg)
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrInvalidRequest, err))
+		return
+	}
+	if len(items) == 0 {
+		ErrorResponse(w, NewAppError(ErrInvalidRequest.Code, ErrInvalidRequest.Message, "batch is empty", ErrInvalidRequest.Status))
+		return
+	}
+	if len(items) > cfg.Batch.MaxItems {
+		ErrorResponse(w, NewAppError(ErrInvalidRequest.Code, ErrInvalidRequest.Message,
+			fmt.Sprintf("batch has %d items, at most %d are allowed", len(items), cfg.Batch.MaxItems), ErrInvalidRequest.Status))
+		return
+	}
+	// Батч целиком считается одним обращением к лимиту, элементы расходуют квоту
+	if ok, retryAfter := h.limiter.Allow(rateLimitKey(principal, r, cfg.RateLimit), cfg.RateLimit); !ok {
+		ErrorResponse(w, rateLimitedError(fmt.Sprintf("search rate limit of %g per minute exceeded", cfg.RateLimit.SearchesPerMinute), retryAfter))
+		return
+	}
+
+	h.logger.Info("batch started", "items", len(items), "api_key", principalName(principal))
+	start := time.Now()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	failed := h.run(r.Context(), items, cfg, func(res BatchResult) error {
+		// Общий WriteTimeout сервера меньше длительности батча, продлеваем на каждую строку
+		_ = rc.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	h.logger.Info("batch finished", "items", len(items), "failed", failed, "elapsed_ms", time.Since(start).Milliseconds())
+}
+
+// run executes items with at most cfg.Batch.Concurrency running at once and
+// passes each result to emit in completion order. When emit fails, e.g.
+// because the client went away, the remaining items are canceled. It
+// returns the number of failed items.
+func (h *BatchHandler) run(ctx context.Context, items []parsedBatchItem, cfg AppConfig, emit func(BatchResult) error) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan BatchResult)
+	go func() {
+		var eg errgroup.Group
+		eg.SetLimit(cfg.Batch.Concurrency)
+		for i, parsed := range items {
+			i, parsed := i, parsed
+			eg.Go(func() error {
+				res := h.runItem(ctx, i, parsed, cfg)
+				select {
+				case results <- res:
+				case <-ctx.Done():
+				}
+				return nil
+			})
+		}
+		_ = eg.Wait()
+		close(results)
+	}()
+
+	var failed int
+	for res := range results {
+		if res.Error != nil {
+			failed++
+		}
+		if err := emit(res); err != nil {
+			h.logger.Warn("batch output failed, canceling remaining items", "error", err)
+			cancel()
+		}
+	}
+	return failed
+}
+
+// runItem runs one batch item and converts every failure into a per-item
+// error.
+func (h *BatchHandler) runItem(ctx context.Context, index int, parsed parsedBatchItem, cfg AppConfig) BatchResult {
+	res := BatchResult{Index: index, ID: parsed.item.ID}
+	fail := func(appErr *AppError) BatchResult {
+		res.Error = &WSError{Code: appErr.Code, Message: appErr.Message, Details: appErr.Details}
+		return res
+	}
+	if parsed.err != nil {
+		return fail(WrapError(ErrInvalidRequest, parsed.err))
+	}
+
+	searchReq := SearchRequest{Prompt: parsed.item.Prompt, Settings: parsed.item.Settings}
+	SanitizeSearchRequest(&searchReq)
+	if searchReq.Settings.Queries == 0 {
+		searchReq.Settings.Queries = cfg.Search.DefaultQueryCount
+	}
+	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
+		return fail(NewAppError("VALIDATION_FAILED", "Request validation failed", validationErrors.Error(), http.StatusBadRequest))
+	}
+	if !h.conns.BeginSearch() {
+		return fail(ErrShuttingDown)
+	}
+	defer h.conns.EndSearch()
+	if appErr := h.auth.BeginSearch(principalFromContext(ctx)); appErr != nil {
+		return fail(appErr)
+	}
+
+	if locale := supportedLocale(parsed.item.Locale); locale != "" {
+		ctx = contextWithLocale(ctx, locale)
+	}
+	// Отмена запроса клиентом и таймаут драйна останавливают элемент
+	itemCtx, cancel := h.conns.SearchContext(ctx, cfg.WebSocket.SearchTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	var out resultCollector
+	executeSearch(itemCtx, &out, h.auth, h.slots, h.history, searchSourceBatch, searchReq, cfg, h.logger, attribute.Int("batch.index", index))
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	switch {
+	case out.result != nil:
+		res.Result = out.result
+	case out.err != nil:
+		res.Error = out.err
+	default:
+		res.Error = &WSError{Code: "SEARCH_CANCELED", Message: "Search was canceled"}
+	}
+	return res
+}
+
+// readBatchItems accepts a JSON array of items, JSONL (one item per line)
+// or a multipart upload with the JSONL in a "file" field. JSONL lines that
+// fail to decode become per-item errors.
+func readBatchItems(r *http.Request, cfg AppConfig) ([]parsedBatchItem, error) {
+	body := io.Reader(r.Body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("multipart upload must carry the batch in a \"file\" field: %w", err)
+		}
+		defer file.Close()
+		body = file
+	}
+
+	return parseBatchItems(body, int(cfg.WebSocket.MaxMessageSize), cfg.Batch.MaxItems)
+}
+
+// parseBatchItems reads a JSON array or JSONL from r. Reading stops once
+// more than maxItems lines were seen, since such a batch is refused anyway.
+func parseBatchItems(r io.Reader, maxLine, maxItems int) ([]parsedBatchItem, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' {
+		var items []BatchItem
+		if err := json.NewDecoder(br).Decode(&items); err != nil {
+			return nil, err
+		}
+		parsed := make([]parsedBatchItem, len(items))
+		for i, item := range items {
+			parsed[i] = parsedBatchItem{item: item}
+		}
+		return parsed, nil
+	}
+
+	var parsed []parsedBatchItem
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var p parsedBatchItem
+		p.err = json.Unmarshal(line, &p.item)
+		parsed = append(parsed, p)
+		if len(parsed) > maxItems {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReadBatchItems(t *testing.T) {
+	cfg := defaultConfig()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(` [{"id": "a", "prompt": "one"}, {"prompt": "two"}]`))
+	req.Header.Set("Content-Type", "application/json")
+	items, err := readBatchItems(req, cfg)
+	if err != nil || len(items) != 2 || items[0].item.ID != "a" || items[1].item.Prompt != "two" {
+		t.Fatalf("unexpected array items %+v, %v", items, err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader("{\"prompt\": \"one\"}\n\nnot json\n{\"prompt\": \"three\"}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	items, err = readBatchItems(req, cfg)
+	if err != nil || len(items) != 3 {
+		t.Fatalf("expected 3 JSONL items, got %+v, %v", items, err)
+	}
+	if items[1].err == nil || items[2].err != nil || items[2].item.Prompt != "three" {
+		t.Fatalf("expected only the malformed line to carry an error, got %+v", items)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "prompts.jsonl")
+	_, _ = fw.Write([]byte("{\"prompt\": \"uploaded\"}\n"))
+	_ = mw.Close()
+	req = httptest.NewRequest(http.MethodPost, "/api/batch", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	items, err = readBatchItems(req, cfg)
+	if err != nil || len(items) != 1 || items[0].item.Prompt != "uploaded" {
+		t.Fatalf("unexpected multipart items %+v, %v", items, err)
+	}
+}
+
+func TestBatchStreamsPerItemResults(t *testing.T) {
+	cfg := defaultConfig()
+	logger := NewLogger()
+	store := NewConfigStore("", cfg, logger)
+	handler := &BatchHandler{
+		store:   store,
+		auth:    NewAuthenticator(cfg.Auth),
+		limiter: NewRateLimiter(),
+		conns:   NewConnManager(),
+		slots:   NewSearchSlots(),
+		logger:  logger,
+	}
+	// Через LoggingMiddleware, чтобы проверить Flush сквозь обертку
+	ts := httptest.NewServer(LoggingMiddleware(logger)(handler))
+	defer ts.Close()
+
+	body := "{\"id\": \"ok\", \"prompt\": \"golang generics\"}\nnot json\n{\"id\": \"empty\", \"prompt\": \"\"}\n"
+	resp, err := http.Post(ts.URL, "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	var results []BatchResult
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var res BatchResult
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		results = append(results, res)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected a line per item, got %+v", results)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+
+	// Без ключа OpenRouter поиск падает на генерации запросов, но батч продолжается
+	want := []struct{ id, code string }{
+		{"ok", "QUERY_GENERATION_FAILED"},
+		{"", ErrInvalidRequest.Code},
+		{"empty", "VALIDATION_FAILED"},
+	}
+	for i, w := range want {
+		if results[i].ID != w.id || results[i].Error == nil || results[i].Error.Code != w.code {
+			t.Errorf("item %d: expected id %q with %s, got %+v", i, w.id, w.code, results[i])
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ChatCompletionRequest is the subset of the OpenAI chat completions request
+// the facade understands. Sampling parameters are accepted and ignored; the
+// optional Search field is an extension that tunes the pipeline.
+type ChatCompletionRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Search   *Settings     `json:"search,omitempty"`
+}
+
+type ChatMessage struct {
+	Role    string      `json:"role"`
+	Content chatContent `json:"content"`
+}
+
+// chatContent is a message body sent either as a string or as an array of
+// content parts; only text parts are kept.
+type chatContent string
+
+func (c *chatContent) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = chatContent(s)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or an array of content parts")
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" {
+			texts = append(texts, p.Text)
+		}
+	}
+	*c = chatContent(strings.Join(texts, "\n"))
+	return nil
+}
+
+// ChatCompletion is both the non-streaming response and, with Object set to
+// "chat.completion.chunk", one SSE chunk of a streaming one. Citations lists
+// the source URLs in the order the answer numbers them.
+type ChatCompletion struct {
+	ID        string       `json:"id"`
+	Object    string       `json:"object"`
+	Created   int64        `json:"created"`
+	Model     string       `json:"model"`
+	Choices   []ChatChoice `json:"choices"`
+	Usage     *ChatUsage   `json:"usage,omitempty"`
+	Citations []string     `json:"citations,omitempty"`
+}
+
+type ChatChoice struct {
+	Index        int                `json:"index"`
+	Message      *ChatResponseDelta `json:"message,omitempty"`
+	Delta        *ChatResponseDelta `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type ChatResponseDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// citationPattern matches the [n] markers the answer model is asked to use.
+var citationPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// ChatHandler serves an OpenAI-compatible chat API: the last user message
+// is searched and the answer is grounded in the ranked results.
+type ChatHandler struct {
+	store   *ConfigStore
+	auth    *Authenticator
+	limiter *RateLimiter
+	conns   *ConnManager
+	slots   *SearchSlots
+	history *SearchHistory
+	logger  *Logger
+}
+
+func (h *ChatHandler) Routes(r chi.Router) {
+	r.Get("/models", h.handleModels)
+	r.Post("/chat/completions", h.handleCompletions)
+}
+
+func (h *ChatHandler) handleModels(w http.ResponseWriter, r *http.Request) {
+	cfg := h.store.Load()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data": []map[string]any{{
+			"id":       cfg.Chat.ModelID,
+			"object":   "model",
+			"created":  0,
+			"owned_by": "ai-search-aggregator",
+		}},
+	})
+}
+
+func (h *ChatHandler) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	cfg := h.store.Load()
+	principal := principalFromContext(r.Context())
+
+	var req ChatCompletionRequest
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.WebSocket.MaxMessageSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		chatErrorResponse(w, WrapError(ErrInvalidRequest, err))
+		return
+	}
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "user" {
+		chatErrorResponse(w, NewAppError(ErrInvalidRequest.Code, ErrInvalidRequest.Message,
+			"the last message must have the user role, it is searched as the prompt", ErrInvalidRequest.Status))
+		return
+	}
+	searchReq := SearchRequest{Prompt: string(req.Messages[len(req.Messages)-1].Content)}
+	if req.Search != nil {
+		searchReq.Settings = *req.Search
+	}
+	SanitizeSearchRequest(&searchReq)
+	if searchReq.Settings.Queries == 0 {
+		searchReq.Settings.Queries = cfg.Search.DefaultQueryCount
+	}
+	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
+		chatErrorResponse(w, NewAppError("VALIDATION_FAILED", "Request validation failed", validationErrors.Error(), http.StatusBadRequest))
+		return
+	}
+
+	if ok, retryAfter := h.limiter.Allow(rateLimitKey(principal, r, cfg.RateLimit), cfg.RateLimit); !ok {
+		searchesTotal.WithLabelValues("rate_limited").Inc()
+		chatErrorResponse(w, rateLimitedError(fmt.Sprintf("search rate limit of %g per minute exceeded", cfg.RateLimit.SearchesPerMinute), retryAfter))
+		return
+	}
+	if !h.conns.BeginSearch() {
+		searchesTotal.WithLabelValues("shutting_down").Inc()
+		chatErrorResponse(w, ErrShuttingDown)
+		return
+	}
+	defer h.conns.EndSearch()
+	if appErr := h.auth.BeginSearch(principal); appErr != nil {
+		searchesTotal.WithLabelValues("quota_exceeded").Inc()
+		chatErrorResponse(w, appErr)
+		return
+	}
+
+	ctx := contextWithLocale(r.Context(), negotiateLocale(r.Header.Get("Accept-Language")))
+	out := &chatStream{w: w, rc: http.NewResponseController(w), enabled: req.Stream}
+	completion := ChatCompletion{
+		ID:      "chatcmpl-" + randomHex(12),
+		Created: time.Now().Unix(),
+		Model:   cfg.Chat.ModelID,
+	}
+	if req.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		completion.Object = "chat.completion.chunk"
+		out.chunk(completion, ChatResponseDelta{Role: "assistant"}, nil)
+	} else {
+		// Ответ пишется целиком после поиска и генерации, они могут идти
+		// дольше общего WriteTimeout сервера
+		_ = out.rc.SetWriteDeadline(time.Now().Add(cfg.WebSocket.SearchTimeout + cfg.Timeouts.Answer + wsWriteWait))
+	}
+
+	start := time.Now()
+	result, appErr := h.search(ctx, out, searchReq, cfg)
+	if appErr != nil {
+		h.logger.Warn("chat completion search failed", "code", appErr.Code, "api_key", principalName(principal))
+		if req.Stream {
+			out.event(chatErrorBody(appErr))
+			out.done()
+			return
+		}
+		chatErrorResponse(w, appErr)
+		return
+	}
+
+	sources := result.Results
+	if len(sources) > cfg.Chat.MaxSources {
+		sources = sources[:cfg.Chat.MaxSources]
+	}
+	for _, s := range sources {
+		completion.Citations = append(completion.Citations, s.URL)
+	}
+
+	usage := &tokenUsage{}
+	answerCtx := contextWithTokenUsage(ctx, usage)
+	defer func() { h.auth.AddTokens(principal, usage.total.Load()) }()
+	answer, answerUsage, err := h.answer(answerCtx, req.Messages, sources, cfg, func(delta string) {
+		out.chunk(completion, ChatResponseDelta{Content: delta}, nil)
+	})
+	if err != nil {
+		appErr := WrapError(ErrAnswerGeneration, err)
+		h.logger.Warn("chat completion answer failed", "error", err, "api_key", principalName(principal))
+		if req.Stream {
+			out.event(chatErrorBody(appErr))
+			out.done()
+			return
+		}
+		chatErrorResponse(w, appErr)
+		return
+	}
+	footer := citationFooter(answer, sources)
+	h.logger.Info("chat completion finished", "sources", len(sources), "stream", req.Stream,
+		"api_key", principalName(principal), "elapsed_ms", time.Since(start).Milliseconds())
+
+	stop := "stop"
+	if req.Stream {
+		if footer != "" {
+			out.chunk(completion, ChatResponseDelta{Content: footer}, nil)
+		}
+		out.chunk(completion, ChatResponseDelta{}, &stop)
+		out.done()
+		return
+	}
+	completion.Object = "chat.completion"
+	completion.Choices = []ChatChoice{{
+		Message:      &ChatResponseDelta{Role: "assistant", Content: answer + footer},
+		FinishReason: &stop,
+	}}
+	if answerUsage != nil {
+		completion.Usage = &ChatUsage{
+			PromptTokens:     answerUsage.PromptTokens,
+			CompletionTokens: answerUsage.CompletionTokens,
+			TotalTokens:      answerUsage.TotalTokens,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(completion)
+}
+
+// search runs the pipeline for the prompt. Client disconnects and the drain
+// timeout cancel it.
+func (h *ChatHandler) search(ctx context.Context, out *chatStream, searchReq SearchRequest, cfg AppConfig) (*WSSearchResult, *AppError) {
+	searchCtx, cancel := h.conns.SearchContext(ctx, cfg.WebSocket.SearchTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	executeSearch(searchCtx, out, h.auth, h.slots, h.history, searchSourceChat, searchReq, cfg, h.logger, attribute.Bool("chat.stream", out.enabled))
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	switch {
+	case out.result != nil:
+		return out.result, nil
+	case out.err != nil:
+		return nil, NewAppError(out.err.Code, out.err.Message, out.err.Details, http.StatusBadGateway)
+	}
+	return nil, NewAppError("SEARCH_CANCELED", "Search was canceled", "", http.StatusServiceUnavailable)
+}
+
+// answer writes the grounded answer. It is streamed from OpenRouter and every
+// piece is passed to onDelta as it arrives.
+func (h *ChatHandler) answer(ctx context.Context, messages []ChatMessage, sources []SearchResult, cfg AppConfig, onDelta func(string)) (string, *openRouterUsage, error) {
+	prompt := string(messages[len(messages)-1].Content)
+	if len(sources) == 0 {
+		text := fmt.Sprintf("No relevant web results were found for %q.", prompt)
+		onDelta(text)
+		return text, nil, nil
+	}
+	return generateAnswerWithOpenRouter(ctx, messages, sources, cfg, onDelta)
+}
+
+// citationFooter lists the sources the answer cites, or all of them when it
+// cites none, so clients that ignore the citations field still show links.
+func citationFooter(answer string, sources []SearchResult) string {
+	cited := make(map[int]bool)
+	for _, m := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 && n <= len(sources) {
+			cited[n] = true
+		}
+	}
+	var b strings.Builder
+	for i, s := range sources {
+		if len(cited) > 0 && !cited[i+1] {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("\n\nSources:\n")
+		}
+		fmt.Fprintf(&b, "[%d] %s - %s\n", i+1, oneLine(s.Title), s.URL)
+	}
+	return b.String()
+}
+
+// chatStream collects the search outcome and, for streaming requests, writes
+// SSE events. Pipeline status events become SSE comments that keep proxies
+// from closing the idle connection while the search runs.
+type chatStream struct {
+	resultCollector
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	enabled bool
+	writeMu sync.Mutex
+}
+
+func (s *chatStream) Send(msgType string, data interface{}) error {
+	status, ok := data.(WSSearchStatus)
+	if !ok {
+		return s.resultCollector.Send(msgType, data)
+	}
+	if s.enabled {
+		s.write(": " + oneLine(status.Message) + "\n\n")
+	}
+	return nil
+}
+
+func (s *chatStream) chunk(c ChatCompletion, delta ChatResponseDelta, finishReason *string) {
+	if !s.enabled {
+		return
+	}
+	c.Choices = []ChatChoice{{Delta: &delta, FinishReason: finishReason}}
+	s.event(c)
+}
+
+func (s *chatStream) event(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	s.write("data: " + string(data) + "\n\n")
+}
+
+func (s *chatStream) done() {
+	s.write("data: [DONE]\n\n")
+}
+
+func (s *chatStream) write(frame string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	// Поиск и ответ могут идти дольше общего WriteTimeout сервера
+	_ = s.rc.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	_, _ = io.WriteString(s.w, frame)
+	_ = s.rc.Flush()
+}
+
+// chatErrorBody renders an AppError in the OpenAI error shape.
+func chatErrorBody(appErr *AppError) map[string]any {
+	message := appErr.Message
+	if appErr.Details != "" {
+		message += ": " + appErr.Details
+	}
+	errType := "server_error"
+	switch appErr.Status {
+	case http.StatusBadRequest:
+		errType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusForbidden:
+		errType = "permission_error"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	}
+	return map[string]any{"error": map[string]any{"message": message, "type": errType, "code": appErr.Code}}
+}
+
+func chatErrorResponse(w http.ResponseWriter, appErr *AppError) {
+	w.Header().Set("Content-Type", "application/json")
+	if appErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(appErr.RetryAfter))
+	}
+	w.WriteHeader(appErr.Status)
+	_ = json.NewEncoder(w).Encode(chatErrorBody(appErr))
+}
+
+// openRouterStreamRequest asks OpenRouter for server-sent events instead of a
+// single response.
+type openRouterStreamRequest struct {
+	openRouterRequest
+	Stream bool `json:"stream"`
+}
+
+type openRouterStreamChunk struct {
+	Choices []struct {
+		Delta openMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *openRouterUsage `json:"usage,omitempty"`
+}
+
+// generateAnswerWithOpenRouter answers the conversation from the numbered
+// sources, citing them as [n]. Earlier turns are passed along so follow-up
+// questions keep their context.
+func generateAnswerWithOpenRouter(ctx context.Context, messages []ChatMessage, sources []SearchResult, cfg AppConfig, onDelta func(string)) (_ string, _ *openRouterUsage, err error) {
+	if cfg.OpenRouter.APIKey == "" {
+		return "", nil, errors.New("OPENROUTER_API_KEY not set")
+	}
+
+	var system strings.Builder
+	system.WriteString("You answer questions using only the numbered web search results below. " +
+		"Cite the sources of every claim with their numbers in square brackets, like [1] or [2][3]. " +
+		"If the results do not contain the answer, say so. Answer in the language of the question.\n\nSearch results:\n")
+	for i, s := range sources {
+		fmt.Fprintf(&system, "\n[%d] %s\nURL: %s\n", i+1, oneLine(s.Title), s.URL)
+		if s.PublishedDate != nil {
+			fmt.Fprintf(&system, "Published: %s\n", s.PublishedDate.Format(time.DateOnly))
+		}
+		text := s.Highlight
+		if text == "" {
+			text = s.Snippet
+		}
+		if s.Page != nil && s.Page.Excerpt != "" && !strings.Contains(text, s.Page.Excerpt) {
+			text = s.Page.Excerpt + "\n" + text
+		}
+		system.WriteString(truncateForLLM(text, cfg.Content.TruncationLength) + "\n")
+	}
+
+	reqBody := openRouterStreamRequest{
+		openRouterRequest: openRouterRequest{
+			Model:     cfg.OpenRouter.Model,
+			Messages:  []openMessage{{Role: "system", Content: system.String()}},
+			MaxTokens: cfg.OpenRouter.AnswerMaxTokens,
+			Usage:     includeUsage,
+		},
+		Stream: true,
+	}
+	for _, m := range messages {
+		switch m.Role {
+		case "user", "assistant":
+			reqBody.Messages = append(reqBody.Messages, openMessage{Role: m.Role, Content: string(m.Content)})
+		case "system", "developer":
+			// Инструкции клиента дополняют, но не заменяют требование ссылаться на источники
+			reqBody.Messages = append(reqBody.Messages, openMessage{Role: "system", Content: string(m.Content)})
+		}
+	}
+
+	payload, _ := json.Marshal(reqBody)
+	callCtx, call := startOpenRouterCall(ctx, "answer", reqBody.Model)
+	defer call.finish()
+	defer func() { call.err = err }()
+	httpReq, _ := http.NewRequestWithContext(callCtx, "POST", cfg.OpenRouter.Endpoint, bytes.NewReader(payload))
+	httpReq.Header.Set("Authorization", "Bearer "+cfg.OpenRouter.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Title", "AI Search Aggregator")
+
+	resp, err := newHTTPClient(cfg.Timeouts.Answer).Do(httpReq)
+	if err != nil {
+		logOpenRouterRequest(cfg, "answer", reqBody.openRouterRequest, nil, err, 0)
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	call.status = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("openrouter status %d: %s", resp.StatusCode, string(body))
+		logOpenRouterRequest(cfg, "answer", reqBody.openRouterRequest, nil, err, resp.StatusCode)
+		return "", nil, err
+	}
+
+	var answer strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		// Строки без data: это комментарии OpenRouter о ходе обработки
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk openRouterStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if chunk.Usage != nil {
+			call.usage = chunk.Usage
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			answer.WriteString(chunk.Choices[0].Delta.Content)
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", nil, err
+	}
+
+	orResp := &openRouterResponse{Usage: call.usage}
+	orResp.Choices = append(orResp.Choices, struct {
+		Message openMessage `json:"message"`
+	}{Message: openMessage{Role: "assistant", Content: answer.String()}})
+	logOpenRouterRequest(cfg, "answer", reqBody.openRouterRequest, orResp, nil, resp.StatusCode)
+	if answer.Len() == 0 {
+		err = errors.New("openrouter returned an empty answer")
+		return "", nil, err
+	}
+	return answer.String(), call.usage, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestChatServer serves the chat API over a fake SearxNG returning one
+// result and a fake OpenRouter that generates one query, keeps every result
+// and streams a short cited answer. history may be nil.
+func newTestChatServer(t *testing.T, history *SearchHistory) *httptest.Server {
+	t.Helper()
+	searx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(fakeSearxResp{Results: []searxResultItem{
+			{Title: "Go generics", URL: "https://go.dev/generics", Content: "Type parameters arrived in Go 1.18", Score: 1},
+		}})
+	}))
+	t.Cleanup(searx.Close)
+	openRouter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), `"stream":true`):
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, piece := range []string{"Go added generics ", "in 1.18 [1]."} {
+				data, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"delta": map[string]string{"content": piece}}}})
+				_, _ = w.Write([]byte(": OPENROUTER PROCESSING\n\ndata: " + string(data) + "\n\n"))
+			}
+			_, _ = w.Write([]byte(`data: {"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}` + "\n\ndata: [DONE]\n\n"))
+		case strings.Contains(string(body), "web-search queries"):
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"golang generics"}}],"usage":{"prompt_tokens":30,"completion_tokens":4,"total_tokens":34,"cost":0.00012}}`))
+		case strings.Contains(string(body), "JSON array"):
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"[1]"}}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"1"}}]}`))
+		}
+	}))
+	t.Cleanup(openRouter.Close)
+
+	cfg := defaultConfig()
+	cfg.Searx.URL = searx.URL
+	cfg.OpenRouter.APIKey = "test"
+	cfg.OpenRouter.Endpoint = openRouter.URL
+	cfg.Debug.LogRequests = false
+	logger := NewLogger()
+	h := &ChatHandler{
+		store:   NewConfigStore("", cfg, logger),
+		auth:    NewAuthenticator(AuthConfig{}),
+		limiter: NewRateLimiter(),
+		conns:   NewConnManager(),
+		slots:   NewSearchSlots(),
+		history: history,
+		logger:  logger,
+	}
+	r := chi.NewRouter()
+	r.Route("/v1", h.Routes)
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func postChat(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestChatCompletionGroundedAnswer(t *testing.T) {
+	ts := newTestChatServer(t, nil)
+	resp := postChat(t, ts.URL, `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"When did Go get generics?"}]}],"search":{"queries":1}}`)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+	var completion ChatCompletion
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		t.Fatal(err)
+	}
+	want := "Go added generics in 1.18 [1].\n\nSources:\n[1] Go generics - https://go.dev/generics\n"
+	if completion.Object != "chat.completion" || completion.Choices[0].Message.Content != want {
+		t.Fatalf("unexpected completion %+v", completion.Choices[0].Message)
+	}
+	if !reflect.DeepEqual(completion.Citations, []string{"https://go.dev/generics"}) {
+		t.Fatalf("unexpected citations %v", completion.Citations)
+	}
+	if completion.Usage == nil || completion.Usage.TotalTokens != 15 {
+		t.Fatalf("expected answer usage, got %+v", completion.Usage)
+	}
+}
+
+func TestChatCompletionStreams(t *testing.T) {
+	ts := newTestChatServer(t, nil)
+	resp := postChat(t, ts.URL, `{"messages":[{"role":"user","content":"When did Go get generics?"}],"stream":true}`)
+
+	var content strings.Builder
+	var chunks []ChatCompletion
+	var done bool
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk ChatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("bad chunk %s: %v", data, err)
+		}
+		chunks = append(chunks, chunk)
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if !done || len(chunks) < 3 {
+		t.Fatalf("expected chunks followed by [DONE], got %d chunks", len(chunks))
+	}
+	if chunks[0].Choices[0].Delta.Role != "assistant" || chunks[0].Object != "chat.completion.chunk" {
+		t.Fatalf("unexpected first chunk %+v", chunks[0])
+	}
+	if last := chunks[len(chunks)-1]; last.Choices[0].FinishReason == nil || *last.Choices[0].FinishReason != "stop" {
+		t.Fatalf("expected stop in the last chunk, got %+v", last.Choices[0])
+	}
+	if !strings.HasPrefix(content.String(), "Go added generics in 1.18 [1].\n\nSources:") {
+		t.Fatalf("unexpected streamed content %q", content.String())
+	}
+}
+
+func TestChatCompletionRejectsMissingUserMessage(t *testing.T) {
+	ts := newTestChatServer(t, nil)
+	resp := postChat(t, ts.URL, `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`)
+	var body struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusBadRequest || body.Error.Type != "invalid_request_error" {
+		t.Fatalf("expected OpenAI-style 400, got %d %+v", resp.StatusCode, body)
+	}
+}
+
+func TestCitationFooterListsCitedSources(t *testing.T) {
+	sources := []SearchResult{{Title: "A", URL: "https://a.com"}, {Title: "B", URL: "https://b.com"}}
+	if got := citationFooter("Only B says so [2].", sources); got != "\n\nSources:\n[2] B - https://b.com\n" {
+		t.Fatalf("unexpected footer %q", got)
+	}
+	if got := citationFooter("No citations.", sources); !strings.Contains(got, "[1] A") || !strings.Contains(got, "[2] B") {
+		t.Fatalf("expected every source without citations, got %q", got)
+	}
+}
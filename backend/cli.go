@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// Коды завершения CLI
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// Форматы вывода команды search
+const (
+	formatTable    = "table"
+	formatJSON     = "json"
+	formatMarkdown = "markdown"
+)
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, `Usage:
+  ai-search-aggregator [serve] [--config FILE] [--print-config]
+  ai-search-aggregator search "prompt" [--queries N] [--content] [--engines a,b] [--format table|json|markdown]
+  ai-search-aggregator batch FILE.jsonl|- [--concurrency N]
+  ai-search-aggregator mcp [--config FILE] [--verbose]
+
+search, batch and mcp run the pipeline in-process without a server. They read
+the same config file and environment variables as serve. mcp speaks the Model
+Context Protocol over stdin and stdout.
+`)
+}
+
+// cliFlags are shared by the search and batch commands.
+type cliFlags struct {
+	config  string
+	verbose bool
+	quiet   bool
+}
+
+func (c *cliFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.config, "config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	fs.BoolVar(&c.verbose, "verbose", false, "log pipeline details to stderr")
+	fs.BoolVar(&c.quiet, "quiet", false, "do not print progress to stderr")
+}
+
+// setup loads the configuration and a logger writing to stderr.
+func (c *cliFlags) setup(stderr io.Writer) (AppConfig, *Logger, error) {
+	cfg, err := loadConfig(c.config)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	level := slog.LevelWarn
+	if c.verbose {
+		level = slog.LevelInfo
+	}
+	return cfg, newLogger(stderr, level), nil
+}
+
+// parseInterspersed parses flags that may follow positional arguments, as in
+// `search "prompt" --queries 5`, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func runSearchCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var common cliFlags
+	common.register(fs)
+	queries := fs.Int("queries", 0, "number of generated queries (default from config)")
+	content := fs.Bool("content", false, "judge relevance by page content instead of snippets")
+	engines := fs.String("engines", "", "comma-separated SearxNG engines")
+	categories := fs.String("categories", "", "comma-separated SearxNG categories")
+	timeRange := fs.String("time-range", "", "day, week, month or year")
+	pages := fs.Int("pages", 1, "result pages to fetch per query")
+	locale := fs.String("locale", defaultLocale, "language of progress messages")
+	format := fs.String("format", formatTable, "output format: table, json or markdown")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "search takes exactly one prompt argument")
+		return exitUsage
+	}
+	if *format != formatTable && *format != formatJSON && *format != formatMarkdown {
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return exitUsage
+	}
+	cfg, logger, err := common.setup(stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	searchReq := SearchRequest{
+		Prompt: positional[0],
+		Settings: Settings{
+			Queries:     *queries,
+			ContentMode: *content,
+			Engines:     splitList(*engines),
+			Categories:  splitList(*categories),
+			TimeRange:   *timeRange,
+			Pages:       *pages,
+		},
+	}
+	SanitizeSearchRequest(&searchReq)
+	if searchReq.Settings.Queries == 0 {
+		searchReq.Settings.Queries = cfg.Search.DefaultQueryCount
+	}
+	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
+		fmt.Fprintln(stderr, "invalid search:", validationErrors.Error())
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, cfg.WebSocket.SearchTimeout)
+	defer cancel()
+	if l := supportedLocale(*locale); l != "" {
+		ctx = contextWithLocale(ctx, l)
+	}
+
+	out := &cliSink{progress: stderr, quiet: common.quiet}
+	executeSearch(ctx, out, NewAuthenticator(AuthConfig{}), NewSearchSlots(), nil, searchSourceCLI, searchReq, cfg, logger)
+	if out.err != nil {
+		fmt.Fprintf(stderr, "search failed: %s: %s\n", out.err.Code, out.err.Details)
+		return exitFailure
+	}
+	if out.result == nil {
+		fmt.Fprintln(stderr, "search was canceled")
+		return exitFailure
+	}
+	if err := writeSearchResult(stdout, *format, searchReq.Prompt, out.result); err != nil {
+		fmt.Fprintln(stderr, "failed to write results:", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runBatchCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var common cliFlags
+	common.register(fs)
+	concurrency := fs.Int("concurrency", 0, "items running at once (default batch.concurrency)")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "batch takes exactly one file argument, use - for stdin")
+		return exitUsage
+	}
+	cfg, logger, err := common.setup(stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	if *concurrency > 0 {
+		cfg.Batch.Concurrency = *concurrency
+	}
+
+	in := stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+		defer f.Close()
+		in = f
+	}
+	// Лимит batch.max_items защищает сервер, для локального файла он не нужен
+	items, err := parseBatchItems(in, int(cfg.WebSocket.MaxMessageSize), int(^uint(0)>>1))
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to read batch:", err)
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	h := &BatchHandler{
+		auth:   NewAuthenticator(AuthConfig{}),
+		conns:  NewConnManager(),
+		slots:  NewSearchSlots(),
+		logger: logger,
+	}
+	enc := json.NewEncoder(stdout)
+	done := 0
+	failed := h.run(ctx, items, cfg, func(res BatchResult) error {
+		done++
+		if !common.quiet {
+			status := "ok"
+			if res.Error != nil {
+				status = res.Error.Code
+			}
+			fmt.Fprintf(stderr, "[%d/%d] item %d: %s\n", done, len(items), res.Index, status)
+		}
+		return enc.Encode(res)
+	})
+	if failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// runMCPCommand serves MCP over stdio until stdin is closed. stdout carries
+// protocol messages only, so logs go to stderr.
+func runMCPCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mcp", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var common cliFlags
+	common.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "mcp takes no arguments")
+		return exitUsage
+	}
+	cfg, logger, err := common.setup(stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	server := NewMCPServer(NewConfigStore(common.config, cfg, logger), NewAuthenticator(AuthConfig{}),
+		NewRateLimiter(), NewConnManager(), NewSearchSlots(), nil, logger)
+	if err := server.ServeStdio(ctx, stdin, stdout); err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// cliSink keeps the outcome of a search and prints progress to stderr.
+type cliSink struct {
+	resultCollector
+	progress io.Writer
+	quiet    bool
+}
+
+func (s *cliSink) Send(msgType string, data interface{}) error {
+	if status, ok := data.(WSSearchStatus); ok {
+		if !s.quiet {
+			fmt.Fprintln(s.progress, status.Message)
+		}
+		return nil
+	}
+	return s.resultCollector.Send(msgType, data)
+}
+
+func writeSearchResult(w io.Writer, format, prompt string, res *WSSearchResult) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	case formatMarkdown:
+		return writeMarkdown(w, prompt, res)
+	case formatTable:
+		return writeTable(w, res)
+	}
+	return errors.New("unknown format " + format)
+}
+
+func writeTable(w io.Writer, res *WSSearchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tTITLE\tURL")
+	for i, r := range res.Results {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", i+1, truncateStr(oneLine(r.Title), 60), r.URL)
+	}
+	return tw.Flush()
+}
+
+func writeMarkdown(w io.Writer, prompt string, res *WSSearchResult) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", oneLine(prompt))
+	for _, answer := range res.Answers {
+		fmt.Fprintf(&b, "> %s\n\n", oneLine(answer.Answer))
+	}
+	for i, r := range res.Results {
+		fmt.Fprintf(&b, "%d. [%s](%s)\n", i+1, escapeMarkdownLink(oneLine(r.Title)), r.URL)
+		snippet := r.Highlight
+		if snippet == "" {
+			snippet = r.Snippet
+		}
+		if snippet = oneLine(snippet); snippet != "" {
+			fmt.Fprintf(&b, "   %s\n", snippet)
+		}
+	}
+	if len(res.Queries) > 0 {
+		fmt.Fprintf(&b, "\n_Queries: %s_\n", strings.Join(res.Queries, "; "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func escapeMarkdownLink(s string) string {
+	return strings.NewReplacer("[", "\\[", "]", "\\]").Replace(s)
+}
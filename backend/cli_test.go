@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseInterspersedFlags(t *testing.T) {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	queries := fs.Int("queries", 0, "")
+	content := fs.Bool("content", false, "")
+
+	positional, err := parseInterspersed(fs, []string{"golang generics", "--queries", "5", "--content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positional, []string{"golang generics"}) || *queries != 5 || !*content {
+		t.Fatalf("unexpected parse: %v queries=%d content=%v", positional, *queries, *content)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	res := &WSSearchResult{
+		Queries: []string{"q1", "q2"},
+		Results: []SearchResult{
+			{Title: "Go [generics]", URL: "https://go.dev/doc", Snippet: "Type\nparameters"},
+		},
+		SearchExtras: SearchExtras{Answers: []SearxAnswer{{Answer: "Go 1.18"}}},
+	}
+	var b bytes.Buffer
+	if err := writeMarkdown(&b, "golang generics", res); err != nil {
+		t.Fatal(err)
+	}
+	want := "# golang generics\n\n> Go 1.18\n\n1. [Go \\[generics\\]](https://go.dev/doc)\n   Type parameters\n\n_Queries: q1; q2_\n"
+	if b.String() != want {
+		t.Fatalf("unexpected markdown:\n%s", b.String())
+	}
+}
+
+func TestWriteTableTruncatesCyrillicTitles(t *testing.T) {
+	// По байтам заголовок режется посреди руны
+	title := "Дженерики в Go 1.18 — " + strings.Repeat("параметры типов ", 6)
+	res := &WSSearchResult{Results: []SearchResult{{Title: title, URL: "https://go.dev/doc"}}}
+	var b bytes.Buffer
+	if err := writeTable(&b, res); err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.Valid(b.Bytes()) {
+		t.Fatalf("table is not valid UTF-8:\n%q", b.String())
+	}
+	want := string([]rune(title)[:60]) + "…"
+	if !strings.Contains(b.String(), "1  "+want+"  https://go.dev/doc\n") {
+		t.Fatalf("unexpected table:\n%s", b.String())
+	}
+}
+
+func TestRunRejectsBadUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := run([]string{"frobnicate"}); code != exitUsage {
+		t.Fatalf("expected usage exit code for unknown command, got %d", code)
+	}
+	if code := runSearchCommand(nil, io.Discard, &stderr); code != exitUsage {
+		t.Fatalf("expected usage exit code without prompt, got %d", code)
+	}
+	if code := runSearchCommand([]string{"prompt", "--format", "xml"}, io.Discard, &stderr); code != exitUsage {
+		t.Fatalf("expected usage exit code for unknown format, got %d", code)
+	}
+}
+
+func TestBatchCommandReportsEveryItem(t *testing.T) {
+	// Без ключа OpenRouter каждый элемент завершается ошибкой генерации запросов
+	t.Setenv("OPENROUTER_API_KEY", "")
+	stdin := strings.NewReader("{\"id\": \"a\", \"prompt\": \"first\"}\nnot json\n")
+	var stdout, stderr bytes.Buffer
+	if code := runBatchCommand([]string{"-", "--quiet"}, stdin, &stdout, &stderr); code != exitFailure {
+		t.Fatalf("expected failure exit code, got %d (%s)", code, stderr.String())
+	}
+
+	byIndex := map[int]BatchResult{}
+	dec := json.NewDecoder(&stdout)
+	for dec.More() {
+		var res BatchResult
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		byIndex[res.Index] = res
+	}
+	if len(byIndex) != 2 {
+		t.Fatalf("expected a line per item, got %v", byIndex)
+	}
+	if res := byIndex[0]; res.ID != "a" || res.Error == nil || res.Error.Code != "QUERY_GENERATION_FAILED" {
+		t.Fatalf("unexpected first item %+v", res)
+	}
+	if res := byIndex[1]; res.Error == nil || res.Error.Code != ErrInvalidRequest.Code {
+		t.Fatalf("expected decode error for second item, got %+v", res)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("--quiet must suppress progress, got %q", stderr.String())
+	}
+}
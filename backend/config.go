@@ -1,193 +1,817 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// AppConfig holds runtime configuration derived from environment variables.
+// AppConfig holds runtime configuration. Values come from built-in defaults,
+// then the optional config file, then environment variables.
 type AppConfig struct {
-	Server            ServerConfig
-	OpenRouter        OpenRouterConfig
-	Searx             SearxConfig
-	WebSocket         WebSocketConfig
-	Search            SearchConfig
-	Content           ContentConfig
-	Validation        ValidationConfig
-	Timeouts          TimeoutConfig
-	Limits            LimitsConfig
-	Debug             DebugConfig
+	Server     ServerConfig     `yaml:"server" toml:"server"`
+	OpenRouter OpenRouterConfig `yaml:"openrouter" toml:"openrouter"`
+	Searx      SearxConfig      `yaml:"searx" toml:"searx"`
+	WebSocket  WebSocketConfig  `yaml:"websocket" toml:"websocket"`
+	Search     SearchConfig     `yaml:"search" toml:"search"`
+	Content    ContentConfig    `yaml:"content" toml:"content"`
+	Validation ValidationConfig `yaml:"validation" toml:"validation"`
+	Timeouts   TimeoutConfig    `yaml:"timeouts" toml:"timeouts"`
+	Limits     LimitsConfig     `yaml:"limits" toml:"limits"`
+	Debug      DebugConfig      `yaml:"debug" toml:"debug"`
+	Metrics    MetricsConfig    `yaml:"metrics" toml:"metrics"`
+	Tracing    TracingConfig    `yaml:"tracing" toml:"tracing"`
+	Auth       AuthConfig       `yaml:"auth" toml:"auth"`
+	RateLimit  RateLimitConfig  `yaml:"rate_limit" toml:"rate_limit"`
+	Jobs       JobsConfig       `yaml:"jobs" toml:"jobs"`
+	Batch      BatchConfig      `yaml:"batch" toml:"batch"`
+	Chat       ChatConfig       `yaml:"chat" toml:"chat"`
+	Suggest    SuggestConfig    `yaml:"suggest" toml:"suggest"`
+	History    HistoryConfig    `yaml:"history" toml:"history"`
+	Saved      SavedConfig      `yaml:"saved_searches" toml:"saved_searches"`
+	Outbound   OutboundConfig   `yaml:"outbound" toml:"outbound"`
 }
 
 type ServerConfig struct {
-	Port string
+	Port string `yaml:"port" toml:"port"`
+	// ConfigWatchInterval controls how often the config file is checked for
+	// changes; zero disables watching (SIGHUP still reloads).
+	ConfigWatchInterval time.Duration `yaml:"config_watch_interval" toml:"config_watch_interval"`
+	// DrainTimeout is how long running searches may continue after SIGTERM
+	DrainTimeout time.Duration `yaml:"drain_timeout" toml:"drain_timeout"`
+	// PublicURL is the address browsers use to reach the site, e.g.
+	// "https://search.example.com"; when empty it is derived from requests
+	PublicURL string `yaml:"public_url" toml:"public_url"`
 }
 
 type OpenRouterConfig struct {
-	APIKey            string
-	Model             string
-	QueryGenMaxTokens int
-	FilterMaxTokens   int
-	ContentMaxTokens  int
-	Endpoint          string
+	APIKey            string `yaml:"api_key" toml:"api_key"`
+	Model             string `yaml:"model" toml:"model"`
+	QueryGenMaxTokens int    `yaml:"query_max_tokens" toml:"query_max_tokens"`
+	FilterMaxTokens   int    `yaml:"filter_max_tokens" toml:"filter_max_tokens"`
+	ContentMaxTokens  int    `yaml:"content_max_tokens" toml:"content_max_tokens"`
+	AnswerMaxTokens   int    `yaml:"answer_max_tokens" toml:"answer_max_tokens"`
+	Endpoint          string `yaml:"endpoint" toml:"endpoint"`
 }
 
 type SearxConfig struct {
-	URL      string
-	Language string
-	Locale   string
+	URL      string `yaml:"url" toml:"url"`
+	Language string `yaml:"language" toml:"language"`
+	Locale   string `yaml:"locale" toml:"locale"`
+	// Instances overrides URL when several SearxNG instances are configured
+	Instances      []SearxInstance `yaml:"instances" toml:"instances"`
+	Strategy       string          `yaml:"strategy" toml:"strategy"`
+	HealthInterval time.Duration   `yaml:"health_interval" toml:"health_interval"`
+	MaxFailures    int             `yaml:"max_failures" toml:"max_failures"`
+	EjectDuration  time.Duration   `yaml:"eject_duration" toml:"eject_duration"`
+	MaxAttempts    int             `yaml:"max_attempts" toml:"max_attempts"`
 }
 
 type WebSocketConfig struct {
-	MaxConnections     int
-	MessageTimeout     time.Duration
-	SearchTimeout      time.Duration
-	MaxMessageSize     int64
-	AllowedOrigins     []string
-	EnableOriginCheck  bool
+	MaxConnections int `yaml:"max_connections" toml:"max_connections"`
+	// MessageTimeout drops a peer that sent neither a message nor a pong for
+	// this long; pings go out every PingInterval
+	MessageTimeout time.Duration `yaml:"message_timeout" toml:"message_timeout"`
+	PingInterval   time.Duration `yaml:"ping_interval" toml:"ping_interval"`
+	SearchTimeout  time.Duration `yaml:"search_timeout" toml:"search_timeout"`
+	MaxMessageSize int64         `yaml:"max_message_size" toml:"max_message_size"`
+	// ResumeRetention keeps the events of a finished search this long so a
+	// client that lost its connection can still replay them
+	ResumeRetention time.Duration `yaml:"resume_retention" toml:"resume_retention"`
+	// ResumeBufferSize caps the bytes of events kept per search for replay;
+	// the oldest are dropped first
+	ResumeBufferSize int64 `yaml:"resume_buffer_size" toml:"resume_buffer_size"`
+	// AllowedOrigins applies to WebSocket upgrades and CORS alike, see
+	// parseOriginPattern for the accepted forms
+	AllowedOrigins    []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	EnableOriginCheck bool     `yaml:"enable_origin_check" toml:"enable_origin_check"`
 }
 
 type SearchConfig struct {
-	DefaultQueryCount     int
-	ContentModeDefault    bool
-	MaxConcurrentQueries  int
-	MaxConcurrentContent  int
-	MaxConcurrentFilter   int
-	MaxResultsToEvaluate  int
-	MaxResultsToProcess   int
+	DefaultQueryCount    int  `yaml:"default_query_count" toml:"default_query_count"`
+	ContentModeDefault   bool `yaml:"content_mode_default" toml:"content_mode_default"`
+	MaxConcurrentQueries int  `yaml:"max_concurrent_queries" toml:"max_concurrent_queries"`
+	MaxConcurrentContent int  `yaml:"max_concurrent_content" toml:"max_concurrent_content"`
+	MaxConcurrentFilter  int  `yaml:"max_concurrent_filter" toml:"max_concurrent_filter"`
+	MaxResultsToEvaluate int  `yaml:"max_results_to_evaluate" toml:"max_results_to_evaluate"`
+	MaxResultsToProcess  int  `yaml:"max_results_to_process" toml:"max_results_to_process"`
+	// MaxConcurrentSearches is shared by WebSocket searches, jobs and
+	// batches; further searches wait in a queue
+	MaxConcurrentSearches int `yaml:"max_concurrent_searches" toml:"max_concurrent_searches"`
 }
 
 type ContentConfig struct {
-	MaxContentLength int
-	TruncationLength int
+	MaxContentLength int `yaml:"max_length" toml:"max_length"`
+	// TruncationLength caps the excerpt sent to the relevance judge, in
+	// characters; passages that do not fit are left out whole
+	TruncationLength int `yaml:"truncation_length" toml:"truncation_length"`
+	PassageWords     int `yaml:"passage_words" toml:"passage_words"`
+	MaxPassages      int `yaml:"max_passages" toml:"max_passages"`
 }
 
 type ValidationConfig struct {
-	MaxPromptLength   int
-	MaxQueryCount     int
-	MaxEngineCount    int
-	SupportedEngines  []string
+	MaxPromptLength     int      `yaml:"max_prompt_length" toml:"max_prompt_length"`
+	MaxQueryCount       int      `yaml:"max_query_count" toml:"max_query_count"`
+	MaxEngineCount      int      `yaml:"max_engine_count" toml:"max_engine_count"`
+	SupportedEngines    []string `yaml:"supported_engines" toml:"supported_engines"`
+	MaxCategoryCount    int      `yaml:"max_category_count" toml:"max_category_count"`
+	SupportedCategories []string `yaml:"supported_categories" toml:"supported_categories"`
+	MaxPages            int      `yaml:"max_pages" toml:"max_pages"`
 }
 
 type TimeoutConfig struct {
-	HTTPClient       time.Duration
-	SearxRequest     time.Duration
-	ContentFetch     time.Duration
-	OpenRouterAPI    time.Duration
-	QueryGeneration  time.Duration
-	AIRelevance      time.Duration
-	ContentRelevance time.Duration
+	HTTPClient       time.Duration `yaml:"http_client" toml:"http_client"`
+	SearxRequest     time.Duration `yaml:"searx_request" toml:"searx_request"`
+	ContentFetch     time.Duration `yaml:"content_fetch" toml:"content_fetch"`
+	OpenRouterAPI    time.Duration `yaml:"openrouter_api" toml:"openrouter_api"`
+	QueryGeneration  time.Duration `yaml:"query_generation" toml:"query_generation"`
+	AIRelevance      time.Duration `yaml:"ai_relevance" toml:"ai_relevance"`
+	ContentRelevance time.Duration `yaml:"content_relevance" toml:"content_relevance"`
+	Answer           time.Duration `yaml:"answer" toml:"answer"`
 }
 
 type LimitsConfig struct {
-	MaxSearchResults  int
-	MaxItemsToFilter  int
-	MaxContentItems   int
+	MaxSearchResults int `yaml:"max_search_results" toml:"max_search_results"`
+	MaxItemsToFilter int `yaml:"max_items_to_filter" toml:"max_items_to_filter"`
+	MaxContentItems  int `yaml:"max_content_items" toml:"max_content_items"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Path    string `yaml:"path" toml:"path"`
+}
+
+type TracingConfig struct {
+	// Exporter is "none" (default, spans are not exported) or "otlp"
+	Exporter    string  `yaml:"exporter" toml:"exporter"`
+	Endpoint    string  `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	Insecure    bool    `yaml:"otlp_insecure" toml:"otlp_insecure"`
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// KeysFile is an optional YAML or TOML file with a top-level "keys" list;
+	// its entries are added to Keys and re-read on every config reload
+	KeysFile string         `yaml:"keys_file" toml:"keys_file"`
+	Keys     []APIKeyConfig `yaml:"keys" toml:"keys"`
+}
+
+// APIKeyConfig describes one client key. Zero daily limits mean unlimited.
+type APIKeyConfig struct {
+	Name          string   `yaml:"name" toml:"name"`
+	Key           string   `yaml:"key" toml:"key"`
+	Scopes        []string `yaml:"scopes" toml:"scopes"`
+	DailySearches int      `yaml:"daily_searches" toml:"daily_searches"`
+	DailyTokens   int64    `yaml:"daily_tokens" toml:"daily_tokens"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// SearchesPerMinute and Burst size the per-client token bucket; clients
+	// are identified by API key name or, without auth, by IP
+	SearchesPerMinute          float64 `yaml:"searches_per_minute" toml:"searches_per_minute"`
+	Burst                      int     `yaml:"burst" toml:"burst"`
+	MaxConcurrentPerConnection int     `yaml:"max_concurrent_per_connection" toml:"max_concurrent_per_connection"`
+	// TrustedProxies lists CIDRs or addresses whose X-Forwarded-For is honoured
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+type JobsConfig struct {
+	// Retention keeps finished jobs available for polling this long
+	Retention time.Duration `yaml:"retention" toml:"retention"`
+	// MaxStored caps running plus retained jobs; new jobs are refused beyond it
+	MaxStored int `yaml:"max_stored" toml:"max_stored"`
+	// CallbackSecret signs callback bodies with HMAC-SHA256; jobs with a
+	// callback URL are refused while it is empty
+	CallbackSecret   string        `yaml:"callback_secret" toml:"callback_secret"`
+	CallbackTimeout  time.Duration `yaml:"callback_timeout" toml:"callback_timeout"`
+	CallbackAttempts int           `yaml:"callback_attempts" toml:"callback_attempts"`
+}
+
+// OutboundConfig governs requests to URLs the server did not choose: job
+// callbacks, webhooks and fetched pages.
+type OutboundConfig struct {
+	// AllowPrivateNetworks permits loopback, private and link-local
+	// destinations; enable it only when every API key is trusted
+	AllowPrivateNetworks bool `yaml:"allow_private_networks" toml:"allow_private_networks"`
+}
+
+type BatchConfig struct {
+	MaxItems int `yaml:"max_items" toml:"max_items"`
+	// Concurrency bounds how many items of one batch run at once; all of
+	// them still share search.max_concurrent_searches with other traffic
+	Concurrency int   `yaml:"concurrency" toml:"concurrency"`
+	MaxBodySize int64 `yaml:"max_body_size" toml:"max_body_size"`
+}
+
+type ChatConfig struct {
+	// ModelID is the model name /v1/models lists and completions report
+	ModelID string `yaml:"model_id" toml:"model_id"`
+	// MaxSources caps how many ranked results the answer is grounded in
+	MaxSources int `yaml:"max_sources" toml:"max_sources"`
+}
+
+type SuggestConfig struct {
+	// Provider is "searx" (SearxNG autocompleter), "llm" (generated queries,
+	// only for callers with a search key when auth is enabled) or "none"
+	Provider string `yaml:"provider" toml:"provider"`
+	// SearxBackend is the SearxNG autocomplete backend, e.g. "duckduckgo"
+	SearxBackend   string `yaml:"searx_backend" toml:"searx_backend"`
+	MaxSuggestions int    `yaml:"max_suggestions" toml:"max_suggestions"`
+	// RequestsPerMinute and Burst throttle each client IP; browsers call the
+	// endpoint without an API key
+	RequestsPerMinute float64       `yaml:"requests_per_minute" toml:"requests_per_minute"`
+	Burst             int           `yaml:"burst" toml:"burst"`
+	Timeout           time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+type HistoryConfig struct {
+	// Enabled records every search in an embedded database at Path; changing
+	// either needs a restart
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Path    string `yaml:"path" toml:"path"`
+	// Retention deletes entries older than this; zero keeps them forever
+	Retention time.Duration `yaml:"retention" toml:"retention"`
+	// MaxEntries caps the entries kept per API key, the oldest are deleted
+	// first; zero means no cap
+	MaxEntries int `yaml:"max_entries" toml:"max_entries"`
+}
+
+type SavedConfig struct {
+	// Enabled runs saved searches from an embedded database at Path;
+	// changing either needs a restart
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Path    string `yaml:"path" toml:"path"`
+	// MinInterval is the shortest schedule a saved search may use
+	MinInterval time.Duration `yaml:"min_interval" toml:"min_interval"`
+	MaxPerKey   int           `yaml:"max_per_key" toml:"max_per_key"`
+	// FeedItems caps the changes kept for each saved search's feed
+	FeedItems int `yaml:"feed_items" toml:"feed_items"`
 }
 
 type DebugConfig struct {
-	Enabled     bool
-	LogRequests bool
-	LogFile     string
+	Enabled     bool   `yaml:"enabled" toml:"enabled"`
+	LogRequests bool   `yaml:"log_requests" toml:"log_requests"`
+	LogFile     string `yaml:"log_file" toml:"log_file"`
 }
 
-func loadConfig() AppConfig {
-	cfg := AppConfig{
+// defaultConfig returns the built-in defaults.
+func defaultConfig() AppConfig {
+	return AppConfig{
 		Server: ServerConfig{
-			Port: getenv("PORT", "8080"),
+			Port:                "8080",
+			ConfigWatchInterval: 5 * time.Second,
+			DrainTimeout:        30 * time.Second,
 		},
 		OpenRouter: OpenRouterConfig{
-			APIKey:            os.Getenv("OPENROUTER_API_KEY"),
-			Model:             getenv("OPENROUTER_MODEL", "openai/gpt-4o-mini"),
-			QueryGenMaxTokens: atoi(getenv("OPENROUTER_QUERY_MAX_TOKENS", "256"), 256),
-			FilterMaxTokens:   atoi(getenv("OPENROUTER_FILTER_MAX_TOKENS", "64"), 64),
-			ContentMaxTokens:  atoi(getenv("OPENROUTER_CONTENT_MAX_TOKENS", "4"), 4),
-			Endpoint:          getenv("OPENROUTER_ENDPOINT", "https://openrouter.ai/api/v1/chat/completions"),
+			Model:             "openai/gpt-4o-mini",
+			QueryGenMaxTokens: 256,
+			FilterMaxTokens:   64,
+			ContentMaxTokens:  4,
+			AnswerMaxTokens:   1024,
+			Endpoint:          "https://openrouter.ai/api/v1/chat/completions",
 		},
 		Searx: SearxConfig{
-			URL:      getenv("SEARX_URL", "http://searx:8080"),
-			Language: getenv("SEARX_LANGUAGE", "en"),
-			Locale:   getenv("SEARX_LOCALE", "en-US"),
+			URL:            "http://searx:8080",
+			Language:       "en",
+			Locale:         "en-US",
+			Strategy:       searxStrategyRoundRobin,
+			HealthInterval: 30 * time.Second,
+			MaxFailures:    3,
+			EjectDuration:  60 * time.Second,
+			MaxAttempts:    2,
 		},
 		WebSocket: WebSocketConfig{
-			MaxConnections:    atoi(getenv("WEBSOCKET_MAX_CONNECTIONS", "100"), 100),
-			MessageTimeout:    parseDuration(getenv("WEBSOCKET_MESSAGE_TIMEOUT", "30s"), 30*time.Second),
-			SearchTimeout:     parseDuration(getenv("WEBSOCKET_SEARCH_TIMEOUT", "10m"), 10*time.Minute),
-			MaxMessageSize:    int64(atoi(getenv("WEBSOCKET_MAX_MESSAGE_SIZE", "65536"), 65536)), // 64KB
-			AllowedOrigins:    parseStringSlice(getenv("WEBSOCKET_ALLOWED_ORIGINS", "")),
-			EnableOriginCheck: getenv("WEBSOCKET_ENABLE_ORIGIN_CHECK", "false") == "true",
+			MaxConnections:   100,
+			MessageTimeout:   30 * time.Second,
+			PingInterval:     15 * time.Second,
+			SearchTimeout:    10 * time.Minute,
+			MaxMessageSize:   65536, // 64KB
+			ResumeRetention:  5 * time.Minute,
+			ResumeBufferSize: 4 << 20, // 4MB
 		},
 		Search: SearchConfig{
-			DefaultQueryCount:     atoi(getenv("SEARCH_DEFAULT_QUERY_COUNT", "5"), 5),
-			ContentModeDefault:    getenv("SEARCH_CONTENT_MODE_DEFAULT", "false") == "true",
-			MaxConcurrentQueries:  atoi(getenv("SEARCH_MAX_CONCURRENT_QUERIES", "5"), 5),
-			MaxConcurrentContent:  atoi(getenv("SEARCH_MAX_CONCURRENT_CONTENT", "3"), 3),
-			MaxConcurrentFilter:   atoi(getenv("SEARCH_MAX_CONCURRENT_FILTER", "3"), 3),
-			MaxResultsToEvaluate:  atoi(getenv("SEARCH_MAX_RESULTS_TO_EVALUATE", "50"), 50),
-			MaxResultsToProcess:   atoi(getenv("SEARCH_MAX_RESULTS_TO_PROCESS", "100"), 100),
+			DefaultQueryCount:     5,
+			MaxConcurrentQueries:  5,
+			MaxConcurrentContent:  3,
+			MaxConcurrentFilter:   3,
+			MaxResultsToEvaluate:  50,
+			MaxResultsToProcess:   100,
+			MaxConcurrentSearches: 20,
 		},
 		Content: ContentConfig{
-			MaxContentLength: atoi(getenv("CONTENT_MAX_LENGTH", "10000"), 10000),
-			TruncationLength: atoi(getenv("CONTENT_TRUNCATION_LENGTH", "3500"), 3500),
+			MaxContentLength: 10000,
+			TruncationLength: 3500,
+			PassageWords:     120,
+			MaxPassages:      4,
 		},
 		Validation: ValidationConfig{
-			MaxPromptLength: atoi(getenv("VALIDATION_MAX_PROMPT_LENGTH", "1000"), 1000),
-			MaxQueryCount:   atoi(getenv("VALIDATION_MAX_QUERY_COUNT", "20"), 20),
-			MaxEngineCount:  atoi(getenv("VALIDATION_MAX_ENGINE_COUNT", "10"), 10),
-			SupportedEngines: parseStringSlice(getenv("VALIDATION_SUPPORTED_ENGINES", 
-				"google,bing,duckduckgo,brave,qwant,yandex,wikipedia,github,stackoverflow,reddit,youtube")),
+			MaxPromptLength: 1000,
+			MaxQueryCount:   20,
+			MaxEngineCount:  10,
+			SupportedEngines: []string{"google", "bing", "duckduckgo", "brave", "qwant", "yandex",
+				"wikipedia", "github", "stackoverflow", "reddit", "youtube"},
+			MaxCategoryCount: 5,
+			SupportedCategories: []string{"general", "news", "science", "it", "images", "videos",
+				"music", "files", "map", "social media"},
+			MaxPages: 5,
 		},
 		Timeouts: TimeoutConfig{
-			HTTPClient:       parseDuration(getenv("TIMEOUT_HTTP_CLIENT", "30s"), 30*time.Second),
-			SearxRequest:     parseDuration(getenv("TIMEOUT_SEARX_REQUEST", "20s"), 20*time.Second),
-			ContentFetch:     parseDuration(getenv("TIMEOUT_CONTENT_FETCH", "20s"), 20*time.Second),
-			OpenRouterAPI:    parseDuration(getenv("TIMEOUT_OPENROUTER_API", "60s"), 60*time.Second),
-			QueryGeneration:  parseDuration(getenv("TIMEOUT_QUERY_GENERATION", "60s"), 60*time.Second),
-			AIRelevance:      parseDuration(getenv("TIMEOUT_AI_RELEVANCE", "30s"), 30*time.Second),
-			ContentRelevance: parseDuration(getenv("TIMEOUT_CONTENT_RELEVANCE", "30s"), 30*time.Second),
+			HTTPClient:       30 * time.Second,
+			SearxRequest:     20 * time.Second,
+			ContentFetch:     20 * time.Second,
+			OpenRouterAPI:    60 * time.Second,
+			QueryGeneration:  60 * time.Second,
+			AIRelevance:      30 * time.Second,
+			ContentRelevance: 30 * time.Second,
+			Answer:           90 * time.Second,
 		},
 		Limits: LimitsConfig{
-			MaxSearchResults: atoi(getenv("LIMITS_MAX_SEARCH_RESULTS", "100"), 100),
-			MaxItemsToFilter: atoi(getenv("LIMITS_MAX_ITEMS_TO_FILTER", "30"), 30),
-			MaxContentItems:  atoi(getenv("LIMITS_MAX_CONTENT_ITEMS", "20"), 20),
+			MaxSearchResults: 100,
+			MaxItemsToFilter: 30,
+			MaxContentItems:  20,
 		},
 		Debug: DebugConfig{
-			Enabled:     getenv("DEBUG", "false") == "true",
-			LogRequests: getenv("DEBUG_LOG_REQUESTS", "true") == "true",
-			LogFile:     getenv("DEBUG_LOG_FILE", "/tmp"),
+			LogRequests: true,
+			LogFile:     "/tmp",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "ai-search-aggregator",
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:                    true,
+			SearchesPerMinute:          10,
+			Burst:                      5,
+			MaxConcurrentPerConnection: 3,
+			// Только loopback; адрес nginx из docker-compose задается в RATE_LIMIT_TRUSTED_PROXIES
+			TrustedProxies: []string{"127.0.0.0/8", "::1/128"},
+		},
+		Jobs: JobsConfig{
+			Retention:        time.Hour,
+			MaxStored:        1000,
+			CallbackTimeout:  10 * time.Second,
+			CallbackAttempts: 3,
+		},
+		Batch: BatchConfig{
+			MaxItems:    500,
+			Concurrency: 4,
+			MaxBodySize: 4 << 20, // 4MB
+		},
+		Chat: ChatConfig{
+			ModelID:    "ai-search-aggregator",
+			MaxSources: 8,
+		},
+		Suggest: SuggestConfig{
+			Provider:          suggestProviderSearx,
+			SearxBackend:      "duckduckgo",
+			MaxSuggestions:    8,
+			RequestsPerMinute: 120,
+			Burst:             20,
+			Timeout:           3 * time.Second,
+		},
+		History: HistoryConfig{
+			Path:       "data/history.db",
+			Retention:  30 * 24 * time.Hour,
+			MaxEntries: 1000,
+		},
+		Saved: SavedConfig{
+			Path:        "data/saved_searches.db",
+			MinInterval: 15 * time.Minute,
+			MaxPerKey:   20,
+			FeedItems:   100,
 		},
 	}
-	
-	// Validate configuration
+}
+
+// loadConfig builds the configuration from defaults, the optional config
+// file at path and the environment. All invalid values are reported together
+// in the returned error.
+func loadConfig(path string) (AppConfig, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		if err := loadConfigFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	var errs []error
+	errs = append(errs, applyEnv(&cfg)...)
+
+	if cfg.Auth.KeysFile != "" {
+		keys, err := loadKeysFile(cfg.Auth.KeysFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("auth.keys_file: %w", err))
+		}
+		cfg.Auth.Keys = append(cfg.Auth.Keys, keys...)
+	}
+
 	if cfg.WebSocket.EnableOriginCheck && len(cfg.WebSocket.AllowedOrigins) == 0 {
 		cfg.WebSocket.AllowedOrigins = []string{"localhost", "127.0.0.1"}
 	}
-	
-	return cfg
+
+	errs = append(errs, validateConfig(cfg)...)
+	return cfg, errors.Join(errs...)
+}
+
+// applyEnv overrides cfg with every environment variable that is set.
+func applyEnv(cfg *AppConfig) []error {
+	e := &envReader{}
+
+	e.str("PORT", &cfg.Server.Port)
+	e.duration("CONFIG_WATCH_INTERVAL", &cfg.Server.ConfigWatchInterval)
+	e.duration("DRAIN_TIMEOUT", &cfg.Server.DrainTimeout)
+	e.str("PUBLIC_URL", &cfg.Server.PublicURL)
+
+	e.str("OPENROUTER_API_KEY", &cfg.OpenRouter.APIKey)
+	e.str("OPENROUTER_MODEL", &cfg.OpenRouter.Model)
+	e.int("OPENROUTER_QUERY_MAX_TOKENS", &cfg.OpenRouter.QueryGenMaxTokens)
+	e.int("OPENROUTER_FILTER_MAX_TOKENS", &cfg.OpenRouter.FilterMaxTokens)
+	e.int("OPENROUTER_CONTENT_MAX_TOKENS", &cfg.OpenRouter.ContentMaxTokens)
+	e.int("OPENROUTER_ANSWER_MAX_TOKENS", &cfg.OpenRouter.AnswerMaxTokens)
+	e.str("OPENROUTER_ENDPOINT", &cfg.OpenRouter.Endpoint)
+
+	e.str("SEARX_URL", &cfg.Searx.URL)
+	e.str("SEARX_LANGUAGE", &cfg.Searx.Language)
+	e.str("SEARX_LOCALE", &cfg.Searx.Locale)
+	e.searxInstances("SEARX_URLS", "SEARX_WEIGHTS", &cfg.Searx.Instances)
+	e.str("SEARX_STRATEGY", &cfg.Searx.Strategy)
+	e.duration("SEARX_HEALTH_INTERVAL", &cfg.Searx.HealthInterval)
+	e.int("SEARX_MAX_FAILURES", &cfg.Searx.MaxFailures)
+	e.duration("SEARX_EJECT_DURATION", &cfg.Searx.EjectDuration)
+	e.int("SEARX_MAX_ATTEMPTS", &cfg.Searx.MaxAttempts)
+
+	e.int("WEBSOCKET_MAX_CONNECTIONS", &cfg.WebSocket.MaxConnections)
+	e.duration("WEBSOCKET_MESSAGE_TIMEOUT", &cfg.WebSocket.MessageTimeout)
+	e.duration("WEBSOCKET_PING_INTERVAL", &cfg.WebSocket.PingInterval)
+	e.duration("WEBSOCKET_SEARCH_TIMEOUT", &cfg.WebSocket.SearchTimeout)
+	e.int64("WEBSOCKET_MAX_MESSAGE_SIZE", &cfg.WebSocket.MaxMessageSize)
+	e.duration("WEBSOCKET_RESUME_RETENTION", &cfg.WebSocket.ResumeRetention)
+	e.int64("WEBSOCKET_RESUME_BUFFER_SIZE", &cfg.WebSocket.ResumeBufferSize)
+	e.list("WEBSOCKET_ALLOWED_ORIGINS", &cfg.WebSocket.AllowedOrigins)
+	e.bool("WEBSOCKET_ENABLE_ORIGIN_CHECK", &cfg.WebSocket.EnableOriginCheck)
+
+	e.int("SEARCH_DEFAULT_QUERY_COUNT", &cfg.Search.DefaultQueryCount)
+	e.bool("SEARCH_CONTENT_MODE_DEFAULT", &cfg.Search.ContentModeDefault)
+	e.int("SEARCH_MAX_CONCURRENT_QUERIES", &cfg.Search.MaxConcurrentQueries)
+	e.int("SEARCH_MAX_CONCURRENT_CONTENT", &cfg.Search.MaxConcurrentContent)
+	e.int("SEARCH_MAX_CONCURRENT_FILTER", &cfg.Search.MaxConcurrentFilter)
+	e.int("SEARCH_MAX_RESULTS_TO_EVALUATE", &cfg.Search.MaxResultsToEvaluate)
+	e.int("SEARCH_MAX_RESULTS_TO_PROCESS", &cfg.Search.MaxResultsToProcess)
+	e.int("SEARCH_MAX_CONCURRENT_SEARCHES", &cfg.Search.MaxConcurrentSearches)
+
+	e.int("CONTENT_MAX_LENGTH", &cfg.Content.MaxContentLength)
+	e.int("CONTENT_TRUNCATION_LENGTH", &cfg.Content.TruncationLength)
+	e.int("CONTENT_PASSAGE_WORDS", &cfg.Content.PassageWords)
+	e.int("CONTENT_MAX_PASSAGES", &cfg.Content.MaxPassages)
+
+	e.int("VALIDATION_MAX_PROMPT_LENGTH", &cfg.Validation.MaxPromptLength)
+	e.int("VALIDATION_MAX_QUERY_COUNT", &cfg.Validation.MaxQueryCount)
+	e.int("VALIDATION_MAX_ENGINE_COUNT", &cfg.Validation.MaxEngineCount)
+	e.list("VALIDATION_SUPPORTED_ENGINES", &cfg.Validation.SupportedEngines)
+	e.int("VALIDATION_MAX_CATEGORY_COUNT", &cfg.Validation.MaxCategoryCount)
+	e.list("VALIDATION_SUPPORTED_CATEGORIES", &cfg.Validation.SupportedCategories)
+	e.int("VALIDATION_MAX_PAGES", &cfg.Validation.MaxPages)
+
+	e.duration("TIMEOUT_HTTP_CLIENT", &cfg.Timeouts.HTTPClient)
+	e.duration("TIMEOUT_SEARX_REQUEST", &cfg.Timeouts.SearxRequest)
+	e.duration("TIMEOUT_CONTENT_FETCH", &cfg.Timeouts.ContentFetch)
+	e.duration("TIMEOUT_OPENROUTER_API", &cfg.Timeouts.OpenRouterAPI)
+	e.duration("TIMEOUT_QUERY_GENERATION", &cfg.Timeouts.QueryGeneration)
+	e.duration("TIMEOUT_AI_RELEVANCE", &cfg.Timeouts.AIRelevance)
+	e.duration("TIMEOUT_CONTENT_RELEVANCE", &cfg.Timeouts.ContentRelevance)
+	e.duration("TIMEOUT_ANSWER", &cfg.Timeouts.Answer)
+
+	e.int("LIMITS_MAX_SEARCH_RESULTS", &cfg.Limits.MaxSearchResults)
+	e.int("LIMITS_MAX_ITEMS_TO_FILTER", &cfg.Limits.MaxItemsToFilter)
+	e.int("LIMITS_MAX_CONTENT_ITEMS", &cfg.Limits.MaxContentItems)
+
+	e.bool("DEBUG", &cfg.Debug.Enabled)
+	e.bool("DEBUG_LOG_REQUESTS", &cfg.Debug.LogRequests)
+	e.str("DEBUG_LOG_FILE", &cfg.Debug.LogFile)
+
+	e.bool("METRICS_ENABLED", &cfg.Metrics.Enabled)
+	e.str("METRICS_PATH", &cfg.Metrics.Path)
+
+	e.str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	e.str("TRACING_OTLP_ENDPOINT", &cfg.Tracing.Endpoint)
+	e.bool("TRACING_OTLP_INSECURE", &cfg.Tracing.Insecure)
+	e.str("TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	e.float("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	e.bool("AUTH_ENABLED", &cfg.Auth.Enabled)
+	e.str("AUTH_KEYS_FILE", &cfg.Auth.KeysFile)
+
+	e.bool("RATE_LIMIT_ENABLED", &cfg.RateLimit.Enabled)
+	e.float("RATE_LIMIT_SEARCHES_PER_MINUTE", &cfg.RateLimit.SearchesPerMinute)
+	e.int("RATE_LIMIT_BURST", &cfg.RateLimit.Burst)
+	e.int("RATE_LIMIT_MAX_CONCURRENT_PER_CONNECTION", &cfg.RateLimit.MaxConcurrentPerConnection)
+	e.list("RATE_LIMIT_TRUSTED_PROXIES", &cfg.RateLimit.TrustedProxies)
+
+	e.duration("JOBS_RETENTION", &cfg.Jobs.Retention)
+	e.int("JOBS_MAX_STORED", &cfg.Jobs.MaxStored)
+	e.str("JOBS_CALLBACK_SECRET", &cfg.Jobs.CallbackSecret)
+	e.duration("JOBS_CALLBACK_TIMEOUT", &cfg.Jobs.CallbackTimeout)
+	e.int("JOBS_CALLBACK_ATTEMPTS", &cfg.Jobs.CallbackAttempts)
+
+	e.bool("OUTBOUND_ALLOW_PRIVATE_NETWORKS", &cfg.Outbound.AllowPrivateNetworks)
+
+	e.int("BATCH_MAX_ITEMS", &cfg.Batch.MaxItems)
+	e.int("BATCH_CONCURRENCY", &cfg.Batch.Concurrency)
+	e.int64("BATCH_MAX_BODY_SIZE", &cfg.Batch.MaxBodySize)
+
+	e.str("CHAT_MODEL_ID", &cfg.Chat.ModelID)
+	e.int("CHAT_MAX_SOURCES", &cfg.Chat.MaxSources)
+
+	e.str("SUGGEST_PROVIDER", &cfg.Suggest.Provider)
+	e.str("SUGGEST_SEARX_BACKEND", &cfg.Suggest.SearxBackend)
+	e.int("SUGGEST_MAX_SUGGESTIONS", &cfg.Suggest.MaxSuggestions)
+	e.float("SUGGEST_REQUESTS_PER_MINUTE", &cfg.Suggest.RequestsPerMinute)
+	e.int("SUGGEST_BURST", &cfg.Suggest.Burst)
+	e.duration("SUGGEST_TIMEOUT", &cfg.Suggest.Timeout)
+
+	e.bool("HISTORY_ENABLED", &cfg.History.Enabled)
+	e.str("HISTORY_PATH", &cfg.History.Path)
+	e.duration("HISTORY_RETENTION", &cfg.History.Retention)
+	e.int("HISTORY_MAX_ENTRIES", &cfg.History.MaxEntries)
+
+	e.bool("SAVED_SEARCHES_ENABLED", &cfg.Saved.Enabled)
+	e.str("SAVED_SEARCHES_PATH", &cfg.Saved.Path)
+	e.duration("SAVED_SEARCHES_MIN_INTERVAL", &cfg.Saved.MinInterval)
+	e.int("SAVED_SEARCHES_MAX_PER_KEY", &cfg.Saved.MaxPerKey)
+	e.int("SAVED_SEARCHES_FEED_ITEMS", &cfg.Saved.FeedItems)
+
+	return e.errs
+}
+
+// validateConfig checks value ranges and enumerations and returns every
+// problem found rather than stopping at the first one.
+func validateConfig(cfg AppConfig) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	positive := func(name string, v int) {
+		check(v > 0, "%s must be positive, got %d", name, v)
+	}
+	positiveDuration := func(name string, d time.Duration) {
+		check(d > 0, "%s must be a positive duration, got %s", name, d)
+	}
+
+	port, err := strconv.Atoi(cfg.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port must be a TCP port, got %q", cfg.Server.Port)
+	check(cfg.Server.ConfigWatchInterval >= 0, "server.config_watch_interval must not be negative")
+	check(cfg.Server.DrainTimeout >= 0, "server.drain_timeout must not be negative")
+	check(cfg.Server.PublicURL == "" || strings.HasPrefix(cfg.Server.PublicURL, "http://") || strings.HasPrefix(cfg.Server.PublicURL, "https://"),
+		"server.public_url must be an http(s) URL, got %q", cfg.Server.PublicURL)
+
+	check(cfg.OpenRouter.Model != "", "openrouter.model must not be empty")
+	check(strings.HasPrefix(cfg.OpenRouter.Endpoint, "http"), "openrouter.endpoint must be an http(s) URL, got %q", cfg.OpenRouter.Endpoint)
+	positive("openrouter.query_max_tokens", cfg.OpenRouter.QueryGenMaxTokens)
+	positive("openrouter.filter_max_tokens", cfg.OpenRouter.FilterMaxTokens)
+	positive("openrouter.content_max_tokens", cfg.OpenRouter.ContentMaxTokens)
+	positive("openrouter.answer_max_tokens", cfg.OpenRouter.AnswerMaxTokens)
+
+	for _, inst := range searxInstances(cfg) {
+		check(strings.HasPrefix(inst.URL, "http"), "searx instance URL must be an http(s) URL, got %q", inst.URL)
+		check(inst.Weight > 0, "searx instance %s weight must be positive, got %d", inst.URL, inst.Weight)
+	}
+	check(cfg.Searx.Strategy == searxStrategyRoundRobin || cfg.Searx.Strategy == searxStrategyLeastLatency,
+		"searx.strategy must be %q or %q, got %q", searxStrategyRoundRobin, searxStrategyLeastLatency, cfg.Searx.Strategy)
+	check(cfg.Searx.HealthInterval >= 0, "searx.health_interval must not be negative")
+	positive("searx.max_failures", cfg.Searx.MaxFailures)
+	positiveDuration("searx.eject_duration", cfg.Searx.EjectDuration)
+	positive("searx.max_attempts", cfg.Searx.MaxAttempts)
+
+	positive("websocket.max_connections", cfg.WebSocket.MaxConnections)
+	positiveDuration("websocket.message_timeout", cfg.WebSocket.MessageTimeout)
+	positiveDuration("websocket.ping_interval", cfg.WebSocket.PingInterval)
+	check(cfg.WebSocket.PingInterval < cfg.WebSocket.MessageTimeout,
+		"websocket.ping_interval (%s) must be shorter than websocket.message_timeout (%s)", cfg.WebSocket.PingInterval, cfg.WebSocket.MessageTimeout)
+	positiveDuration("websocket.search_timeout", cfg.WebSocket.SearchTimeout)
+	for _, origin := range cfg.WebSocket.AllowedOrigins {
+		_, err := parseOriginPattern(origin)
+		check(err == nil, "websocket.allowed_origins: %v", err)
+	}
+	check(cfg.WebSocket.MaxMessageSize > 0, "websocket.max_message_size must be positive, got %d", cfg.WebSocket.MaxMessageSize)
+	check(cfg.WebSocket.ResumeRetention >= 0, "websocket.resume_retention must not be negative, got %s", cfg.WebSocket.ResumeRetention)
+	check(cfg.WebSocket.ResumeBufferSize > 0, "websocket.resume_buffer_size must be positive, got %d", cfg.WebSocket.ResumeBufferSize)
+
+	positive("search.default_query_count", cfg.Search.DefaultQueryCount)
+	check(cfg.Search.DefaultQueryCount <= cfg.Validation.MaxQueryCount,
+		"search.default_query_count (%d) exceeds validation.max_query_count (%d)", cfg.Search.DefaultQueryCount, cfg.Validation.MaxQueryCount)
+	positive("search.max_concurrent_queries", cfg.Search.MaxConcurrentQueries)
+	positive("search.max_concurrent_content", cfg.Search.MaxConcurrentContent)
+	positive("search.max_concurrent_filter", cfg.Search.MaxConcurrentFilter)
+	positive("search.max_concurrent_searches", cfg.Search.MaxConcurrentSearches)
+
+	positive("content.truncation_length", cfg.Content.TruncationLength)
+	positive("content.passage_words", cfg.Content.PassageWords)
+	positive("content.max_passages", cfg.Content.MaxPassages)
+
+	positive("validation.max_prompt_length", cfg.Validation.MaxPromptLength)
+	positive("validation.max_query_count", cfg.Validation.MaxQueryCount)
+	positive("validation.max_engine_count", cfg.Validation.MaxEngineCount)
+	positive("validation.max_pages", cfg.Validation.MaxPages)
+
+	positiveDuration("timeouts.searx_request", cfg.Timeouts.SearxRequest)
+	positiveDuration("timeouts.content_fetch", cfg.Timeouts.ContentFetch)
+	positiveDuration("timeouts.query_generation", cfg.Timeouts.QueryGeneration)
+	positiveDuration("timeouts.ai_relevance", cfg.Timeouts.AIRelevance)
+	positiveDuration("timeouts.content_relevance", cfg.Timeouts.ContentRelevance)
+	positiveDuration("timeouts.answer", cfg.Timeouts.Answer)
+
+	check(strings.HasPrefix(cfg.Metrics.Path, "/"), "metrics.path must start with /, got %q", cfg.Metrics.Path)
+
+	check(cfg.Tracing.Exporter == "none" || cfg.Tracing.Exporter == "otlp",
+		"tracing.exporter must be \"none\" or \"otlp\", got %q", cfg.Tracing.Exporter)
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1,
+		"tracing.sample_ratio must be between 0 and 1, got %v", cfg.Tracing.SampleRatio)
+
+	check(!cfg.Auth.Enabled || len(cfg.Auth.Keys) > 0, "auth.enabled requires at least one key in auth.keys or auth.keys_file")
+	names := make(map[string]bool)
+	secrets := make(map[string]bool)
+	for i, k := range cfg.Auth.Keys {
+		check(k.Name != "", "auth key #%d must have a name", i+1)
+		check(!names[k.Name], "auth key name %q is duplicated", k.Name)
+		check(len(k.Key) >= minAPIKeyLength, "auth key %q must be at least %d characters long", k.Name, minAPIKeyLength)
+		check(!secrets[k.Key], "auth key %q reuses the secret of another key", k.Name)
+		for _, scope := range k.Scopes {
+			check(knownScopes[scope], "auth key %q has unknown scope %q", k.Name, scope)
+		}
+		check(k.DailySearches >= 0, "auth key %q daily_searches must not be negative", k.Name)
+		check(k.DailyTokens >= 0, "auth key %q daily_tokens must not be negative", k.Name)
+		names[k.Name] = true
+		secrets[k.Key] = true
+	}
+
+	if cfg.RateLimit.Enabled {
+		check(cfg.RateLimit.SearchesPerMinute > 0, "rate_limit.searches_per_minute must be positive, got %v", cfg.RateLimit.SearchesPerMinute)
+		positive("rate_limit.burst", cfg.RateLimit.Burst)
+		check(cfg.RateLimit.MaxConcurrentPerConnection >= 0, "rate_limit.max_concurrent_per_connection must not be negative")
+	}
+	for _, proxy := range cfg.RateLimit.TrustedProxies {
+		_, err := parseCIDROrIP(proxy)
+		check(err == nil, "rate_limit.trusted_proxies: %q is not an IP address or CIDR", proxy)
+	}
+
+	check(cfg.Jobs.Retention >= 0, "jobs.retention must not be negative")
+	positive("jobs.max_stored", cfg.Jobs.MaxStored)
+	positiveDuration("jobs.callback_timeout", cfg.Jobs.CallbackTimeout)
+	positive("jobs.callback_attempts", cfg.Jobs.CallbackAttempts)
+
+	positive("batch.max_items", cfg.Batch.MaxItems)
+	positive("batch.concurrency", cfg.Batch.Concurrency)
+	check(cfg.Batch.MaxBodySize > 0, "batch.max_body_size must be positive, got %d", cfg.Batch.MaxBodySize)
+
+	check(cfg.Chat.ModelID != "", "chat.model_id must not be empty")
+	positive("chat.max_sources", cfg.Chat.MaxSources)
+
+	check(cfg.Suggest.Provider == suggestProviderSearx || cfg.Suggest.Provider == suggestProviderLLM || cfg.Suggest.Provider == suggestProviderNone,
+		"suggest.provider must be %q, %q or %q, got %q", suggestProviderSearx, suggestProviderLLM, suggestProviderNone, cfg.Suggest.Provider)
+	positive("suggest.max_suggestions", cfg.Suggest.MaxSuggestions)
+	check(cfg.Suggest.RequestsPerMinute > 0, "suggest.requests_per_minute must be positive, got %v", cfg.Suggest.RequestsPerMinute)
+	positive("suggest.burst", cfg.Suggest.Burst)
+	positiveDuration("suggest.timeout", cfg.Suggest.Timeout)
+
+	check(!cfg.History.Enabled || cfg.History.Path != "", "history.path must be set when history is enabled")
+	check(cfg.History.Retention >= 0, "history.retention must not be negative")
+	check(cfg.History.MaxEntries >= 0, "history.max_entries must not be negative, got %d", cfg.History.MaxEntries)
+
+	check(!cfg.Saved.Enabled || cfg.Saved.Path != "", "saved_searches.path must be set when saved searches are enabled")
+	positiveDuration("saved_searches.min_interval", cfg.Saved.MinInterval)
+	positive("saved_searches.max_per_key", cfg.Saved.MaxPerKey)
+	positive("saved_searches.feed_items", cfg.Saved.FeedItems)
+
+	return errs
+}
+
+// envReader applies environment variables onto config fields, leaving the
+// field untouched when the variable is unset and recording parse errors
+// instead of silently falling back to the default.
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) lookup(key string) (string, bool) {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return "", false
+	}
+	return strings.TrimSpace(val), true
+}
+
+func (e *envReader) fail(key, val, kind string) {
+	e.errs = append(e.errs, fmt.Errorf("%s: invalid %s %q", key, kind, val))
+}
+
+func (e *envReader) str(key string, dst *string) {
+	if val, ok := e.lookup(key); ok {
+		*dst = val
+	}
+}
+
+func (e *envReader) int(key string, dst *int) {
+	if val, ok := e.lookup(key); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			e.fail(key, val, "integer")
+			return
+		}
+		*dst = v
+	}
+}
+
+func (e *envReader) int64(key string, dst *int64) {
+	if val, ok := e.lookup(key); ok {
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			e.fail(key, val, "integer")
+			return
+		}
+		*dst = v
+	}
+}
+
+func (e *envReader) float(key string, dst *float64) {
+	if val, ok := e.lookup(key); ok {
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			e.fail(key, val, "number")
+			return
+		}
+		*dst = v
+	}
+}
+
+func (e *envReader) bool(key string, dst *bool) {
+	if val, ok := e.lookup(key); ok {
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			e.fail(key, val, "boolean")
+			return
+		}
+		*dst = v
+	}
 }
 
-func getenv(key, def string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		return def
+func (e *envReader) duration(key string, dst *time.Duration) {
+	if val, ok := e.lookup(key); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			e.fail(key, val, "duration")
+			return
+		}
+		*dst = v
 	}
-	return val
 }
 
-func atoi(s string, def int) int {
-	if v, err := strconv.Atoi(s); err == nil {
-		return v
+func (e *envReader) list(key string, dst *[]string) {
+	if val, ok := e.lookup(key); ok {
+		*dst = parseStringSlice(val)
 	}
-	return def
 }
 
-func parseDuration(s string, def time.Duration) time.Duration {
-	if d, err := time.ParseDuration(s); err == nil {
-		return d
+// searxInstances pairs the URL list with the optional weight list; missing
+// weights default to 1.
+func (e *envReader) searxInstances(urlsKey, weightsKey string, dst *[]SearxInstance) {
+	val, ok := e.lookup(urlsKey)
+	if !ok {
+		return
+	}
+	urls := parseStringSlice(val)
+	var weights []string
+	if w, ok := e.lookup(weightsKey); ok {
+		weights = parseStringSlice(w)
+	}
+
+	instances := make([]SearxInstance, 0, len(urls))
+	for i, u := range urls {
+		weight := 1
+		if i < len(weights) {
+			v, err := strconv.Atoi(weights[i])
+			if err != nil {
+				e.fail(weightsKey, weights[i], "integer")
+				continue
+			}
+			weight = v
+		}
+		instances = append(instances, SearxInstance{URL: u, Weight: weight})
 	}
-	return def
+	*dst = instances
 }
 
 func parseStringSlice(s string) []string {
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const redactedValue = "<redacted>"
+
+// loadConfigFile decodes a YAML (.yaml, .yml) or TOML (.toml) file on top of
+// cfg. Unknown keys are rejected so that typos do not go unnoticed.
+func loadConfigFile(path string, cfg *AppConfig) error {
+	return decodeFile(path, cfg)
+}
+
+// decodeFile strictly decodes a YAML or TOML file into dst, choosing the
+// format by extension.
+func decodeFile(path string, dst any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(dst); err != nil && err != io.EOF {
+			return fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), dst)
+		if err != nil {
+			return fmt.Errorf("parse config file %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, k := range undecoded {
+				keys = append(keys, k.String())
+			}
+			return fmt.Errorf("parse config file %s: unknown keys: %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension, use .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// loadKeysFile reads API keys from a YAML or TOML file with a top-level
+// "keys" list in the same format as auth.keys.
+func loadKeysFile(path string) ([]APIKeyConfig, error) {
+	var file struct {
+		Keys []APIKeyConfig `yaml:"keys" toml:"keys"`
+	}
+	if err := decodeFile(path, &file); err != nil {
+		return nil, err
+	}
+	return file.Keys, nil
+}
+
+// redactConfig returns a copy of cfg with secrets replaced, suitable for
+// printing or logging.
+func redactConfig(cfg AppConfig) AppConfig {
+	if cfg.OpenRouter.APIKey != "" {
+		cfg.OpenRouter.APIKey = redactedValue
+	}
+	if cfg.Jobs.CallbackSecret != "" {
+		cfg.Jobs.CallbackSecret = redactedValue
+	}
+	// Срез копируем, чтобы не затереть ключи в исходной конфигурации
+	keys := make([]APIKeyConfig, len(cfg.Auth.Keys))
+	for i, k := range cfg.Auth.Keys {
+		k.Key = redactedValue
+		keys[i] = k
+	}
+	cfg.Auth.Keys = keys
+	return cfg
+}
+
+// printConfig writes the effective configuration as YAML with secrets redacted.
+func printConfig(w io.Writer, cfg AppConfig) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(redactConfig(cfg)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// ConfigStore holds the live configuration. Handlers call Load for every new
+// search so reloaded settings apply without dropping open connections.
+type ConfigStore struct {
+	path   string
+	logger *Logger
+	cur    atomic.Pointer[AppConfig]
+	mu     sync.Mutex // serializes reloads
+}
+
+func NewConfigStore(path string, cfg AppConfig, logger *Logger) *ConfigStore {
+	s := &ConfigStore{path: path, logger: logger}
+	s.cur.Store(&cfg)
+	return s
+}
+
+// Load returns the current configuration snapshot.
+func (s *ConfigStore) Load() AppConfig {
+	return *s.cur.Load()
+}
+
+// Reload re-reads the file and environment. Invalid configurations are
+// rejected as a whole and the previous one stays active. Settings that
+// cannot change at runtime keep their old values and are reported.
+func (s *ConfigStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next, err := loadConfig(s.path)
+	if err != nil {
+		return err
+	}
+
+	cur := s.Load()
+	merged, pinned := mergeReloadable(cur, next)
+	for _, section := range pinned {
+		s.logger.Warn("config change requires restart, ignoring", "section", section)
+	}
+	s.cur.Store(&merged)
+	return nil
+}
+
+// mergeReloadable returns next with the sections that cannot be changed at
+// runtime (listen port, metrics, tracing, debug logging and the history and
+// saved search databases) copied from cur, along with the names of those
+// sections that differed.
+func mergeReloadable(cur, next AppConfig) (AppConfig, []string) {
+	var pinned []string
+	if cur.Server != next.Server {
+		pinned = append(pinned, "server")
+	}
+	if cur.Metrics != next.Metrics {
+		pinned = append(pinned, "metrics")
+	}
+	if cur.Tracing != next.Tracing {
+		pinned = append(pinned, "tracing")
+	}
+	if cur.Debug != next.Debug {
+		pinned = append(pinned, "debug")
+	}
+	next.Server = cur.Server
+	next.Metrics = cur.Metrics
+	next.Tracing = cur.Tracing
+	next.Debug = cur.Debug
+	// Срок хранения истории меняется на лету, сама база открывается при старте
+	if cur.History.Enabled != next.History.Enabled || cur.History.Path != next.History.Path {
+		pinned = append(pinned, "history")
+	}
+	next.History.Enabled, next.History.Path = cur.History.Enabled, cur.History.Path
+	if cur.Saved.Enabled != next.Saved.Enabled || cur.Saved.Path != next.Saved.Path {
+		pinned = append(pinned, "saved_searches")
+	}
+	next.Saved.Enabled, next.Saved.Path = cur.Saved.Enabled, cur.Saved.Path
+	return next, pinned
+}
+
+// Watch reloads the configuration on SIGHUP and, when a file is configured
+// and interval is positive, whenever the file's modification time or size
+// changes. onReload is called after each successful reload.
+func (s *ConfigStore) Watch(ctx context.Context, interval time.Duration, onReload func(AppConfig)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	var lastMod time.Time
+	var lastSize int64
+	if s.path != "" && interval > 0 {
+		if st, err := os.Stat(s.path); err == nil {
+			lastMod, lastSize = st.ModTime(), st.Size()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	reload := func(reason string) {
+		if err := s.Reload(); err != nil {
+			s.logger.Error("config reload failed, keeping previous config", "reason", reason, "error", err)
+			return
+		}
+		s.logger.Info("config reloaded", "reason", reason)
+		if onReload != nil {
+			onReload(s.Load())
+		}
+	}
+
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload("sighup")
+		case <-tick:
+			st, err := os.Stat(s.path)
+			if err != nil {
+				continue
+			}
+			if st.ModTime().Equal(lastMod) && st.Size() == lastSize {
+				continue
+			}
+			lastMod, lastSize = st.ModTime(), st.Size()
+			reload("file_changed")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFileWithEnvOverride(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+openrouter:
+  model: anthropic/claude-3-haiku
+searx:
+  instances:
+    - url: http://searx-a:8080
+      weight: 2
+timeouts:
+  searx_request: 5s
+`)
+	t.Setenv("OPENROUTER_MODEL", "openai/gpt-4o")
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.OpenRouter.Model != "openai/gpt-4o" {
+		t.Fatalf("expected env to override file, got %q", cfg.OpenRouter.Model)
+	}
+	if cfg.Timeouts.SearxRequest != 5*time.Second {
+		t.Fatalf("expected searx timeout from file, got %s", cfg.Timeouts.SearxRequest)
+	}
+	if len(cfg.Searx.Instances) != 1 || cfg.Searx.Instances[0].Weight != 2 {
+		t.Fatalf("unexpected instances: %+v", cfg.Searx.Instances)
+	}
+	if cfg.Search.DefaultQueryCount != 5 {
+		t.Fatalf("expected defaults to survive, got %d", cfg.Search.DefaultQueryCount)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", `
+[websocket]
+search_timeout = "2m"
+allowed_origins = ["https://search.example.com"]
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.WebSocket.SearchTimeout != 2*time.Minute || len(cfg.WebSocket.AllowedOrigins) != 1 {
+		t.Fatalf("unexpected websocket config: %+v", cfg.WebSocket)
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "search:\n  default_query_cnt: 3\n")
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "default_query_cnt") {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	t.Setenv("OPENROUTER_QUERY_MAX_TOKENS", "25six")
+	t.Setenv("WEBSOCKET_SEARCH_TIMEOUT", "ten minutes")
+	t.Setenv("SEARX_STRATEGY", "random")
+
+	_, err := loadConfig("")
+	if err == nil {
+		t.Fatalf("expected validation errors")
+	}
+	for _, want := range []string{"OPENROUTER_QUERY_MAX_TOKENS", "WEBSOCKET_SEARCH_TIMEOUT", "searx.strategy"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error to mention %s, got: %v", want, err)
+		}
+	}
+}
+
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.OpenRouter.APIKey = "sk-or-secret"
+
+	var buf bytes.Buffer
+	if err := printConfig(&buf, cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "sk-or-secret") {
+		t.Fatalf("secret leaked into printed config")
+	}
+	if !strings.Contains(buf.String(), "search_timeout: 10m0s") {
+		t.Fatalf("expected durations to be printed as strings:\n%s", buf.String())
+	}
+}
+
+func TestMergeReloadablePinsRestartOnlySections(t *testing.T) {
+	cur := defaultConfig()
+	next := defaultConfig()
+	next.Server.Port = "9090"
+	next.OpenRouter.Model = "openai/gpt-4o"
+
+	merged, pinned := mergeReloadable(cur, next)
+	if merged.Server.Port != "8080" {
+		t.Fatalf("expected port to stay unchanged, got %s", merged.Server.Port)
+	}
+	if merged.OpenRouter.Model != "openai/gpt-4o" {
+		t.Fatalf("expected model to be reloaded, got %s", merged.OpenRouter.Model)
+	}
+	if len(pinned) != 1 || pinned[0] != "server" {
+		t.Fatalf("unexpected pinned sections: %v", pinned)
+	}
+}
+
+func TestMergeReloadableKeepsHistoryDatabase(t *testing.T) {
+	cur := defaultConfig()
+	next := defaultConfig()
+	next.History.Enabled = true
+	next.History.Retention = time.Hour
+
+	merged, pinned := mergeReloadable(cur, next)
+	if merged.History.Enabled || merged.History.Retention != time.Hour {
+		t.Fatalf("expected only the retention to be reloaded, got %+v", merged.History)
+	}
+	if len(pinned) != 1 || pinned[0] != "history" {
+		t.Fatalf("unexpected pinned sections: %v", pinned)
+	}
+}
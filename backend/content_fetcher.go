@@ -2,13 +2,42 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	readability "github.com/go-shiori/go-readability"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func fetchPageContent(ctx context.Context, targetURL string, cfg AppConfig) (string, error) {
+// PageDocument is the structured result of extracting a fetched page.
+// Text carries the flattened body used for relevance judging and is not
+// serialized; the remaining fields are exposed on SearchResult.Page.
+type PageDocument struct {
+	Title       string     `json:"title,omitempty"`
+	Author      string     `json:"author,omitempty"`
+	SiteName    string     `json:"site_name,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	ModifiedAt  *time.Time `json:"modified_at,omitempty"`
+	Language    string     `json:"language,omitempty"`
+	WordCount   int        `json:"word_count"`
+	Image       string     `json:"image,omitempty"`
+	Excerpt     string     `json:"excerpt,omitempty"`
+	Text        string     `json:"-"`
+}
+
+// fetchPageContent downloads targetURL and extracts its readable content.
+// Trace context is deliberately not propagated to third-party sites; the
+// fetch is only recorded as a local span. Internal addresses are refused on
+// every redirect hop unless cfg.Outbound.AllowPrivateNetworks is set.
+func fetchPageContent(ctx context.Context, targetURL string, cfg AppConfig) (_ *PageDocument, err error) {
+	_, span := tracer.Start(ctx, "content.fetch", trace.WithAttributes(attribute.String("url.full", targetURL)))
+	defer func() { endSpan(span, err) }()
+
 	// Derive timeout from context if available
 	timeout := cfg.Timeouts.ContentFetch
 	if deadline, ok := ctx.Deadline(); ok {
@@ -18,14 +47,62 @@ func fetchPageContent(ctx context.Context, targetURL string, cfg AppConfig) (str
 		}
 	}
 
-	article, err := readability.FromURL(targetURL, timeout)
+	article, err := fetchArticle(ctx, targetURL, newOutboundClient(timeout, cfg.Outbound))
 	if err != nil {
-		return "", err
+		contentFetchTotal.WithLabelValues("error").Inc()
+		return nil, err
 	}
+	doc := newPageDocument(article)
+	span.SetAttributes(attribute.Int("content.word_count", doc.WordCount))
+	if doc.WordCount == 0 {
+		contentFetchTotal.WithLabelValues("empty").Inc()
+	} else {
+		contentFetchTotal.WithLabelValues("success").Inc()
+	}
+	return doc, nil
+}
+
+// fetchArticle GETs an HTML page with client and parses it, like
+// readability.FromURL but without its unrestricted client.
+func fetchArticle(ctx context.Context, targetURL string, client *http.Client) (readability.Article, error) {
+	parsedURL, err := url.ParseRequestURI(targetURL)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to parse URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return readability.Article{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to fetch the page: %w", err)
+	}
+	defer resp.Body.Close()
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return readability.Article{}, errors.New("URL is not a HTML document")
+	}
+	parser := readability.NewParser()
+	return parser.Parse(resp.Body, parsedURL)
+}
+
+// newPageDocument flattens the article text and copies its metadata.
+func newPageDocument(article readability.Article) *PageDocument {
 	text := strings.TrimSpace(article.TextContent)
 	if text == "" {
 		text = strings.TrimSpace(article.Excerpt)
 	}
-	text = strings.Join(strings.Fields(text), " ")
-	return text, nil
+	words := strings.Fields(text)
+
+	return &PageDocument{
+		Title:       strings.TrimSpace(article.Title),
+		Author:      strings.TrimSpace(article.Byline),
+		SiteName:    strings.TrimSpace(article.SiteName),
+		PublishedAt: article.PublishedTime,
+		ModifiedAt:  article.ModifiedTime,
+		Language:    strings.TrimSpace(article.Language),
+		WordCount:   len(words),
+		Image:       article.Image,
+		Excerpt:     strings.Join(strings.Fields(article.Excerpt), " "),
+		Text:        strings.Join(words, " "),
+	}
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	readability "github.com/go-shiori/go-readability"
+)
+
+func TestNewPageDocumentMetadata(t *testing.T) {
+	html := `<html lang="en"><head>
+<title>Go Scheduler Internals</title>
+<meta name="author" content="Jane Doe">
+<meta property="og:site_name" content="Go Blog">
+<meta property="og:image" content="https://example.com/lead.png">
+<meta property="article:published_time" content="2024-03-01T10:00:00Z">
+</head><body><article><h1>Go Scheduler Internals</h1>
+<p>` + strings.Repeat("The runtime scheduler multiplexes goroutines onto threads. ", 20) + `</p>
+</article></body></html>`
+
+	pageURL, _ := url.Parse("https://example.com/post")
+	article, err := readability.FromReader(strings.NewReader(html), pageURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	doc := newPageDocument(article)
+	if doc.Author != "Jane Doe" {
+		t.Fatalf("unexpected author: %q", doc.Author)
+	}
+	if doc.SiteName != "Go Blog" {
+		t.Fatalf("unexpected site name: %q", doc.SiteName)
+	}
+	if doc.PublishedAt == nil || doc.PublishedAt.Year() != 2024 {
+		t.Fatalf("expected published date in 2024, got %v", doc.PublishedAt)
+	}
+	if doc.Language != "en" {
+		t.Fatalf("unexpected language: %q", doc.Language)
+	}
+	if doc.WordCount != len(strings.Fields(doc.Text)) || doc.WordCount == 0 {
+		t.Fatalf("word count %d does not match text", doc.WordCount)
+	}
+	if strings.Contains(doc.Text, "\n") {
+		t.Fatalf("expected flattened text")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // AppError представляет структурированную ошибку приложения
@@ -12,6 +13,8 @@ type AppError struct {
 	Message string `json:"message"`
 	Details string `json:"details,omitempty"`
 	Status  int    `json:"-"`
+	// RetryAfter, when set, is sent as the Retry-After header
+	RetryAfter time.Duration `json:"-"`
 }
 
 func (e *AppError) Error() string {
@@ -45,6 +48,11 @@ var (
 		Message: "Failed to fetch page content",
 		Status:  http.StatusInternalServerError,
 	}
+	ErrAnswerGeneration = &AppError{
+		Code:    "ANSWER_GENERATION_FAILED",
+		Message: "Failed to generate an answer",
+		Status:  http.StatusBadGateway,
+	}
 )
 
 // NewAppError создает новую ошибку приложения
@@ -70,6 +78,9 @@ func WrapError(base *AppError, err error) *AppError {
 // ErrorResponse отправляет структурированный ответ об ошибке
 func ErrorResponse(w http.ResponseWriter, err *AppError) {
 	w.Header().Set("Content-Type", "application/json")
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(err.RetryAfter))
+	}
 	w.WriteHeader(err.Status)
 
 	response := struct {
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.etcd.io/bbolt"
+)
+
+// Откуда запущен поиск, сохраняется в истории
+const (
+	searchSourceWebSocket = "websocket"
+	searchSourceJob       = "job"
+	searchSourceBatch     = "batch"
+	searchSourceChat      = "chat"
+	searchSourceMCP       = "mcp"
+	searchSourceSaved     = "saved_search"
+	// CLI searches are never recorded, the database belongs to the server
+	searchSourceCLI = "cli"
+)
+
+const (
+	historyDefaultLimit = 20
+	historyMaxLimit     = 100
+	// historySweepInterval is how often retention and the per-key cap are
+	// enforced
+	historySweepInterval = time.Hour
+)
+
+var (
+	ErrHistoryNotFound = &AppError{
+		Code:    "HISTORY_NOT_FOUND",
+		Message: "History entry was not found or has expired",
+		Status:  http.StatusNotFound,
+	}
+	ErrHistoryDisabled = &AppError{
+		Code:    "HISTORY_DISABLED",
+		Message: "Search history is disabled on this server",
+		Status:  http.StatusNotFound,
+	}
+	ErrHistoryStorage = &AppError{
+		Code:    "HISTORY_STORAGE_FAILED",
+		Message: "Search history storage failed",
+		Status:  http.StatusInternalServerError,
+	}
+)
+
+// HistoryJudgment is the relevance verdict given to one result URL.
+type HistoryJudgment struct {
+	URL     string `json:"url"`
+	Verdict string `json:"verdict"`
+	Reason  string `json:"reason"`
+}
+
+// HistoryUsage is what a search spent on LLM calls. Cost is in OpenRouter
+// credits and is only set when OpenRouter reports it.
+type HistoryUsage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost,omitempty"`
+}
+
+// HistoryEntry is one recorded search. Result holds the search_complete
+// payload and Judgments every relevance verdict, including dropped results.
+type HistoryEntry struct {
+	ID        string            `json:"id"`
+	Source    string            `json:"source"`
+	Prompt    string            `json:"prompt"`
+	Settings  Settings          `json:"settings"`
+	Outcome   string            `json:"outcome"`
+	Result    *WSSearchResult   `json:"result,omitempty"`
+	Judgments []HistoryJudgment `json:"judgments,omitempty"`
+	Error     *WSError          `json:"error,omitempty"`
+	StartedAt time.Time         `json:"started_at"`
+	// ElapsedMs is the wall time of the search including the wait for a
+	// free slot
+	ElapsedMs int64        `json:"elapsed_ms"`
+	Usage     HistoryUsage `json:"usage"`
+}
+
+// HistorySummary is the list view of an entry.
+type HistorySummary struct {
+	ID          string       `json:"id"`
+	Source      string       `json:"source"`
+	Prompt      string       `json:"prompt"`
+	Outcome     string       `json:"outcome"`
+	ResultCount int          `json:"result_count"`
+	StartedAt   time.Time    `json:"started_at"`
+	ElapsedMs   int64        `json:"elapsed_ms"`
+	Usage       HistoryUsage `json:"usage"`
+}
+
+func (e *HistoryEntry) summary() HistorySummary {
+	s := HistorySummary{
+		ID:        e.ID,
+		Source:    e.Source,
+		Prompt:    e.Prompt,
+		Outcome:   e.Outcome,
+		StartedAt: e.StartedAt,
+		ElapsedMs: e.ElapsedMs,
+		Usage:     e.Usage,
+	}
+	if e.Result != nil {
+		s.ResultCount = len(e.Result.Results)
+	}
+	return s
+}
+
+// HistoryList is the body of GET /api/history. Next is passed back as
+// before to fetch the following page.
+type HistoryList struct {
+	Entries []HistorySummary `json:"entries"`
+	Next    string           `json:"next,omitempty"`
+}
+
+// HistoryQuery selects entries of one owner, newest first.
+type HistoryQuery struct {
+	// Before returns only entries older than the one with this ID
+	Before string
+	// Text keeps entries whose prompt contains it, ignoring case
+	Text  string
+	Limit int
+}
+
+// HistoryStore persists search history. Entries belong to an owner, the
+// API key name, which is empty when authentication is off.
+type HistoryStore interface {
+	Save(owner string, entry *HistoryEntry) error
+	// Get returns nil when owner has no entry with id
+	Get(owner, id string) (*HistoryEntry, error)
+	// List returns up to q.Limit entries and the cursor of the next page
+	List(owner string, q HistoryQuery) ([]*HistoryEntry, string, error)
+	Delete(owner, id string) (bool, error)
+	// Clear deletes every entry of owner
+	Clear(owner string) (int, error)
+	// Prune deletes entries started before cutoff, unless it is zero, and
+	// the oldest entries of each owner beyond maxPerOwner, unless it is zero
+	Prune(cutoff time.Time, maxPerOwner int) (int, error)
+	Close() error
+}
+
+// newHistoryID returns an ID that sorts by start time, so the store can
+// page and prune without decoding entries.
+func newHistoryID(started time.Time) string {
+	return fmt.Sprintf("%016x%s", started.UnixNano(), randomHex(4))
+}
+
+func historyIDTime(id string) time.Time {
+	if len(id) < 16 {
+		return time.Time{}
+	}
+	nanos, err := strconv.ParseUint(id[:16], 16, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(nanos))
+}
+
+var historyBucket = []byte("searches")
+
+// historyKeySep separates the owner from the entry ID in bbolt keys.
+const historyKeySep = "\x00"
+
+func historyKey(owner, id string) []byte {
+	return []byte(owner + historyKeySep + id)
+}
+
+// boltHistoryStore keeps entries as JSON in a single bbolt bucket under
+// "<owner>\x00<id>" keys, so one owner's entries are contiguous and ordered
+// by time.
+type boltHistoryStore struct {
+	db *bbolt.DB
+}
+
+func openBoltHistoryStore(path string) (*boltHistoryStore, error) {
+	db, err := openBolt(path, historyBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &boltHistoryStore{db: db}, nil
+}
+
+// openBolt opens the bbolt database at path, creating its directory and
+// the given buckets when missing.
+func openBolt(path string, buckets ...[]byte) (*bbolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	// Таймаут нужен, чтобы второй процесс с той же базой не зависал на блокировке
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (s *boltHistoryStore) Save(owner string, entry *HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(historyBucket).Put(historyKey(owner, entry.ID), data)
+	})
+}
+
+func (s *boltHistoryStore) Get(owner, id string) (*HistoryEntry, error) {
+	var entry *HistoryEntry
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(historyBucket).Get(historyKey(owner, id))
+		if data == nil {
+			return nil
+		}
+		entry = &HistoryEntry{}
+		return json.Unmarshal(data, entry)
+	})
+	return entry, err
+}
+
+func (s *boltHistoryStore) List(owner string, q HistoryQuery) ([]*HistoryEntry, string, error) {
+	prefix := historyKey(owner, "")
+	text := strings.ToLower(q.Text)
+	var (
+		entries []*HistoryEntry
+		next    string
+	)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		// Идем от верхней границы назад: новые записи в конце диапазона владельца
+		upper := []byte(owner + "\x01")
+		if q.Before != "" {
+			upper = historyKey(owner, q.Before)
+		}
+		k, v := c.Seek(upper)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Prev() {
+			var entry HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if text != "" && !strings.Contains(strings.ToLower(entry.Prompt), text) {
+				continue
+			}
+			if len(entries) == q.Limit {
+				next = entries[len(entries)-1].ID
+				break
+			}
+			entries = append(entries, &entry)
+		}
+		return nil
+	})
+	return entries, next, err
+}
+
+func (s *boltHistoryStore) Delete(owner, id string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		key := historyKey(owner, id)
+		if b.Get(key) == nil {
+			return nil
+		}
+		found = true
+		return b.Delete(key)
+	})
+	return found, err
+}
+
+func (s *boltHistoryStore) Clear(owner string) (int, error) {
+	prefix := historyKey(owner, "")
+	var removed int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, bytes.Clone(k))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	return removed, err
+}
+
+func (s *boltHistoryStore) Prune(cutoff time.Time, maxPerOwner int) (int, error) {
+	var removed int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		var expired [][]byte
+		byOwner := make(map[string][][]byte)
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			owner, id, _ := strings.Cut(string(k), historyKeySep)
+			key := bytes.Clone(k)
+			if !cutoff.IsZero() && historyIDTime(id).Before(cutoff) {
+				expired = append(expired, key)
+				continue
+			}
+			byOwner[owner] = append(byOwner[owner], key)
+		}
+		if maxPerOwner > 0 {
+			// Ключи владельца отсортированы по времени, лишние — самые старые
+			for _, keys := range byOwner {
+				if len(keys) > maxPerOwner {
+					expired = append(expired, keys[:len(keys)-maxPerOwner]...)
+				}
+			}
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	return removed, err
+}
+
+func (s *boltHistoryStore) Close() error {
+	return s.db.Close()
+}
+
+// SearchHistory records finished searches and serves /api/history. A nil
+// *SearchHistory records nothing and its endpoints report that history is
+// disabled.
+type SearchHistory struct {
+	store  *ConfigStore
+	db     HistoryStore
+	logger *Logger
+	now    func() time.Time
+}
+
+func NewSearchHistory(store *ConfigStore, db HistoryStore, logger *Logger) *SearchHistory {
+	return &SearchHistory{store: store, db: db, logger: logger, now: time.Now}
+}
+
+// OpenSearchHistory opens the database at history.path, or returns nil when
+// history is disabled.
+func OpenSearchHistory(store *ConfigStore, logger *Logger) (*SearchHistory, error) {
+	cfg := store.Load().History
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	db, err := openBoltHistoryStore(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSearchHistory(store, db, logger), nil
+}
+
+func (h *SearchHistory) Close() error {
+	if h == nil {
+		return nil
+	}
+	return h.db.Close()
+}
+
+// Sweep enforces history.retention and history.max_entries now and then
+// hourly until ctx is done.
+func (h *SearchHistory) Sweep(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	ticker := time.NewTicker(historySweepInterval)
+	defer ticker.Stop()
+	for {
+		h.prune()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *SearchHistory) prune() {
+	cfg := h.store.Load().History
+	var cutoff time.Time
+	if cfg.Retention > 0 {
+		cutoff = h.now().Add(-cfg.Retention)
+	}
+	removed, err := h.db.Prune(cutoff, cfg.MaxEntries)
+	if err != nil {
+		h.logger.Error("failed to prune search history", "error", err)
+		return
+	}
+	if removed > 0 {
+		h.logger.Info("search history pruned", "removed", removed)
+	}
+}
+
+// track wraps out so that the search is recorded when the returned function
+// is called with its outcome. The owner and token usage are taken from ctx.
+func (h *SearchHistory) track(ctx context.Context, out messageSink, source string, searchReq SearchRequest) (messageSink, func(outcome string)) {
+	if h == nil {
+		return out, func(string) {}
+	}
+	rec := &historyRecorder{out: out, entry: HistoryEntry{
+		Source:    source,
+		Prompt:    searchReq.Prompt,
+		Settings:  searchReq.Settings,
+		StartedAt: h.now(),
+	}}
+	return rec, func(outcome string) { h.save(ctx, rec, outcome) }
+}
+
+func (h *SearchHistory) save(ctx context.Context, rec *historyRecorder, outcome string) {
+	rec.mu.Lock()
+	entry := rec.entry
+	rec.mu.Unlock()
+
+	entry.ID = newHistoryID(entry.StartedAt)
+	entry.Outcome = finalOutcome(ctx, outcome)
+	entry.ElapsedMs = h.now().Sub(entry.StartedAt).Milliseconds()
+	if usage := tokenUsageFromContext(ctx); usage != nil {
+		entry.Usage = HistoryUsage{
+			PromptTokens:     usage.prompt.Load(),
+			CompletionTokens: usage.completion.Load(),
+			TotalTokens:      usage.total.Load(),
+			Cost:             usage.cost(),
+		}
+	}
+
+	status := "success"
+	if err := h.db.Save(principalName(principalFromContext(ctx)), &entry); err != nil {
+		status = "error"
+		h.logger.Error("failed to record search history", "error", err)
+	}
+	historyWritesTotal.WithLabelValues(status).Inc()
+}
+
+// historyRecorder forwards messages to the search's sink and keeps what the
+// history entry needs.
+type historyRecorder struct {
+	out messageSink
+
+	mu    sync.Mutex
+	entry HistoryEntry
+}
+
+func (r *historyRecorder) Send(msgType string, data interface{}) error {
+	r.mu.Lock()
+	switch v := data.(type) {
+	case WSSearchResult:
+		r.entry.Result = &v
+	case WSResultUpdate:
+		r.entry.Judgments = append(r.entry.Judgments, HistoryJudgment{URL: v.URL, Verdict: v.Verdict, Reason: v.Reason})
+	case WSError:
+		r.entry.Error = &v
+	}
+	r.mu.Unlock()
+	return r.out.Send(msgType, data)
+}
+
+// Routes mounts the history endpoints.
+func (h *SearchHistory) Routes(r chi.Router) {
+	r.Get("/", h.handleList)
+	r.Delete("/", h.handleClear)
+	r.Get("/{id}", h.handleGet)
+	r.Delete("/{id}", h.handleDelete)
+}
+
+func (h *SearchHistory) handleList(w http.ResponseWriter, r *http.Request) {
+	if h == nil {
+		ErrorResponse(w, ErrHistoryDisabled)
+		return
+	}
+	q := HistoryQuery{
+		Before: r.URL.Query().Get("before"),
+		Text:   strings.TrimSpace(r.URL.Query().Get("q")),
+		Limit:  historyDefaultLimit,
+	}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > historyMaxLimit {
+			ErrorResponse(w, NewAppError(ErrInvalidRequest.Code, ErrInvalidRequest.Message,
+				fmt.Sprintf("limit must be between 1 and %d", historyMaxLimit), ErrInvalidRequest.Status))
+			return
+		}
+		q.Limit = limit
+	}
+
+	entries, next, err := h.db.List(principalName(principalFromContext(r.Context())), q)
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrHistoryStorage, err))
+		return
+	}
+	list := HistoryList{Entries: make([]HistorySummary, 0, len(entries)), Next: next}
+	for _, entry := range entries {
+		list.Entries = append(list.Entries, entry.summary())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+func (h *SearchHistory) handleGet(w http.ResponseWriter, r *http.Request) {
+	if h == nil {
+		ErrorResponse(w, ErrHistoryDisabled)
+		return
+	}
+	entry, err := h.db.Get(principalName(principalFromContext(r.Context())), chi.URLParam(r, "id"))
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrHistoryStorage, err))
+		return
+	}
+	if entry == nil {
+		ErrorResponse(w, ErrHistoryNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entry)
+}
+
+func (h *SearchHistory) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if h == nil {
+		ErrorResponse(w, ErrHistoryDisabled)
+		return
+	}
+	found, err := h.db.Delete(principalName(principalFromContext(r.Context())), chi.URLParam(r, "id"))
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrHistoryStorage, err))
+		return
+	}
+	if !found {
+		ErrorResponse(w, ErrHistoryNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleClear deletes the caller's whole history.
+func (h *SearchHistory) handleClear(w http.ResponseWriter, r *http.Request) {
+	if h == nil {
+		ErrorResponse(w, ErrHistoryDisabled)
+		return
+	}
+	removed, err := h.db.Clear(principalName(principalFromContext(r.Context())))
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrHistoryStorage, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"deleted": removed})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func newTestHistory(t *testing.T, cfg AppConfig) *SearchHistory {
+	t.Helper()
+	db, err := openBoltHistoryStore(filepath.Join(t.TempDir(), "data", "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	logger := NewLogger()
+	return NewSearchHistory(NewConfigStore("", cfg, logger), db, logger)
+}
+
+func historyIDs(entries []*HistoryEntry) []string {
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestHistoryRecordsChatSearch(t *testing.T) {
+	history := newTestHistory(t, defaultConfig())
+	ts := newTestChatServer(t, history)
+	resp := postChat(t, ts.URL, `{"messages":[{"role":"user","content":"When did Go get generics?"}],"search":{"queries":1}}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	entries, _, err := history.db.List("", HistoryQuery{Limit: 10})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one entry, got %d, %v", len(entries), err)
+	}
+	e := entries[0]
+	if e.Source != searchSourceChat || e.Outcome != "success" || e.Prompt != "When did Go get generics?" || e.Settings.Queries != 1 {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e.Result == nil || !reflect.DeepEqual(e.Result.Queries, []string{"golang generics"}) || len(e.Result.Results) != 1 {
+		t.Fatalf("expected the final result to be recorded, got %+v", e.Result)
+	}
+	want := []HistoryJudgment{{URL: "https://go.dev/generics", Verdict: verdictKept, Reason: reasonRelevant}}
+	if !reflect.DeepEqual(e.Judgments, want) {
+		t.Fatalf("unexpected judgments %+v", e.Judgments)
+	}
+	if e.Usage.TotalTokens != 34 || e.Usage.PromptTokens != 30 || e.Usage.Cost != 0.00012 {
+		t.Fatalf("unexpected usage %+v", e.Usage)
+	}
+}
+
+func TestBoltHistoryStorePagesAndPrunes(t *testing.T) {
+	db := newTestHistory(t, defaultConfig()).db
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		e := &HistoryEntry{ID: newHistoryID(start.Add(time.Duration(i) * time.Hour)), Prompt: fmt.Sprintf("Prompt %d", i)}
+		ids = append(ids, e.ID)
+		if err := db.Save("web", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Save("ops", &HistoryEntry{ID: newHistoryID(start), Prompt: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Новые записи первыми, курсор продолжает со следующей страницы
+	var got []string
+	next := ""
+	for page := 0; page < 3; page++ {
+		entries, cursor, err := db.List("web", HistoryQuery{Before: next, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, historyIDs(entries)...)
+		next = cursor
+	}
+	if want := []string{ids[4], ids[3], ids[2], ids[1], ids[0]}; !reflect.DeepEqual(got, want) || next != "" {
+		t.Fatalf("unexpected pages %v (next %q), want %v", got, next, want)
+	}
+	if entries, _, _ := db.List("web", HistoryQuery{Text: "prompt 3", Limit: 10}); !reflect.DeepEqual(historyIDs(entries), ids[3:4]) {
+		t.Fatalf("unexpected text filter result %v", historyIDs(entries))
+	}
+	if e, err := db.Get("ops", ids[0]); err != nil || e != nil {
+		t.Fatalf("expected entries to be scoped to their owner, got %+v, %v", e, err)
+	}
+
+	// Две записи web и запись ops старше отсечки, еще одна web сверх лимита
+	removed, err := db.Prune(start.Add(90*time.Minute), 2)
+	if err != nil || removed != 4 {
+		t.Fatalf("expected 4 pruned entries, got %d, %v", removed, err)
+	}
+	if entries, _, _ := db.List("web", HistoryQuery{Limit: 10}); !reflect.DeepEqual(historyIDs(entries), []string{ids[4], ids[3]}) {
+		t.Fatalf("unexpected entries after prune %v", historyIDs(entries))
+	}
+}
+
+func TestHistoryAPIIsScopedToKey(t *testing.T) {
+	history := newTestHistory(t, defaultConfig())
+	id := newHistoryID(time.Now())
+	if err := history.db.Save("web", &HistoryEntry{ID: id, Prompt: "golang generics", Outcome: "success"}); err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthenticator(testAuthConfig())
+	r := chi.NewRouter()
+	r.Use(AuthMiddleware(auth, ""))
+	r.Route("/api/history", history.Routes)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	do := func(method, path, key string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		req.Header.Set(apiKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	list := func(key string) HistoryList {
+		t.Helper()
+		var l HistoryList
+		_ = json.NewDecoder(do(http.MethodGet, "/api/history", key).Body).Decode(&l)
+		return l
+	}
+
+	if l := list("web-key-0123456789"); len(l.Entries) != 1 || l.Entries[0].ID != id || l.Entries[0].Prompt != "golang generics" {
+		t.Fatalf("unexpected list %+v", l)
+	}
+	if l := list("ops-key-0123456789"); len(l.Entries) != 0 {
+		t.Fatalf("expected another key to see nothing, got %+v", l)
+	}
+	if resp := do(http.MethodDelete, "/api/history/"+id, "ops-key-0123456789"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 deleting another key's entry, got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodGet, "/api/history?limit=0", "web-key-0123456789"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad limit, got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodDelete, "/api/history/"+id, "web-key-0123456789"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodGet, "/api/history/"+id, "web-key-0123456789"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", resp.StatusCode)
+	}
+
+	var disabled *SearchHistory
+	rec := httptest.NewRecorder()
+	disabled.handleList(rec, httptest.NewRequest(http.MethodGet, "/api/history", nil))
+	if rec.Code != http.StatusNotFound || !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("expected HISTORY_DISABLED, got %d %s", rec.Code, rec.Body.String())
+	}
+}
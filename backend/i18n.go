@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLocale is used when the client asks for no supported language. The
+// interface is in Russian, so status messages have always defaulted to it.
+const defaultLocale = "ru"
+
+// Коды статусных сообщений. Они стабильны и не зависят от языка, клиенты
+// могут строить по ним собственный текст из params.
+const (
+	msgQueued            = "queued"
+	msgGeneratingQueries = "generating_queries"
+	msgSearching         = "searching"
+	msgQueriesCompleted  = "queries_completed"
+	msgProcessing        = "processing"
+	msgAnalyzingContent  = "analyzing_content"
+	msgPagesAnalyzed     = "pages_analyzed"
+	msgAIFiltering       = "ai_filtering"
+	msgResultsEvaluated  = "results_evaluated"
+)
+
+// messageCatalog maps locale to message code to a template. Placeholders
+// in braces are filled from the status params.
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		msgQueued:            "Waiting for a free search slot...",
+		msgGeneratingQueries: "Generating search queries...",
+		msgSearching:         "Running search queries...",
+		msgQueriesCompleted:  "Queries completed: {completed}/{total}",
+		msgProcessing:        "Processing results...",
+		msgAnalyzingContent:  "Analyzing page content...",
+		msgPagesAnalyzed:     "Pages analyzed: {completed}/{total}",
+		msgAIFiltering:       "Filtering results with AI...",
+		msgResultsEvaluated:  "Results evaluated: {completed}/{total}",
+	},
+	"ru": {
+		msgQueued:            "Ожидание свободного слота поиска...",
+		msgGeneratingQueries: "Генерация поисковых запросов...",
+		msgSearching:         "Выполнение поисковых запросов...",
+		msgQueriesCompleted:  "Выполнено запросов: {completed}/{total}",
+		msgProcessing:        "Обработка результатов...",
+		msgAnalyzingContent:  "Анализ содержимого страниц...",
+		msgPagesAnalyzed:     "Проанализировано страниц: {completed}/{total}",
+		msgAIFiltering:       "ИИ-фильтрация результатов...",
+		msgResultsEvaluated:  "Проанализировано результатов: {completed}/{total}",
+	},
+}
+
+// localize renders the message for code in locale, falling back to the
+// default locale and finally to the code itself.
+func localize(locale, code string, params map[string]any) string {
+	tmpl, ok := messageCatalog[locale][code]
+	if !ok {
+		if tmpl, ok = messageCatalog[defaultLocale][code]; !ok {
+			return code
+		}
+	}
+	if len(params) == 0 {
+		return tmpl
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for k, v := range params {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(tmpl)
+}
+
+// supportedLocale maps a language tag such as "en-US" to a catalogue
+// locale, or returns "" when the language is not translated.
+func supportedLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if base, _, ok := strings.Cut(tag, "-"); ok {
+		tag = base
+	}
+	if _, ok := messageCatalog[tag]; ok {
+		return tag
+	}
+	return ""
+}
+
+// negotiateLocale picks the best supported locale from an Accept-Language
+// header, honouring q-values.
+func negotiateLocale(header string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if locale := supportedLocale(tag); locale != "" && q > 0 {
+			candidates = append(candidates, candidate{locale: locale, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return defaultLocale
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+type localeKey struct{}
+
+func contextWithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+func localeFromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok {
+		return locale
+	}
+	return defaultLocale
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMessageCatalogComplete(t *testing.T) {
+	for code := range messageCatalog[defaultLocale] {
+		for locale, messages := range messageCatalog {
+			if _, ok := messages[code]; !ok {
+				t.Errorf("locale %s misses message %s", locale, code)
+			}
+		}
+	}
+	for locale, messages := range messageCatalog {
+		if len(messages) != len(messageCatalog[defaultLocale]) {
+			t.Errorf("locale %s has messages missing from %s", locale, defaultLocale)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	params := map[string]any{"completed": 2, "total": 5}
+	if got := localize("en", msgQueriesCompleted, params); got != "Queries completed: 2/5" {
+		t.Fatalf("unexpected english message %q", got)
+	}
+	if got := localize("ru", msgQueriesCompleted, params); got != "Выполнено запросов: 2/5" {
+		t.Fatalf("unexpected russian message %q", got)
+	}
+	if got := localize("de", msgProcessing, nil); got != "Обработка результатов..." {
+		t.Fatalf("expected fallback to russian, got %q", got)
+	}
+	if got := localize("en", "unknown_code", nil); got != "unknown_code" {
+		t.Fatalf("expected code as last resort, got %q", got)
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	tests := map[string]string{
+		"":                               "ru",
+		"ru-RU,ru;q=0.9,en-US;q=0.8":     "ru",
+		"de-DE,en;q=0.5,ru;q=0.7":        "ru",
+		"fr, de":                         "ru",
+		"en-GB;q=0.2, ru;q=0":            "en",
+		"RU":                             "ru",
+		"ru;q=bogus, en;q=0.1":           "en",
+		"en-US,en;q=0.9,ru-RU;q=0.8,ru;": "en",
+	}
+	for header, want := range tests {
+		if got := negotiateLocale(header); got != want {
+			t.Errorf("negotiateLocale(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestSendSafeStatusIncludesCodeAndParams(t *testing.T) {
+	conn, msgs := newRecordingConn(t)
+	ctx := contextWithLocale(context.Background(), "ru")
+	sendSafeStatus(ctx, conn, "searching", msgQueriesCompleted, 1, 3, map[string]any{"completed": 1, "total": 3})
+
+	msg := <-msgs
+	if !strings.Contains(msg, `"code":"queries_completed"`) || !strings.Contains(msg, `"params":{"completed":1,"total":3}`) ||
+		!strings.Contains(msg, `"locale":"ru"`) || !strings.Contains(msg, "Выполнено запросов: 1/3") {
+		t.Fatalf("unexpected status message %s", msg)
+	}
+}
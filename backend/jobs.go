@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Состояния фоновой задачи
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// Состояния доставки callback
+const (
+	callbackPending   = "pending"
+	callbackDelivered = "delivered"
+	callbackFailed    = "failed"
+)
+
+const (
+	jobSignatureHeader = "X-Signature-256"
+	jobTimestampHeader = "X-Signature-Timestamp"
+	jobIDHeader        = "X-Job-ID"
+)
+
+var (
+	ErrJobNotFound = &AppError{
+		Code:    "JOB_NOT_FOUND",
+		Message: "Job was not found or has expired",
+		Status:  http.StatusNotFound,
+	}
+	ErrTooManyJobs = &AppError{
+		Code:    "TOO_MANY_JOBS",
+		Message: "Too many jobs are stored, retry later",
+		Status:  http.StatusServiceUnavailable,
+	}
+	ErrInvalidCallback = &AppError{
+		Code:    "INVALID_CALLBACK",
+		Message: "Callback URL is not acceptable",
+		Status:  http.StatusBadRequest,
+	}
+)
+
+// JobRequest is the body of POST /api/jobs.
+type JobRequest struct {
+	Prompt   string   `json:"prompt"`
+	Settings Settings `json:"settings"`
+	Locale   string   `json:"locale,omitempty"`
+	// CallbackURL receives the finished job as a signed POST
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// JobView is the JSON representation of a job returned by the API and sent
+// to callbacks. Progress mirrors the latest WebSocket status message.
+type JobView struct {
+	ID             string          `json:"id"`
+	State          string          `json:"state"`
+	Progress       *WSSearchStatus `json:"progress,omitempty"`
+	Result         *WSSearchResult `json:"result,omitempty"`
+	Error          *WSError        `json:"error,omitempty"`
+	CallbackURL    string          `json:"callback_url,omitempty"`
+	CallbackStatus string          `json:"callback_status,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Job is a search running in the background. It implements messageSink and
+// keeps only the latest status and the outcome of the pipeline.
+type Job struct {
+	id          string
+	principal   string
+	callbackURL string
+	cancel      context.CancelFunc
+
+	mu             sync.Mutex
+	state          string
+	progress       *WSSearchStatus
+	result         *WSSearchResult
+	err            *WSError
+	callbackStatus string
+	createdAt      time.Time
+	finishedAt     time.Time
+}
+
+func (j *Job) Send(msgType string, data interface{}) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch v := data.(type) {
+	case WSSearchStatus:
+		j.progress = &v
+	case WSSearchResult:
+		j.result = &v
+	case WSError:
+		j.err = &v
+	}
+	return nil
+}
+
+func (j *Job) view() JobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	v := JobView{
+		ID:             j.id,
+		State:          j.state,
+		Progress:       j.progress,
+		Result:         j.result,
+		Error:          j.err,
+		CallbackURL:    j.callbackURL,
+		CallbackStatus: j.callbackStatus,
+		CreatedAt:      j.createdAt,
+	}
+	if !j.finishedAt.IsZero() {
+		finished := j.finishedAt
+		v.FinishedAt = &finished
+	}
+	return v
+}
+
+// JobManager runs searches submitted through /api/jobs and keeps their state
+// in memory for polling. Jobs count as searches for graceful shutdown.
+type JobManager struct {
+	store   *ConfigStore
+	auth    *Authenticator
+	limiter *RateLimiter
+	conns   *ConnManager
+	slots   *SearchSlots
+	history *SearchHistory
+	logger  *Logger
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	now  func() time.Time
+}
+
+func NewJobManager(store *ConfigStore, auth *Authenticator, limiter *RateLimiter, conns *ConnManager, slots *SearchSlots, history *SearchHistory, logger *Logger) *JobManager {
+	return &JobManager{
+		store:   store,
+		auth:    auth,
+		limiter: limiter,
+		conns:   conns,
+		slots:   slots,
+		history: history,
+		logger:  logger,
+		jobs:    make(map[string]*Job),
+		now:     time.Now,
+	}
+}
+
+// Routes mounts the job endpoints.
+func (m *JobManager) Routes(r chi.Router) {
+	r.Post("/", m.handleCreate)
+	r.Get("/{id}", m.handleGet)
+	r.Delete("/{id}", m.handleDelete)
+}
+
+func (m *JobManager) handleCreate(w http.ResponseWriter, r *http.Request) {
+	cfg := m.store.Load()
+	principal := principalFromContext(r.Context())
+
+	var req JobRequest
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.WebSocket.MaxMessageSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ErrorResponse(w, WrapError(ErrInvalidRequest, err))
+		return
+	}
+	searchReq := SearchRequest{Prompt: req.Prompt, Settings: req.Settings}
+	SanitizeSearchRequest(&searchReq)
+	// В отличие от WebSocket клиента, скрипты могут не указывать число запросов
+	if searchReq.Settings.Queries == 0 {
+		searchReq.Settings.Queries = cfg.Search.DefaultQueryCount
+	}
+	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
+		ErrorResponse(w, NewAppError("VALIDATION_FAILED", "Request validation failed", validationErrors.Error(), http.StatusBadRequest))
+		return
+	}
+	if req.CallbackURL != "" {
+		if appErr := validateCallbackURL(req.CallbackURL, cfg); appErr != nil {
+			ErrorResponse(w, appErr)
+			return
+		}
+	}
+
+	if ok, retryAfter := m.limiter.Allow(rateLimitKey(principal, r, cfg.RateLimit), cfg.RateLimit); !ok {
+		searchesTotal.WithLabelValues("rate_limited").Inc()
+		ErrorResponse(w, rateLimitedError(fmt.Sprintf("search rate limit of %g per minute exceeded", cfg.RateLimit.SearchesPerMinute), retryAfter))
+		return
+	}
+	if !m.conns.BeginSearch() {
+		searchesTotal.WithLabelValues("shutting_down").Inc()
+		ErrorResponse(w, ErrShuttingDown)
+		return
+	}
+
+	locale := negotiateLocale(r.Header.Get("Accept-Language"))
+	if l := supportedLocale(req.Locale); l != "" {
+		locale = l
+	}
+	// Задача живет дольше HTTP запроса, но сохраняет его trace и принципала
+	ctx := contextWithLocale(context.WithoutCancel(r.Context()), locale)
+	ctx, cancel := m.conns.SearchContext(ctx, cfg.WebSocket.SearchTimeout)
+
+	job, appErr := m.add(principalName(principal), req.CallbackURL, cancel, cfg.Jobs.MaxStored)
+	if appErr == nil {
+		if appErr = m.auth.BeginSearch(principal); appErr != nil {
+			searchesTotal.WithLabelValues("quota_exceeded").Inc()
+			m.remove(job.id)
+		}
+	}
+	if appErr != nil {
+		cancel()
+		m.conns.EndSearch()
+		ErrorResponse(w, appErr)
+		return
+	}
+	go m.run(ctx, job, searchReq, cfg)
+
+	m.logger.Info("job created", "job_id", job.id, "api_key", job.principal, "callback", req.CallbackURL != "")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+job.id)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job.view())
+}
+
+func (m *JobManager) handleGet(w http.ResponseWriter, r *http.Request) {
+	job := m.lookup(chi.URLParam(r, "id"), principalName(principalFromContext(r.Context())))
+	if job == nil {
+		ErrorResponse(w, ErrJobNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job.view())
+}
+
+// handleDelete cancels a running job; a finished job is removed.
+func (m *JobManager) handleDelete(w http.ResponseWriter, r *http.Request) {
+	job := m.lookup(chi.URLParam(r, "id"), principalName(principalFromContext(r.Context())))
+	if job == nil {
+		ErrorResponse(w, ErrJobNotFound)
+		return
+	}
+
+	job.mu.Lock()
+	running := job.finishedAt.IsZero()
+	if running {
+		job.state = jobCanceled
+	}
+	job.mu.Unlock()
+
+	if !running {
+		m.remove(job.id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	job.cancel()
+	m.logger.Info("job canceled", "job_id", job.id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job.view())
+}
+
+// run executes the job's search, records its final state and delivers the
+// callback, if any.
+func (m *JobManager) run(ctx context.Context, job *Job, searchReq SearchRequest, cfg AppConfig) {
+	defer m.conns.EndSearch()
+	defer job.cancel()
+
+	logger := &Logger{Logger: m.logger.With("job_id", job.id)}
+	outcome := executeSearch(ctx, job, m.auth, m.slots, m.history, searchSourceJob, searchReq, cfg, logger, attribute.String("job.id", job.id))
+
+	job.mu.Lock()
+	switch {
+	case job.state == jobCanceled:
+		// отменена через DELETE, результат не нужен
+		job.result = nil
+	case outcome == "success":
+		job.state = jobCompleted
+	default:
+		job.state = jobFailed
+		switch {
+		case job.err != nil:
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			job.err = &WSError{Code: "TIMEOUT", Message: "Search did not finish in time"}
+		default:
+			job.err = &WSError{Code: ErrShuttingDown.Code, Message: ErrShuttingDown.Message}
+		}
+	}
+	job.finishedAt = m.now()
+	if job.callbackURL != "" {
+		job.callbackStatus = callbackPending
+	}
+	state := job.state
+	job.mu.Unlock()
+
+	logger.Info("job finished", "state", state)
+	if job.callbackURL != "" {
+		// Доставка держит слот дренажа, поэтому прерывается вместе с поисками
+		m.deliverCallback(m.conns.AbortContext(), job, cfg, logger)
+	}
+}
+
+// executeSearch runs an already charged search outside of a WebSocket
+// connection: it opens the root span, counts the outcome, records the search
+// in history under source and charges the spent tokens to the principal in
+// ctx.
+func executeSearch(ctx context.Context, out messageSink, auth *Authenticator, slots *SearchSlots, history *SearchHistory, source string, searchReq SearchRequest, cfg AppConfig, logger *Logger, attrs ...attribute.KeyValue) string {
+	usage := &tokenUsage{}
+	ctx = contextWithTokenUsage(ctx, usage)
+	defer func() { auth.AddTokens(principalFromContext(ctx), usage.total.Load()) }()
+
+	ctx, span := tracer.Start(ctx, "search")
+	span.SetAttributes(append(attrs, attribute.String("search.source", source))...)
+	out, record := history.track(ctx, out, source, searchReq)
+	outcome := runSearch(ctx, out, slots, searchReq, cfg, logger)
+	record(outcome)
+	finishSearchSpan(ctx, span, outcome)
+	return outcome
+}
+
+// deliverCallback POSTs the finished job to its callback URL.
+func (m *JobManager) deliverCallback(ctx context.Context, job *Job, cfg AppConfig, logger *Logger) {
+	body, err := json.Marshal(job.view())
+	if err != nil {
+		logger.Error("failed to encode job callback", "error", err)
+		return
+	}
+
+	status := callbackFailed
+	if deliverSigned(ctx, job.callbackURL, http.Header{jobIDHeader: {job.id}}, body, cfg, m.now, logger) {
+		status = callbackDelivered
+	}
+	jobCallbacksTotal.WithLabelValues(status).Inc()
+
+	job.mu.Lock()
+	job.callbackStatus = status
+	job.mu.Unlock()
+}
+
+// deliverSigned POSTs body to target signed with cfg.Jobs.CallbackSecret,
+// with header added to the request, retrying network errors and 5xx/429
+// responses with exponential backoff until ctx is done. Redirects are not
+// followed and internal addresses are refused unless
+// cfg.Outbound.AllowPrivateNetworks is set. It reports whether an attempt
+// succeeded.
+func deliverSigned(ctx context.Context, target string, header http.Header, body []byte, cfg AppConfig, now func() time.Time, logger *Logger) bool {
+	client := newOutboundClient(0, cfg.Outbound)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	backoff := time.Second
+	for attempt := 1; attempt <= cfg.Jobs.CallbackAttempts; attempt++ {
+		retry, err := postSigned(ctx, client, target, header, body, cfg.Jobs, now)
+		if err == nil {
+			return true
+		}
+		logger.Warn("callback delivery failed", "attempt", attempt, "error", err)
+		if !retry || attempt == cfg.Jobs.CallbackAttempts {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Warn("callback delivery abandoned", "error", ctx.Err())
+			return false
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+	return false
+}
+
+// postSigned makes a single delivery attempt and reports whether a failure
+// is worth retrying.
+func postSigned(ctx context.Context, client *http.Client, target string, header http.Header, body []byte, cfg JobsConfig, now func() time.Time) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.CallbackTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	for name, values := range header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(jobTimestampHeader, timestamp)
+	req.Header.Set(jobSignatureHeader, signCallback(cfg.CallbackSecret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Запрещенный адрес не станет разрешенным при повторе
+		return !errors.Is(err, errPrivateAddress), err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("callback returned status %d", resp.StatusCode)
+}
+
+// signCallback returns the X-Signature-256 value: an HMAC-SHA256 over
+// "<timestamp>.<body>". Including the timestamp lets receivers reject
+// replayed deliveries.
+func signCallback(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validateCallbackURL(raw string, cfg AppConfig) *AppError {
+	if cfg.Jobs.CallbackSecret == "" {
+		return NewAppError(ErrInvalidCallback.Code, ErrInvalidCallback.Message,
+			"callbacks are disabled: jobs.callback_secret is not set", ErrInvalidCallback.Status)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return NewAppError(ErrInvalidCallback.Code, ErrInvalidCallback.Message,
+			fmt.Sprintf("%q is not an absolute http(s) URL", raw), ErrInvalidCallback.Status)
+	}
+	if err := checkPublicHost(u.Hostname(), cfg.Outbound); err != nil {
+		return NewAppError(ErrInvalidCallback.Code, ErrInvalidCallback.Message,
+			fmt.Sprintf("%q: %v", raw, err), ErrInvalidCallback.Status)
+	}
+	return nil
+}
+
+// add registers a new running job unless maxStored jobs already exist.
+func (m *JobManager) add(principal, callbackURL string, cancel context.CancelFunc, maxStored int) (*Job, *AppError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked()
+	if len(m.jobs) >= maxStored {
+		return nil, ErrTooManyJobs
+	}
+	job := &Job{
+		id:          randomHex(12),
+		principal:   principal,
+		callbackURL: callbackURL,
+		cancel:      cancel,
+		state:       jobRunning,
+		createdAt:   m.now(),
+	}
+	m.jobs[job.id] = job
+	return job, nil
+}
+
+// lookup returns the job with id if it belongs to principal.
+func (m *JobManager) lookup(id, principal string) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked()
+	job, ok := m.jobs[id]
+	if !ok || job.principal != principal {
+		return nil
+	}
+	return job
+}
+
+func (m *JobManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+}
+
+// sweepLocked drops finished jobs older than the retention period.
+func (m *JobManager) sweepLocked() {
+	cutoff := m.now().Add(-m.store.Load().Jobs.Retention)
+	for id, job := range m.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && job.finishedAt.Before(cutoff)
+		job.mu.Unlock()
+		if expired {
+			delete(m.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const testCallbackSecret = "callback-secret"
+
+// newTestJobServer serves the job API with auth enabled and a second
+// search-scoped key, "team". OpenRouter has no key configured, so every job
+// fails right after query generation starts. Callbacks may reach loopback
+// test receivers.
+func newTestJobServer(t *testing.T) (*httptest.Server, *JobManager) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Auth = testAuthConfig()
+	cfg.Auth.Keys = append(cfg.Auth.Keys, APIKeyConfig{Name: "team", Key: "team-key-0123456789", Scopes: []string{scopeSearch}})
+	cfg.Jobs.CallbackSecret = testCallbackSecret
+	cfg.Outbound.AllowPrivateNetworks = true
+	logger := NewLogger()
+	store := NewConfigStore("", cfg, logger)
+	auth := NewAuthenticator(cfg.Auth)
+	jobs := NewJobManager(store, auth, NewRateLimiter(), NewConnManager(), NewSearchSlots(), nil, logger)
+
+	r := chi.NewRouter()
+	r.Use(AuthMiddleware(auth, ""))
+	r.With(RequireScope(scopeSearch)).Route("/api/jobs", jobs.Routes)
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts, jobs
+}
+
+func doJobRequest(t *testing.T, method, url, key, body string) (*http.Response, JobView) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set(apiKeyHeader, key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	var view JobView
+	_ = json.NewDecoder(resp.Body).Decode(&view)
+	return resp, view
+}
+
+func TestJobLifecycleWithSignedCallback(t *testing.T) {
+	ts, _ := newTestJobServer(t)
+
+	delivered := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		delivered <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	resp, created := doJobRequest(t, http.MethodPost, ts.URL+"/api/jobs", "web-key-0123456789",
+		`{"prompt": "golang generics", "callback_url": "`+receiver.URL+`"}`)
+	if resp.StatusCode != http.StatusAccepted || created.ID == "" {
+		t.Fatalf("expected 202 with a job ID, got %d %+v", resp.StatusCode, created)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/api/jobs/"+created.ID {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+
+	var req *http.Request
+	select {
+	case req = <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not delivered")
+	}
+	body := <-bodies
+	want := signCallback(testCallbackSecret, req.Header.Get(jobTimestampHeader), body)
+	if got := req.Header.Get(jobSignatureHeader); got != want {
+		t.Fatalf("bad signature %q, want %q", got, want)
+	}
+	var payload JobView
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ID != created.ID || payload.State != jobFailed {
+		t.Fatalf("unexpected callback payload %s", body)
+	}
+	if payload.Error == nil || payload.Error.Code != "QUERY_GENERATION_FAILED" {
+		t.Fatalf("expected query generation error, got %+v", payload.Error)
+	}
+
+	// Чужой ключ не видит задачу
+	if resp, _ := doJobRequest(t, http.MethodGet, ts.URL+"/api/jobs/"+created.ID, "team-key-0123456789", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected another key's job to be hidden, got %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, polled := doJobRequest(t, http.MethodGet, ts.URL+"/api/jobs/"+created.ID, "web-key-0123456789", "")
+		if polled.CallbackStatus == callbackDelivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected delivered callback status, got %+v", polled)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if resp, _ := doJobRequest(t, http.MethodDelete, ts.URL+"/api/jobs/"+created.ID, "web-key-0123456789", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected finished job to be removed, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJobRequest(t, http.MethodGet, ts.URL+"/api/jobs/"+created.ID, "web-key-0123456789", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", resp.StatusCode)
+	}
+}
+
+func TestJobRejectsCallbackWithoutSecret(t *testing.T) {
+	if appErr := validateCallbackURL("https://example.com/hook", AppConfig{}); appErr == nil || appErr.Code != ErrInvalidCallback.Code {
+		t.Fatalf("expected callbacks to be refused without a secret, got %v", appErr)
+	}
+	cfg := AppConfig{Jobs: JobsConfig{CallbackSecret: "s"}}
+	for _, raw := range []string{
+		"ftp://example.com/hook", "/relative", "https://",
+		"http://localhost:8080/hook", "http://127.0.0.1/hook", "http://[::1]/hook",
+		"http://10.0.0.5/hook", "http://169.254.169.254/latest/meta-data/", "http://0.0.0.0/hook",
+	} {
+		if appErr := validateCallbackURL(raw, cfg); appErr == nil {
+			t.Errorf("expected %q to be refused", raw)
+		}
+	}
+	if appErr := validateCallbackURL("https://example.com/hook", cfg); appErr != nil {
+		t.Fatalf("expected valid callback URL, got %v", appErr)
+	}
+	cfg.Outbound.AllowPrivateNetworks = true
+	if appErr := validateCallbackURL("http://10.0.0.5/hook", cfg); appErr != nil {
+		t.Fatalf("expected private callback URL to be allowed, got %v", appErr)
+	}
+}
+
+func TestCallbackDeliveryRefusesInternalAddresses(t *testing.T) {
+	var hits atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer receiver.Close()
+	// Адрес проверяется после резолва имени, а не только в URL
+	target := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+
+	cfg := defaultConfig()
+	cfg.Jobs.CallbackSecret = testCallbackSecret
+	if deliverSigned(context.Background(), target, nil, []byte("{}"), cfg, time.Now, NewLogger()) {
+		t.Fatal("expected delivery to a loopback address to fail")
+	}
+	if hits.Load() != 0 {
+		t.Fatal("expected the receiver not to be contacted")
+	}
+
+	redirector := httptest.NewServer(http.RedirectHandler(receiver.URL, http.StatusFound))
+	defer redirector.Close()
+	cfg.Outbound.AllowPrivateNetworks = true
+	if deliverSigned(context.Background(), redirector.URL, nil, []byte("{}"), cfg, time.Now, NewLogger()) || hits.Load() != 0 {
+		t.Fatal("expected callback redirects not to be followed")
+	}
+}
+
+func TestCallbackRetryStopsWithContext(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	cfg := defaultConfig()
+	cfg.Jobs.CallbackSecret = testCallbackSecret
+	cfg.Jobs.CallbackAttempts = 10
+	cfg.Outbound.AllowPrivateNetworks = true
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if deliverSigned(ctx, receiver.URL, nil, []byte("{}"), cfg, time.Now, NewLogger()) {
+		t.Fatal("expected delivery to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected backoff to stop with the context, took %s", elapsed)
+	}
+}
+
+func TestJobRequestValidation(t *testing.T) {
+	ts, jobs := newTestJobServer(t)
+
+	resp, _ := doJobRequest(t, http.MethodPost, ts.URL+"/api/jobs", "web-key-0123456789", `{"prompt": ""}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty prompt, got %d", resp.StatusCode)
+	}
+	if len(jobs.jobs) != 0 {
+		t.Fatalf("rejected request must not create a job, got %d", len(jobs.jobs))
+	}
+}
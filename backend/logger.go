@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
@@ -26,7 +27,12 @@ func NewLogger() *Logger {
 	if os.Getenv("DEBUG") == "true" {
 		level = slog.LevelDebug
 	}
+	return newLogger(os.Stdout, level)
+}
 
+// newLogger пишет в w начиная с level. CLI пишет логи в stderr, чтобы они не
+// смешивались с результатами в stdout.
+func newLogger(w io.Writer, level slog.Level) *Logger {
 	opts := &slog.HandlerOptions{
 		Level:     level,
 		AddSource: level == slog.LevelDebug,
@@ -34,9 +40,9 @@ func NewLogger() *Logger {
 
 	var handler slog.Handler
 	if os.Getenv("LOG_FORMAT") == "json" {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+		handler = slog.NewJSONHandler(w, opts)
 	} else {
-		handler = slog.NewTextHandler(os.Stdout, opts)
+		handler = slog.NewTextHandler(w, opts)
 	}
 
 	return &Logger{
@@ -123,6 +129,18 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush реализует http.Flusher для потоковых ответов
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap дает http.ResponseController доступ к исходному writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Hijack реализует интерфейс http.Hijacker для поддержки WebSocket
 func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
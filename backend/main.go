@@ -2,39 +2,163 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
-	cfg := loadConfig()
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to a subcommand. Without one the binary serves, so
+// existing deployments that pass only flags keep working.
+func run(args []string) int {
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve":
+		return runServe(args)
+	case "search":
+		return runSearchCommand(args, os.Stdout, os.Stderr)
+	case "batch":
+		return runBatchCommand(args, os.Stdin, os.Stdout, os.Stderr)
+	case "mcp":
+		return runMCPCommand(args, os.Stdin, os.Stdout, os.Stderr)
+	case "help":
+		printUsage(os.Stdout)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+}
+
+// runServe starts the HTTP and WebSocket server and blocks until SIGINT or
+// SIGTERM.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	printCfg := fs.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	cfg, cfgErr := loadConfig(*configPath)
+	if *printCfg {
+		if err := printConfig(os.Stdout, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to print config:", err)
+			return exitFailure
+		}
+	}
+	if cfgErr != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", cfgErr)
+		return exitFailure
+	}
+	if *printCfg {
+		return exitOK
+	}
+
 	logger := NewLogger()
+	store := NewConfigStore(*configPath, cfg, logger)
+	auth := NewAuthenticator(cfg.Auth)
+	limiter := NewRateLimiter()
+	conns := NewConnManager()
+	slots := NewSearchSlots()
+	history, err := OpenSearchHistory(store, logger)
+	if err != nil {
+		logger.Error("failed to open search history", "error", err)
+		return exitFailure
+	}
+	defer history.Close()
+	saved, err := OpenSavedSearches(store, auth, conns, slots, history, logger)
+	if err != nil {
+		logger.Error("failed to open saved searches", "error", err)
+		return exitFailure
+	}
+	jobs := NewJobManager(store, auth, limiter, conns, slots, history, logger)
+	if !cfg.Auth.Enabled {
+		logger.Warn("API key authentication is disabled, the backend is open to anyone who can reach it")
+	}
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	savedDone := make(chan struct{})
+	defer func() {
+		stopBackground()
+		// Запуски сохраненных поисков пишут в базу, закрываем ее после них
+		<-savedDone
+		_ = saved.Close()
+	}()
+
+	shutdownTracing, err := initTracing(bgCtx, cfg)
+	if err != nil {
+		logger.Error("failed to init tracing, spans will not be exported", "error", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+	activateSearxPool(bgCtx, cfg, logger)
+	sessions := NewSearchSessions()
+	go sessions.Sweep(bgCtx)
+	go history.Sweep(bgCtx)
+	go func() {
+		defer close(savedDone)
+		saved.Run(bgCtx)
+	}()
+
+	// Горячая перезагрузка конфигурации по SIGHUP и изменению файла
+	go store.Watch(bgCtx, cfg.Server.ConfigWatchInterval, func(next AppConfig) {
+		activateSearxPool(bgCtx, next, logger)
+		auth.Update(next.Auth)
+	})
 
 	// Создаем главный роутер
 	mainRouter := http.NewServeMux()
 
 	// Отдельный обработчик для WebSocket без middleware
-	mainRouter.HandleFunc("/api/ws/search", func(w http.ResponseWriter, r *http.Request) {
-		handleWebSocketSearch(w, r, cfg, logger)
+	mainRouter.Handle("/api/ws/search", &WSHandler{
+		store:    store,
+		auth:     auth,
+		limiter:  limiter,
+		conns:    conns,
+		sessions: sessions,
+		slots:    slots,
+		history:  history,
+		logger:   logger,
 	})
 
+	// Метрики Prometheus отдаем без логирования каждого scrape
+	if cfg.Metrics.Enabled {
+		mainRouter.Handle(cfg.Metrics.Path, promhttp.Handler())
+	}
+
 	// Chi роутер для остальных эндпоинтов с middleware
 	r := chi.NewRouter()
 	r.Use(RecoveryMiddleware)
+	r.Use(otelhttp.NewMiddleware("http.server", otelhttp.WithFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/healthz"
+	})))
 	r.Use(LoggingMiddleware(logger))
 
 	// CORS для обычных HTTP эндпоинтов
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowOriginFunc: func(r *http.Request, origin string) bool {
+			return NewOriginPolicy(store.Load().WebSocket).Allowed(origin)
+		},
+		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", apiKeyHeader},
+		ExposedHeaders:   []string{"Link", "Location", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
@@ -44,6 +168,53 @@ func main() {
 		_, _ = w.Write([]byte("ok"))
 	})
 
+	// Браузер добавляет агрегатор как поисковую систему и не передает API ключ
+	openSearch := NewOpenSearchHandler(store, auth, logger)
+	r.Get("/opensearch.xml", openSearch.handleDescription)
+	r.Get("/search", openSearch.handleSearch)
+
+	r.Route("/api", func(r chi.Router) {
+		r.Get("/suggest", openSearch.handleSuggest)
+		// Ридеры лент не передают API ключ, доступ дает токен в URL
+		r.Get("/feeds/{token}", saved.handleFeed)
+		// Остальные эндпоинты /api требуют API ключ, когда аутентификация включена
+		r.Group(func(r chi.Router) {
+			r.Use(AuthMiddleware(auth, ""))
+			r.Get("/quota", handleQuota(auth))
+			r.Group(func(r chi.Router) {
+				r.Use(RequireScope(scopeSearch))
+				r.Route("/jobs", jobs.Routes)
+				r.Route("/history", history.Routes)
+				r.Route("/saved-searches", saved.Routes)
+				r.Handle("/mcp", NewMCPServer(store, auth, limiter, conns, slots, history, logger))
+				r.Method(http.MethodPost, "/batch", &BatchHandler{
+					store:   store,
+					auth:    auth,
+					limiter: limiter,
+					conns:   conns,
+					slots:   slots,
+					history: history,
+					logger:  logger,
+				})
+			})
+		})
+	})
+
+	// OpenAI-совместимый API для клиентов, которые умеют только chat completions
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(AuthMiddleware(auth, ""))
+		r.Use(RequireScope(scopeSearch))
+		(&ChatHandler{
+			store:   store,
+			auth:    auth,
+			limiter: limiter,
+			conns:   conns,
+			slots:   slots,
+			history: history,
+			logger:  logger,
+		}).Routes(r)
+	})
+
 	// Монтируем chi роутер для всех путей кроме WebSocket
 	mainRouter.Handle("/", r)
 
@@ -70,22 +241,34 @@ func main() {
 	<-quit
 
 	logger.Info("shutting down server")
+
+	// Сначала даем завершиться поискам: Shutdown не отслеживает WebSocket соединения
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	conns.Drain(drainCtx, logger)
+	cancelDrain()
+
+	// Оркестратор должен ждать DrainTimeout плюс этот таймаут (stop_grace_period в docker-compose.yml)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Error("server forced to shutdown", "error", err)
-		os.Exit(1)
+		return exitFailure
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("failed to flush traces", "error", err)
 	}
 
 	logger.Info("server exited")
+	return exitOK
 }
 
+// truncateStr cuts s to max characters, never inside a UTF-8 sequence.
 func truncateStr(s string, max int) string {
-	if len(s) <= max {
-		return s
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max]) + "…"
 	}
-	return s[:max] + "…"
+	return s
 }
 
 func sampleStrings(in []string, n int) []string {
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Model Context Protocol. The server is stateless: it only offers tools, so
+// there is nothing to keep between requests besides in-flight calls.
+const (
+	mcpProtocolVersion = "2025-06-18"
+	mcpServerName      = "ai-search-aggregator"
+	mcpDefaultMaxChars = 20000
+)
+
+// mcpProtocolVersions are the revisions the server can speak, newest first.
+var mcpProtocolVersions = []string{mcpProtocolVersion, "2025-03-26", "2024-11-05"}
+
+// Коды ошибок JSON-RPC 2.0
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// Инструменты MCP
+const (
+	mcpToolWebSearch       = "web_search"
+	mcpToolFetchPage       = "fetch_page"
+	mcpToolGenerateQueries = "generate_queries"
+)
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response. A request
+// has an ID and a method, a notification only a method.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func rpcResponse(id json.RawMessage, result any) *rpcMessage {
+	return &rpcMessage{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) *rpcMessage {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcMessage{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
+}
+
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpToolResult is the result of tools/call. Failures of the tool itself are
+// reported with IsError so the model can see them, not as protocol errors.
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent any          `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError,omitempty"`
+}
+
+func mcpText(text string) mcpToolResult {
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: text}}}
+}
+
+func mcpToolError(format string, args ...any) mcpToolResult {
+	res := mcpText(fmt.Sprintf(format, args...))
+	res.IsError = true
+	return res
+}
+
+func mcpAppError(appErr *AppError) mcpToolResult {
+	if appErr.Details != "" {
+		return mcpToolError("%s: %s: %s", appErr.Code, appErr.Message, appErr.Details)
+	}
+	return mcpToolError("%s: %s", appErr.Code, appErr.Message)
+}
+
+type mcpCallParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+	Meta      struct {
+		ProgressToken json.RawMessage `json:"progressToken,omitempty"`
+	} `json:"_meta"`
+}
+
+type mcpWebSearchArgs struct {
+	Prompt      string   `json:"prompt"`
+	Queries     int      `json:"queries"`
+	ContentMode bool     `json:"content_mode"`
+	Engines     []string `json:"engines"`
+	Categories  []string `json:"categories"`
+	TimeRange   string   `json:"time_range"`
+	Locale      string   `json:"locale"`
+}
+
+type mcpFetchPageArgs struct {
+	URL      string `json:"url"`
+	MaxChars int    `json:"max_chars"`
+}
+
+type mcpGenerateQueriesArgs struct {
+	Prompt string `json:"prompt"`
+	Count  int    `json:"count"`
+}
+
+// mcpPage is the structured result of fetch_page.
+type mcpPage struct {
+	URL       string        `json:"url"`
+	Page      *PageDocument `json:"page"`
+	Text      string        `json:"text"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name: mcpToolWebSearch,
+		Description: "Search the web for a natural-language prompt. The aggregator generates search queries, " +
+			"runs them through SearxNG and keeps only results an LLM judged relevant. Returns ranked results as Markdown.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"prompt":       map[string]any{"type": "string", "description": "What to search for"},
+				"queries":      map[string]any{"type": "integer", "minimum": 1, "description": "Number of search queries to generate"},
+				"content_mode": map[string]any{"type": "boolean", "description": "Judge relevance by page content instead of snippets; slower but more precise"},
+				"engines":      map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "SearxNG engines to use"},
+				"categories":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "SearxNG categories to use"},
+				"time_range":   map[string]any{"type": "string", "enum": []string{"day", "week", "month", "year"}},
+				"locale":       map[string]any{"type": "string", "description": "Language of progress messages"},
+			},
+			"required": []string{"prompt"},
+		},
+	},
+	{
+		Name:        mcpToolFetchPage,
+		Description: "Download a web page and return its readable text and metadata.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"url":       map[string]any{"type": "string", "description": "Absolute http(s) URL"},
+				"max_chars": map[string]any{"type": "integer", "minimum": 1, "description": fmt.Sprintf("Truncate the text to this many characters (default %d)", mcpDefaultMaxChars)},
+			},
+			"required": []string{"url"},
+		},
+	},
+	{
+		Name:        mcpToolGenerateQueries,
+		Description: "Generate web search queries for a natural-language prompt without running them.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"prompt": map[string]any{"type": "string", "description": "What to search for"},
+				"count":  map[string]any{"type": "integer", "minimum": 1, "description": "Number of queries"},
+			},
+			"required": []string{"prompt"},
+		},
+	},
+}
+
+// notifyFunc sends a JSON-RPC notification to the client of the current
+// request. Transports that cannot push messages pass a no-op.
+type notifyFunc func(method string, params any)
+
+// MCPServer exposes the search pipeline as Model Context Protocol tools over
+// stdio (ServeStdio) or streamable HTTP (ServeHTTP).
+type MCPServer struct {
+	store   *ConfigStore
+	auth    *Authenticator
+	limiter *RateLimiter
+	conns   *ConnManager
+	slots   *SearchSlots
+	history *SearchHistory
+	logger  *Logger
+}
+
+func NewMCPServer(store *ConfigStore, auth *Authenticator, limiter *RateLimiter, conns *ConnManager, slots *SearchSlots, history *SearchHistory, logger *Logger) *MCPServer {
+	return &MCPServer{
+		store:   store,
+		auth:    auth,
+		limiter: limiter,
+		conns:   conns,
+		slots:   slots,
+		history: history,
+		logger:  logger,
+	}
+}
+
+// handle answers one message. It returns nil for notifications and for
+// responses the client sends, since the server never issues requests.
+func (s *MCPServer) handle(ctx context.Context, msg rpcMessage, notify notifyFunc) *rpcMessage {
+	if msg.Method == "" {
+		return nil
+	}
+	if msg.ID == nil {
+		// Уведомления (initialized, cancelled) ответа не требуют
+		return nil
+	}
+	if msg.JSONRPC != "2.0" {
+		return rpcErrorResponse(msg.ID, rpcInvalidRequest, "jsonrpc must be \"2.0\"")
+	}
+
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(msg.Params, &params)
+		version := mcpProtocolVersion
+		for _, v := range mcpProtocolVersions {
+			if v == params.ProtocolVersion {
+				version = v
+			}
+		}
+		return rpcResponse(msg.ID, map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": mcpServerName, "version": "1.0.0"},
+			"instructions":    "Use web_search for questions that need current information from the web; use fetch_page to read a result in full.",
+		})
+	case "ping":
+		return rpcResponse(msg.ID, struct{}{})
+	case "tools/list":
+		return rpcResponse(msg.ID, map[string]any{"tools": mcpTools})
+	case "tools/call":
+		var params mcpCallParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return rpcErrorResponse(msg.ID, rpcInvalidParams, err.Error())
+		}
+		res, rpcErr := s.callTool(ctx, params, notify)
+		if rpcErr != nil {
+			return rpcErrorResponse(msg.ID, rpcErr.Code, rpcErr.Message)
+		}
+		return rpcResponse(msg.ID, res)
+	}
+	return rpcErrorResponse(msg.ID, rpcMethodNotFound, "method not found: "+msg.Method)
+}
+
+func (s *MCPServer) callTool(ctx context.Context, params mcpCallParams, notify notifyFunc) (mcpToolResult, *rpcError) {
+	cfg := s.store.Load()
+	if len(params.Arguments) == 0 {
+		params.Arguments = json.RawMessage("{}")
+	}
+	invalid := func(err error) (mcpToolResult, *rpcError) {
+		return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid arguments for %s: %v", params.Name, err)}
+	}
+
+	start := time.Now()
+	var res mcpToolResult
+	switch params.Name {
+	case mcpToolWebSearch:
+		var args mcpWebSearchArgs
+		if err := json.Unmarshal(params.Arguments, &args); err != nil {
+			return invalid(err)
+		}
+		res = s.webSearch(ctx, args, params.Meta.ProgressToken, notify, cfg)
+	case mcpToolFetchPage:
+		var args mcpFetchPageArgs
+		if err := json.Unmarshal(params.Arguments, &args); err != nil {
+			return invalid(err)
+		}
+		res = s.fetchPage(ctx, args, cfg)
+	case mcpToolGenerateQueries:
+		var args mcpGenerateQueriesArgs
+		if err := json.Unmarshal(params.Arguments, &args); err != nil {
+			return invalid(err)
+		}
+		res = s.generateQueries(ctx, args, cfg)
+	default:
+		return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "unknown tool: " + params.Name}
+	}
+
+	outcome := "success"
+	if res.IsError {
+		outcome = "error"
+	}
+	mcpToolCallsTotal.WithLabelValues(params.Name, outcome).Inc()
+	s.logger.Info("mcp tool called", "tool", params.Name, "outcome", outcome,
+		"api_key", principalName(principalFromContext(ctx)), "elapsed_ms", time.Since(start).Milliseconds())
+	return res, nil
+}
+
+func (s *MCPServer) webSearch(ctx context.Context, args mcpWebSearchArgs, progressToken json.RawMessage, notify notifyFunc, cfg AppConfig) mcpToolResult {
+	searchReq := SearchRequest{
+		Prompt: args.Prompt,
+		Settings: Settings{
+			Queries:     args.Queries,
+			ContentMode: args.ContentMode,
+			Engines:     args.Engines,
+			Categories:  args.Categories,
+			TimeRange:   args.TimeRange,
+			Pages:       1,
+		},
+	}
+	SanitizeSearchRequest(&searchReq)
+	if searchReq.Settings.Queries == 0 {
+		searchReq.Settings.Queries = cfg.Search.DefaultQueryCount
+	}
+	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
+		return mcpToolError("invalid search: %s", validationErrors.Error())
+	}
+	if !s.conns.BeginSearch() {
+		return mcpAppError(ErrShuttingDown)
+	}
+	defer s.conns.EndSearch()
+	if appErr := s.auth.BeginSearch(principalFromContext(ctx)); appErr != nil {
+		searchesTotal.WithLabelValues("quota_exceeded").Inc()
+		return mcpAppError(appErr)
+	}
+
+	if locale := supportedLocale(args.Locale); locale != "" {
+		ctx = contextWithLocale(ctx, locale)
+	}
+	// Отмена вызова клиентом и таймаут драйна останавливают поиск
+	searchCtx, cancel := s.conns.SearchContext(ctx, cfg.WebSocket.SearchTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	out := &mcpProgressSink{token: progressToken, notify: notify}
+	executeSearch(searchCtx, out, s.auth, s.slots, s.history, searchSourceMCP, searchReq, cfg, s.logger, attribute.String("mcp.tool", mcpToolWebSearch))
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	switch {
+	case out.result != nil:
+		var b strings.Builder
+		if len(out.result.Results) == 0 {
+			b.WriteString("No relevant results found.\n\n")
+		}
+		if err := writeMarkdown(&b, searchReq.Prompt, out.result); err != nil {
+			return mcpToolError("failed to format results: %v", err)
+		}
+		res := mcpText(b.String())
+		res.StructuredContent = out.result
+		return res
+	case out.err != nil:
+		return mcpToolError("%s: %s: %s", out.err.Code, out.err.Message, out.err.Details)
+	}
+	return mcpToolError("search was canceled")
+}
+
+func (s *MCPServer) fetchPage(ctx context.Context, args mcpFetchPageArgs, cfg AppConfig) mcpToolResult {
+	u, err := url.Parse(args.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return mcpToolError("url must be an absolute http(s) URL")
+	}
+	maxChars := args.MaxChars
+	if maxChars <= 0 {
+		maxChars = mcpDefaultMaxChars
+	}
+	if !s.conns.BeginSearch() {
+		return mcpAppError(ErrShuttingDown)
+	}
+	defer s.conns.EndSearch()
+	if appErr := s.auth.BeginSearch(principalFromContext(ctx)); appErr != nil {
+		return mcpAppError(appErr)
+	}
+	if err := s.slots.Acquire(ctx, cfg.Search.MaxConcurrentSearches); err != nil {
+		return mcpToolError("fetch was canceled")
+	}
+	defer s.slots.Release()
+
+	doc, err := fetchPageContent(ctx, u.String(), cfg)
+	if err != nil {
+		return mcpToolError("failed to fetch %s: %v", u, err)
+	}
+	page := mcpPage{URL: u.String(), Page: doc, Text: doc.Text}
+	if runes := []rune(page.Text); len(runes) > maxChars {
+		page.Text = string(runes[:maxChars])
+		page.Truncated = true
+	}
+
+	var b strings.Builder
+	if doc.Title != "" {
+		fmt.Fprintf(&b, "# %s\n\n", oneLine(doc.Title))
+	}
+	fmt.Fprintf(&b, "URL: %s\n", page.URL)
+	if doc.SiteName != "" {
+		fmt.Fprintf(&b, "Site: %s\n", doc.SiteName)
+	}
+	if doc.Author != "" {
+		fmt.Fprintf(&b, "Author: %s\n", doc.Author)
+	}
+	if doc.PublishedAt != nil {
+		fmt.Fprintf(&b, "Published: %s\n", doc.PublishedAt.Format(time.DateOnly))
+	}
+	fmt.Fprintf(&b, "\n%s\n", page.Text)
+	if page.Truncated {
+		fmt.Fprintf(&b, "\n[text truncated to %d characters]\n", maxChars)
+	}
+	res := mcpText(b.String())
+	res.StructuredContent = page
+	return res
+}
+
+func (s *MCPServer) generateQueries(ctx context.Context, args mcpGenerateQueriesArgs, cfg AppConfig) mcpToolResult {
+	prompt := strings.TrimSpace(args.Prompt)
+	if prompt == "" {
+		return mcpToolError("prompt is required")
+	}
+	count := args.Count
+	if count == 0 {
+		count = cfg.Search.DefaultQueryCount
+	}
+	if count < 1 || count > cfg.Validation.MaxQueryCount {
+		return mcpToolError("count must be between 1 and %d", cfg.Validation.MaxQueryCount)
+	}
+
+	usage := &tokenUsage{}
+	ctx = contextWithTokenUsage(ctx, usage)
+	defer func() { s.auth.AddTokens(principalFromContext(ctx), usage.total.Load()) }()
+	queries, err := generateQueriesWithOpenRouter(ctx, prompt, count, cfg)
+	if err != nil {
+		return mcpToolError("failed to generate queries: %v", err)
+	}
+	res := mcpText(strings.Join(queries, "\n"))
+	res.StructuredContent = map[string]any{"queries": queries}
+	return res
+}
+
+// mcpProgressSink collects the search outcome and forwards status events as
+// notifications/progress when the client asked for them with a progress
+// token.
+type mcpProgressSink struct {
+	resultCollector
+	token    json.RawMessage
+	notify   notifyFunc
+	progress int
+}
+
+func (s *mcpProgressSink) Send(msgType string, data interface{}) error {
+	status, ok := data.(WSSearchStatus)
+	if !ok {
+		return s.resultCollector.Send(msgType, data)
+	}
+	if s.token == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Этапы конвейера сбрасывают свой счетчик, а прогресс MCP должен только расти
+	s.progress++
+	s.notify("notifications/progress", map[string]any{
+		"progressToken": s.token,
+		"progress":      s.progress,
+		"message":       status.Message,
+	})
+	return nil
+}
+
+// ServeStdio reads newline-delimited JSON-RPC messages from r until EOF or
+// ctx is done and writes responses and notifications to w. Calls run
+// concurrently and can be canceled with notifications/cancelled.
+func (s *MCPServer) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	var writeMu sync.Mutex
+	write := func(msg *rpcMessage) {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			s.logger.Error("failed to encode mcp message", "error", err)
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, _ = w.Write(append(data, '\n'))
+	}
+	notify := func(method string, params any) {
+		raw, _ := json.Marshal(params)
+		write(&rpcMessage{JSONRPC: "2.0", Method: method, Params: raw})
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		inFlight = map[string]context.CancelFunc{}
+	)
+	// После EOF незавершенные вызовы дорабатывают, при отмене ctx они прерываются
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(s.store.Load().WebSocket.MaxMessageSize))
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		for scanner.Scan() {
+			select {
+			case lines <- bytes.Clone(scanner.Bytes()):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		var line []byte
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case l, ok := <-lines:
+			if !ok {
+				return scanner.Err()
+			}
+			line = bytes.TrimSpace(l)
+		}
+		if len(line) == 0 {
+			continue
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			write(rpcErrorResponse(nil, rpcParseError, "parse error: "+err.Error()))
+			continue
+		}
+
+		if msg.Method == "notifications/cancelled" {
+			var params struct {
+				RequestID json.RawMessage `json:"requestId"`
+			}
+			_ = json.Unmarshal(msg.Params, &params)
+			mu.Lock()
+			if cancel, ok := inFlight[string(params.RequestID)]; ok {
+				cancel()
+			}
+			mu.Unlock()
+			continue
+		}
+		if msg.ID == nil || msg.Method == "" {
+			_ = s.handle(ctx, msg, notify)
+			continue
+		}
+
+		callCtx, cancel := context.WithCancel(ctx)
+		id := string(msg.ID)
+		mu.Lock()
+		inFlight[id] = cancel
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := s.handle(callCtx, msg, notify)
+			mu.Lock()
+			delete(inFlight, id)
+			mu.Unlock()
+			// По спецификации на отмененный запрос не отвечают
+			if resp != nil && callCtx.Err() == nil {
+				write(resp)
+			}
+			cancel()
+		}()
+	}
+}
+
+// ServeHTTP implements the streamable HTTP transport without sessions. Each
+// POST carries one message; when the client accepts text/event-stream, a
+// tool call is answered as an SSE stream so progress notifications arrive
+// before the result.
+func (s *MCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := s.store.Load()
+	if origin := r.Header.Get("Origin"); origin != "" && !mcpOriginAllowed(origin, cfg) {
+		ErrorResponse(w, NewAppError(ErrForbidden.Code, ErrForbidden.Message,
+			fmt.Sprintf("origin %q is not allowed", origin), ErrForbidden.Status))
+		return
+	}
+	if r.Method != http.MethodPost {
+		// Сервер не отправляет сообщения вне запросов, поток по GET не нужен
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg rpcMessage
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.WebSocket.MaxMessageSize)
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeRPCJSON(w, http.StatusBadRequest, rpcErrorResponse(nil, rpcParseError, "parse error: "+err.Error()))
+		return
+	}
+	if msg.ID == nil || msg.Method == "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if msg.Method == "tools/call" {
+		principal := principalFromContext(r.Context())
+		if ok, retryAfter := s.limiter.Allow(rateLimitKey(principal, r, cfg.RateLimit), cfg.RateLimit); !ok {
+			ErrorResponse(w, rateLimitedError(fmt.Sprintf("search rate limit of %g per minute exceeded", cfg.RateLimit.SearchesPerMinute), retryAfter))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if locale := negotiateLocale(r.Header.Get("Accept-Language")); locale != "" {
+		ctx = contextWithLocale(ctx, locale)
+	}
+	if msg.Method != "tools/call" || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		writeRPCJSON(w, http.StatusOK, s.handle(ctx, msg, func(string, any) {}))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	var mu sync.Mutex
+	writeEvent := func(m *rpcMessage) {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Поиск может идти дольше общего WriteTimeout сервера
+		_ = rc.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		_ = rc.Flush()
+	}
+	resp := s.handle(ctx, msg, func(method string, params any) {
+		raw, _ := json.Marshal(params)
+		writeEvent(&rpcMessage{JSONRPC: "2.0", Method: method, Params: raw})
+	})
+	writeEvent(resp)
+}
+
+// mcpOriginAllowed reports whether a browser at origin may use the HTTP
+// transport. The MCP spec requires this check against DNS rebinding, so it
+// applies even without websocket.enable_origin_check: only
+// websocket.allowed_origins and server.public_url are accepted.
+func mcpOriginAllowed(origin string, cfg AppConfig) bool {
+	policy := NewOriginPolicy(cfg.WebSocket)
+	policy.enabled = true
+	if p, err := parseOriginPattern(strings.TrimRight(cfg.Server.PublicURL, "/")); err == nil {
+		policy.patterns = append(policy.patterns, p)
+	}
+	return policy.Allowed(origin)
+}
+
+func writeRPCJSON(w http.ResponseWriter, status int, msg *rpcMessage) {
+	// Вызов инструмента может идти дольше общего WriteTimeout сервера
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wsWriteWait))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(msg)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestMCPServer(t *testing.T, cfg AppConfig) *MCPServer {
+	t.Helper()
+	logger := NewLogger()
+	return NewMCPServer(NewConfigStore("", cfg, logger), NewAuthenticator(AuthConfig{}),
+		NewRateLimiter(), NewConnManager(), NewSearchSlots(), nil, logger)
+}
+
+// mcpExchange writes the requests to ServeStdio and returns every message
+// it wrote back, keyed by ID for responses and collected for notifications.
+func mcpExchange(t *testing.T, s *MCPServer, requests ...string) (map[string]rpcMessage, []rpcMessage) {
+	t.Helper()
+	var out strings.Builder
+	if err := s.ServeStdio(context.Background(), strings.NewReader(strings.Join(requests, "\n")), &out); err != nil {
+		t.Fatal(err)
+	}
+	responses := map[string]rpcMessage{}
+	var notifications []rpcMessage
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		var msg rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("bad output line %q: %v", scanner.Text(), err)
+		}
+		if msg.ID != nil {
+			responses[string(msg.ID)] = msg
+		} else {
+			notifications = append(notifications, msg)
+		}
+	}
+	return responses, notifications
+}
+
+func decodeResult(t *testing.T, msg rpcMessage, v any) {
+	t.Helper()
+	if msg.Error != nil {
+		t.Fatalf("unexpected error %+v", msg.Error)
+	}
+	raw, _ := json.Marshal(msg.Result)
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMCPStdioHandshakeAndTools(t *testing.T) {
+	openRouter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"go generics tutorial\ngo type parameters"}}]}`))
+	}))
+	defer openRouter.Close()
+	cfg := defaultConfig()
+	cfg.OpenRouter.APIKey = "test"
+	cfg.OpenRouter.Endpoint = openRouter.URL
+
+	responses, _ := mcpExchange(t, newTestMCPServer(t, cfg),
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"generate_queries","arguments":{"prompt":"golang generics","count":2}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"resources/list"}`,
+		`not json`,
+	)
+
+	var initialized struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	decodeResult(t, responses["1"], &initialized)
+	if initialized.ProtocolVersion != "2025-03-26" {
+		t.Fatalf("expected the client's protocol version to be accepted, got %q", initialized.ProtocolVersion)
+	}
+
+	var list struct {
+		Tools []mcpTool `json:"tools"`
+	}
+	decodeResult(t, responses["2"], &list)
+	if len(list.Tools) != 3 || list.Tools[0].Name != mcpToolWebSearch {
+		t.Fatalf("unexpected tools %+v", list.Tools)
+	}
+
+	var call mcpToolResult
+	decodeResult(t, responses["3"], &call)
+	if call.IsError || call.Content[0].Text != "go generics tutorial\ngo type parameters" {
+		t.Fatalf("unexpected generate_queries result %+v", call)
+	}
+
+	if resp := responses["4"]; resp.Error == nil || resp.Error.Code != rpcMethodNotFound {
+		t.Fatalf("expected method not found, got %+v", resp)
+	}
+	if resp := responses["null"]; resp.Error == nil || resp.Error.Code != rpcParseError {
+		t.Fatalf("expected parse error, got %+v", resp)
+	}
+}
+
+func TestMCPWebSearchReportsProgressAndToolErrors(t *testing.T) {
+	// Без ключа OpenRouter поиск падает на генерации запросов после первого статуса
+	responses, notifications := mcpExchange(t, newTestMCPServer(t, defaultConfig()),
+		`{"jsonrpc":"2.0","id":"a","method":"tools/call","params":{"name":"web_search","arguments":{"prompt":"golang generics"},"_meta":{"progressToken":"tok"}}}`,
+		`{"jsonrpc":"2.0","id":"b","method":"tools/call","params":{"name":"web_search","arguments":{"prompt":""}}}`,
+		`{"jsonrpc":"2.0","id":"c","method":"tools/call","params":{"name":"fetch_page","arguments":{"url":"file:///etc/passwd"}}}`,
+	)
+
+	var res mcpToolResult
+	decodeResult(t, responses[`"a"`], &res)
+	if !res.IsError || !strings.Contains(res.Content[0].Text, "QUERY_GENERATION_FAILED") {
+		t.Fatalf("expected query generation tool error, got %+v", res)
+	}
+	if len(notifications) == 0 {
+		t.Fatal("expected progress notifications")
+	}
+	var progress struct {
+		ProgressToken string `json:"progressToken"`
+		Progress      int    `json:"progress"`
+	}
+	for i, n := range notifications {
+		if n.Method != "notifications/progress" {
+			t.Fatalf("unexpected notification %q", n.Method)
+		}
+		_ = json.Unmarshal(n.Params, &progress)
+		if progress.ProgressToken != "tok" || progress.Progress != i+1 {
+			t.Fatalf("unexpected progress %+v at %d", progress, i)
+		}
+	}
+
+	for _, id := range []string{`"b"`, `"c"`} {
+		var res mcpToolResult
+		decodeResult(t, responses[id], &res)
+		if !res.IsError {
+			t.Fatalf("expected tool error for %s, got %+v", id, res)
+		}
+	}
+}
+
+func TestMCPHTTPStreamsToolCall(t *testing.T) {
+	ts := httptest.NewServer(newTestMCPServer(t, defaultConfig()))
+	defer ts.Close()
+
+	post := func(body, accept string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post(`{"jsonrpc":"2.0","method":"notifications/initialized"}`, "application/json, text/event-stream")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202 for a notification, got %d", resp.StatusCode)
+	}
+
+	resp = post(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"web_search","arguments":{"prompt":"golang"},"_meta":{"progressToken":1}}}`,
+		"application/json, text/event-stream")
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected SSE response, got %q", ct)
+	}
+	var events []rpcMessage
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var msg rpcMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, msg)
+		}
+	}
+	if len(events) < 2 || events[0].Method != "notifications/progress" {
+		t.Fatalf("expected progress before the result, got %+v", events)
+	}
+	if last := events[len(events)-1]; string(last.ID) != "7" || last.Result == nil {
+		t.Fatalf("expected the response last, got %+v", last)
+	}
+}
+
+func TestMCPFetchPageIsGuardedAndCharged(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><head><title>Generics</title></head><body><article><p>Type parameters let functions work with many types.</p></article></body></html>`))
+	}))
+	defer page.Close()
+
+	cfg := defaultConfig()
+	cfg.Auth = testAuthConfig()
+	s := newTestMCPServer(t, cfg)
+	s.auth = NewAuthenticator(cfg.Auth)
+	principal, appErr := s.auth.Authenticate("web-key-0123456789", scopeSearch)
+	if appErr != nil {
+		t.Fatal(appErr)
+	}
+	ctx := contextWithPrincipal(context.Background(), principal)
+
+	if res := s.fetchPage(ctx, mcpFetchPageArgs{URL: page.URL}, cfg); !res.IsError || !strings.Contains(res.Content[0].Text, errPrivateAddress.Error()) {
+		t.Fatalf("expected a loopback page to be refused, got %+v", res)
+	}
+
+	cfg.Outbound.AllowPrivateNetworks = true
+	if res := s.fetchPage(ctx, mcpFetchPageArgs{URL: page.URL}, cfg); res.IsError || !strings.Contains(res.Content[0].Text, "Type parameters") {
+		t.Fatalf("expected the page text, got %+v", res)
+	}
+	// Ключ web может сделать два поиска в день, обе загрузки их израсходовали
+	if res := s.fetchPage(ctx, mcpFetchPageArgs{URL: page.URL}, cfg); !res.IsError || !strings.Contains(res.Content[0].Text, ErrQuotaExceeded.Code) {
+		t.Fatalf("expected fetch_page to count against the daily quota, got %+v", res)
+	}
+}
+
+func TestMCPHTTPChecksOrigin(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Server.PublicURL = "https://search.example.com/"
+	ts := httptest.NewServer(newTestMCPServer(t, cfg))
+	defer ts.Close()
+
+	for origin, want := range map[string]int{
+		"http://rebound.example.net": http.StatusForbidden,
+		"https://search.example.com": http.StatusOK,
+		"":                           http.StatusOK,
+	} {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("origin %q: expected %d, got %d", origin, want, resp.StatusCode)
+		}
+	}
+}
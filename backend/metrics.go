@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Prometheus collectors. They are registered on the default registry and
+// served by promhttp at cfg.Metrics.Path.
+var (
+	searchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_searches_total",
+		Help: "Searches handled, by outcome.",
+	}, []string{"outcome"})
+
+	searchStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aggregator_search_stage_duration_seconds",
+		Help:    "Duration of each search pipeline stage.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"stage"})
+
+	searxRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aggregator_searx_request_duration_seconds",
+		Help:    "Latency of SearxNG requests, by instance and HTTP status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"instance", "status"})
+
+	searxErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_searx_errors_total",
+		Help: "SearxNG queries that failed on every instance, by page (first or later).",
+	}, []string{"page"})
+
+	openRouterRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aggregator_openrouter_request_duration_seconds",
+		Help:    "Latency of OpenRouter calls, by pipeline stage, model and HTTP status.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+	}, []string{"stage", "model", "status"})
+
+	openRouterTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_openrouter_tokens_total",
+		Help: "Tokens reported by OpenRouter, by stage, model and kind (prompt or completion).",
+	}, []string{"stage", "model", "kind"})
+
+	contentFetchTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_content_fetch_total",
+		Help: "Page content fetches, by outcome.",
+	}, []string{"outcome"})
+
+	authRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_auth_rejections_total",
+		Help: "Requests and searches rejected by API key checks, by error code.",
+	}, []string{"code"})
+
+	jobCallbacksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_job_callbacks_total",
+		Help: "Job result callbacks, by outcome (delivered or failed).",
+	}, []string{"outcome"})
+
+	searchSlotsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "aggregator_search_slots_in_use",
+		Help: "Searches currently holding one of the global pipeline slots.",
+	})
+
+	searchesQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "aggregator_searches_queued",
+		Help: "Searches waiting for a free pipeline slot.",
+	})
+
+	mcpToolCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_mcp_tool_calls_total",
+		Help: "MCP tool calls, by tool and outcome (success or error).",
+	}, []string{"tool", "outcome"})
+
+	suggestRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_suggest_requests_total",
+		Help: "Search suggestion requests, by provider and outcome.",
+	}, []string{"provider", "outcome"})
+
+	historyWritesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_history_writes_total",
+		Help: "Searches recorded in the history, by outcome (success or error).",
+	}, []string{"outcome"})
+
+	savedSearchRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_saved_search_runs_total",
+		Help: "Scheduled saved search runs, by outcome (completed or failed).",
+	}, []string{"outcome"})
+
+	savedSearchWebhooksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_saved_search_webhooks_total",
+		Help: "Saved search change alerts, by outcome (delivered or failed).",
+	}, []string{"outcome"})
+
+	websocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "aggregator_websocket_connections",
+		Help: "Currently open WebSocket connections.",
+	})
+)
+
+// observeSearchStage records the time spent in a pipeline stage.
+func observeSearchStage(stage string, start time.Time) {
+	searchStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// statusLabel converts an HTTP status into a metric label; zero means the
+// request failed before a response was received.
+func statusLabel(status int) string {
+	if status == 0 {
+		return "error"
+	}
+	return strconv.Itoa(status)
+}
+
+// openRouterCall accumulates the outcome of a single OpenRouter request and
+// records it, together with its tracing span, when finish is called.
+type openRouterCall struct {
+	stage  string
+	model  string
+	start  time.Time
+	status int
+	usage  *openRouterUsage
+	err    error
+	span   trace.Span
+	tokens *tokenUsage
+}
+
+func startOpenRouterCall(ctx context.Context, stage, model string) (context.Context, *openRouterCall) {
+	ctx, span := tracer.Start(ctx, "openrouter."+stage, trace.WithAttributes(
+		attribute.String("llm.stage", stage),
+		attribute.String("llm.model", model),
+	))
+	return ctx, &openRouterCall{stage: stage, model: model, start: time.Now(), span: span, tokens: tokenUsageFromContext(ctx)}
+}
+
+func (c *openRouterCall) finish() {
+	c.span.SetAttributes(attribute.Int("http.response.status_code", c.status))
+	if c.usage != nil {
+		c.span.SetAttributes(
+			attribute.Int("llm.usage.prompt_tokens", c.usage.PromptTokens),
+			attribute.Int("llm.usage.completion_tokens", c.usage.CompletionTokens),
+		)
+	}
+	endSpan(c.span, c.err)
+
+	openRouterRequestDuration.WithLabelValues(c.stage, c.model, statusLabel(c.status)).Observe(time.Since(c.start).Seconds())
+	if c.usage != nil {
+		openRouterTokens.WithLabelValues(c.stage, c.model, "prompt").Add(float64(c.usage.PromptTokens))
+		openRouterTokens.WithLabelValues(c.stage, c.model, "completion").Add(float64(c.usage.CompletionTokens))
+		if c.tokens != nil {
+			c.tokens.add(c.usage)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOpenRouterCallRecordsTokens(t *testing.T) {
+	prompt := openRouterTokens.WithLabelValues("test_stage", "test/model", "prompt")
+	before := testutil.ToFloat64(prompt)
+
+	_, call := startOpenRouterCall(context.Background(), "test_stage", "test/model")
+	call.status = 200
+	call.usage = &openRouterUsage{PromptTokens: 120, CompletionTokens: 5}
+	call.finish()
+
+	if got := testutil.ToFloat64(prompt) - before; got != 120 {
+		t.Fatalf("expected 120 prompt tokens recorded, got %v", got)
+	}
+	if n := testutil.CollectAndCount(openRouterRequestDuration, "aggregator_openrouter_request_duration_seconds"); n == 0 {
+		t.Fatalf("expected request duration to be observed")
+	}
+}
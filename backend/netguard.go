@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned when an outbound request to a URL the server
+// did not choose would connect to an internal address.
+var errPrivateAddress = errors.New("destination is a loopback, private, link-local or unspecified address")
+
+// isPublicAddr reports whether requests to URLs the server did not choose may
+// connect to addr.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsUnspecified()
+}
+
+// checkPublicHost rejects URLs whose host is "localhost" or an internal IP
+// literal, so obviously internal targets fail when they are submitted rather
+// than on delivery. Host names are checked again after DNS resolution.
+func checkPublicHost(host string, cfg OutboundConfig) error {
+	if cfg.AllowPrivateNetworks {
+		return nil
+	}
+	if host == "localhost" {
+		return errPrivateAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// newOutboundClient returns a client for URLs the server did not choose:
+// job callbacks, webhooks and fetched pages. Unless cfg.AllowPrivateNetworks
+// is set it refuses to connect to internal addresses. The check runs on the
+// resolved address of every connection, so DNS names and redirects cannot get
+// around it, and proxies from the environment are ignored because only the
+// proxy address would be checked.
+func newOutboundClient(timeout time.Duration, cfg OutboundConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: 10 * time.Second,
+			// Клиент создается на один запрос, соединения не переиспользуются
+			DisableKeepAlives: true,
+		},
+	}
+}
@@ -18,8 +18,18 @@ type openRouterRequest struct {
 	Model     string        `json:"model"`
 	Messages  []openMessage `json:"messages"`
 	MaxTokens int           `json:"max_tokens,omitempty"`
+	// Usage asks OpenRouter to report the cost of the request
+	Usage *openRouterUsageOptions `json:"usage,omitempty"`
 }
 
+type openRouterUsageOptions struct {
+	Include bool `json:"include"`
+}
+
+// includeUsage is set on every request so that search history can record
+// the cost
+var includeUsage = &openRouterUsageOptions{Include: true}
+
 type openMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -29,6 +39,15 @@ type openRouterResponse struct {
 	Choices []struct {
 		Message openMessage `json:"message"`
 	} `json:"choices"`
+	Usage *openRouterUsage `json:"usage,omitempty"`
+}
+
+type openRouterUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	// Cost is reported in credits when usage accounting is requested
+	Cost float64 `json:"cost,omitempty"`
 }
 
 
@@ -144,21 +163,28 @@ func generateQueriesWithOpenRouter(ctx context.Context, prompt string, n int, cf
 			{Role: "user", Content: prompt},
 		},
 		MaxTokens: cfg.OpenRouter.QueryGenMaxTokens,
+		Usage:     includeUsage,
 	}
 
 	b, _ := json.Marshal(reqBody)
-	httpReq, _ := http.NewRequestWithContext(ctx, "POST", cfg.OpenRouter.Endpoint, bytes.NewReader(b))
+	callCtx, call := startOpenRouterCall(ctx, "query_generation", reqBody.Model)
+	defer call.finish()
+	httpReq, _ := http.NewRequestWithContext(callCtx, "POST", cfg.OpenRouter.Endpoint, bytes.NewReader(b))
 	httpReq.Header.Set("Authorization", "Bearer "+cfg.OpenRouter.APIKey)
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("X-Title", "AI Search Aggregator")
 
-	client := &http.Client{Timeout: cfg.Timeouts.QueryGeneration}
+	client := newHTTPClient(cfg.Timeouts.QueryGeneration)
 	resp, err := client.Do(httpReq)
+	if err == nil {
+		call.status = resp.StatusCode
+	}
 
 	// Log request always
 	logOpenRouterRequest(cfg, "query_generation", reqBody, nil, err, 0)
 
 	if err != nil {
+		call.err = err
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -167,17 +193,20 @@ func generateQueriesWithOpenRouter(ctx context.Context, prompt string, n int, cf
 		body, _ := io.ReadAll(resp.Body)
 		// Log error response
 		logOpenRouterRequest(cfg, "query_generation", reqBody, nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body)), resp.StatusCode)
+		call.err = fmt.Errorf("openrouter status %d", resp.StatusCode)
 		return nil, fmt.Errorf("openrouter status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var orResp openRouterResponse
 	if err := json.NewDecoder(resp.Body).Decode(&orResp); err != nil {
 		logOpenRouterRequest(cfg, "query_generation", reqBody, nil, err, resp.StatusCode)
+		call.err = err
 		return nil, err
 	}
 
 	// Log successful response
 	logOpenRouterRequest(cfg, "query_generation", reqBody, &orResp, nil, resp.StatusCode)
+	call.usage = orResp.Usage
 
 	if len(orResp.Choices) == 0 {
 		return nil, errors.New("no choices returned from openrouter")
@@ -256,21 +285,28 @@ func filterByAIRelevance(ctx context.Context, prompt string, results []SearchRes
 			{Role: "user", Content: userPrompt},
 		},
 		MaxTokens: cfg.OpenRouter.FilterMaxTokens,
+		Usage:     includeUsage,
 	}
 
 	payload, _ := json.Marshal(reqBody)
-	httpReq, _ := http.NewRequestWithContext(ctx, "POST", cfg.OpenRouter.Endpoint, bytes.NewReader(payload))
+	callCtx, call := startOpenRouterCall(ctx, "ai_relevance_filter", reqBody.Model)
+	defer call.finish()
+	httpReq, _ := http.NewRequestWithContext(callCtx, "POST", cfg.OpenRouter.Endpoint, bytes.NewReader(payload))
 	httpReq.Header.Set("Authorization", "Bearer "+cfg.OpenRouter.APIKey)
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("X-Title", "AI Relevance Filter")
 
-	client := &http.Client{Timeout: cfg.Timeouts.AIRelevance}
+	client := newHTTPClient(cfg.Timeouts.AIRelevance)
 	resp, err := client.Do(httpReq)
+	if err == nil {
+		call.status = resp.StatusCode
+	}
 
 	// Log request always
 	logOpenRouterRequest(cfg, "ai_relevance_filter", reqBody, nil, err, 0)
 
 	if err != nil {
+		call.err = err
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -279,17 +315,20 @@ func filterByAIRelevance(ctx context.Context, prompt string, results []SearchRes
 		body, _ := io.ReadAll(resp.Body)
 		// Log error response
 		logOpenRouterRequest(cfg, "ai_relevance_filter", reqBody, nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body)), resp.StatusCode)
+		call.err = fmt.Errorf("openrouter status %d", resp.StatusCode)
 		return nil, fmt.Errorf("openrouter status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var orResp openRouterResponse
 	if err := json.NewDecoder(resp.Body).Decode(&orResp); err != nil {
 		logOpenRouterRequest(cfg, "ai_relevance_filter", reqBody, nil, err, resp.StatusCode)
+		call.err = err
 		return nil, err
 	}
 
 	// Log successful response
 	logOpenRouterRequest(cfg, "ai_relevance_filter", reqBody, &orResp, nil, resp.StatusCode)
+	call.usage = orResp.Usage
 
 	if len(orResp.Choices) == 0 {
 		return nil, errors.New("no choices returned from openrouter")
@@ -335,21 +374,23 @@ func filterByAIRelevance(ctx context.Context, prompt string, results []SearchRes
 	return filtered, nil
 }
 
+// truncateForLLM trims s and cuts it to max characters.
 func truncateForLLM(s string, max int) string {
 	s = strings.TrimSpace(s)
-	if len(s) <= max {
-		return s
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max]) + "…"
 	}
-	return s[:max] + "…"
+	return s
 }
 
-// isContentRelevantToPrompt judges a single page content for relevance to the user's query.
-// Returns true if relevant, false otherwise.
+// isContentRelevantToPrompt judges a page for relevance to the user's query from
+// an excerpt: the selected passages or the search snippet. Callers size the
+// excerpt to content.truncation_length. Returns true if relevant, false otherwise.
 func isContentRelevantToPrompt(ctx context.Context, prompt, title, url, content string, cfg AppConfig) (bool, error) {
 	if cfg.OpenRouter.APIKey == "" {
 		return false, errors.New("OPENROUTER_API_KEY not set")
 	}
-	systemPrompt := "You are a strict binary relevance judge. Answer with a single character: 1 if the page content is relevant to the user's query, 0 if not. No explanation."
+	systemPrompt := "You are a strict binary relevance judge. Answer with a single character: 1 if the page is relevant to the user's query, 0 if not. No explanation."
 
 	userPrompt := strings.Builder{}
 	userPrompt.WriteString("User query:\n")
@@ -358,8 +399,8 @@ func isContentRelevantToPrompt(ctx context.Context, prompt, title, url, content
 	userPrompt.WriteString(strings.TrimSpace(title))
 	userPrompt.WriteString("\nURL:\n")
 	userPrompt.WriteString(strings.TrimSpace(url))
-	userPrompt.WriteString("\n\nPage content (may be truncated):\n")
-	userPrompt.WriteString(truncateForLLM(content, cfg.Content.TruncationLength))
+	userPrompt.WriteString("\n\nExcerpts from the page (passages selected for the query, separated by …):\n")
+	userPrompt.WriteString(strings.TrimSpace(content))
 
 	reqBody := openRouterRequest{
 		Model: cfg.OpenRouter.Model,
@@ -368,21 +409,28 @@ func isContentRelevantToPrompt(ctx context.Context, prompt, title, url, content
 			{Role: "user", Content: userPrompt.String()},
 		},
 		MaxTokens: cfg.OpenRouter.ContentMaxTokens,
+		Usage:     includeUsage,
 	}
 
 	payload, _ := json.Marshal(reqBody)
-	httpReq, _ := http.NewRequestWithContext(ctx, "POST", cfg.OpenRouter.Endpoint, bytes.NewReader(payload))
+	callCtx, call := startOpenRouterCall(ctx, "content_relevance", reqBody.Model)
+	defer call.finish()
+	httpReq, _ := http.NewRequestWithContext(callCtx, "POST", cfg.OpenRouter.Endpoint, bytes.NewReader(payload))
 	httpReq.Header.Set("Authorization", "Bearer "+cfg.OpenRouter.APIKey)
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("X-Title", "AI Single Content Relevance")
 
-	client := &http.Client{Timeout: cfg.Timeouts.ContentRelevance}
+	client := newHTTPClient(cfg.Timeouts.ContentRelevance)
 	resp, err := client.Do(httpReq)
+	if err == nil {
+		call.status = resp.StatusCode
+	}
 
 	// Log request always
 	logOpenRouterRequest(cfg, "content_relevance", reqBody, nil, err, 0)
 
 	if err != nil {
+		call.err = err
 		return false, err
 	}
 	defer resp.Body.Close()
@@ -391,17 +439,20 @@ func isContentRelevantToPrompt(ctx context.Context, prompt, title, url, content
 		body, _ := io.ReadAll(resp.Body)
 		// Log error response
 		logOpenRouterRequest(cfg, "content_relevance", reqBody, nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body)), resp.StatusCode)
+		call.err = fmt.Errorf("openrouter status %d", resp.StatusCode)
 		return false, fmt.Errorf("openrouter status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var orResp openRouterResponse
 	if err := json.NewDecoder(resp.Body).Decode(&orResp); err != nil {
 		logOpenRouterRequest(cfg, "content_relevance", reqBody, nil, err, resp.StatusCode)
+		call.err = err
 		return false, err
 	}
 
 	// Log successful response
 	logOpenRouterRequest(cfg, "content_relevance", reqBody, &orResp, nil, resp.StatusCode)
+	call.usage = orResp.Usage
 
 	if len(orResp.Choices) == 0 {
 		return false, errors.New("no choices returned from openrouter")
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Провайдеры подсказок /api/suggest
+const (
+	suggestProviderSearx = "searx"
+	suggestProviderLLM   = "llm"
+	suggestProviderNone  = "none"
+)
+
+const (
+	openSearchShortName = "AI Search"
+	openSearchXMLNS     = "http://a9.com/-/spec/opensearch/1.1/"
+)
+
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"OpenSearchDescription"`
+	XMLNS         string          `xml:"xmlns,attr"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	URLs          []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Rel      string `xml:"rel,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+// OpenSearchHandler lets browsers add the aggregator as a search engine: it
+// serves the OpenSearch description, the /search entry point and address bar
+// suggestions. None of them needs an API key; the frontend authenticates the
+// search itself. Only generated suggestions, which spend OpenRouter tokens,
+// are reserved for callers with a key.
+type OpenSearchHandler struct {
+	store *ConfigStore
+	auth  *Authenticator
+	// limiter is separate from the search limiter, suggestions are requested
+	// on every keystroke
+	limiter *RateLimiter
+	logger  *Logger
+}
+
+func NewOpenSearchHandler(store *ConfigStore, auth *Authenticator, logger *Logger) *OpenSearchHandler {
+	return &OpenSearchHandler{store: store, auth: auth, limiter: NewRateLimiter(), logger: logger}
+}
+
+func (h *OpenSearchHandler) handleDescription(w http.ResponseWriter, r *http.Request) {
+	base := publicBaseURL(r, h.store.Load())
+	desc := openSearchDescription{
+		XMLNS:         openSearchXMLNS,
+		ShortName:     openSearchShortName,
+		Description:   "AI-powered search aggregator",
+		InputEncoding: "UTF-8",
+		URLs: []openSearchURL{
+			{Type: "text/html", Template: base + "/search?q={searchTerms}"},
+			{Type: "application/x-suggestions+json", Template: base + "/api/suggest?q={searchTerms}"},
+			{Type: "application/opensearchdescription+xml", Rel: "self", Template: base + "/opensearch.xml"},
+		},
+	}
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	_ = enc.Encode(desc)
+}
+
+// handleSearch forwards a browser search to the frontend, which starts the
+// search from the q parameter.
+func (h *OpenSearchHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	target := "/"
+	if q != "" {
+		target = "/?q=" + url.QueryEscape(q)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleSuggest answers in the OpenSearch suggestions format
+// ["query", ["completion", ...]]. Provider failures yield an empty list
+// since browsers do not surface errors. With the llm provider, callers
+// without a usable key get SearxNG suggestions instead.
+func (h *OpenSearchHandler) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	cfg := h.store.Load()
+	provider := cfg.Suggest.Provider
+	var principal *Principal
+	if provider == suggestProviderLLM {
+		var ok bool
+		if principal, ok = h.llmPrincipal(r); !ok {
+			provider = suggestProviderSearx
+		}
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if runes := []rune(q); len(runes) > cfg.Validation.MaxPromptLength {
+		q = string(runes[:cfg.Validation.MaxPromptLength])
+	}
+
+	limits := RateLimitConfig{
+		Enabled:           cfg.RateLimit.Enabled,
+		SearchesPerMinute: cfg.Suggest.RequestsPerMinute,
+		Burst:             cfg.Suggest.Burst,
+	}
+	if ok, retryAfter := h.limiter.Allow("ip:"+clientIP(r, cfg.RateLimit.TrustedProxies), limits); !ok {
+		suggestRequestsTotal.WithLabelValues(provider, "rate_limited").Inc()
+		ErrorResponse(w, rateLimitedError(fmt.Sprintf("suggestion rate limit of %g per minute exceeded", cfg.Suggest.RequestsPerMinute), retryAfter))
+		return
+	}
+
+	suggestions := []string{}
+	if q != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Suggest.Timeout)
+		defer cancel()
+		var (
+			found []string
+			err   error
+		)
+		switch provider {
+		case suggestProviderSearx:
+			found, err = fetchSearxSuggestions(ctx, cfg, q, cfg.Suggest.SearxBackend)
+		case suggestProviderLLM:
+			usage := &tokenUsage{}
+			found, err = generateQueriesWithOpenRouter(contextWithTokenUsage(ctx, usage), q, cfg.Suggest.MaxSuggestions, cfg)
+			h.auth.AddTokens(principal, usage.total.Load())
+		}
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+			h.logger.Warn("suggestions failed", "provider", provider, "error", err)
+		}
+		suggestRequestsTotal.WithLabelValues(provider, outcome).Inc()
+		for _, s := range found {
+			if s = strings.TrimSpace(s); s != "" && len(suggestions) < cfg.Suggest.MaxSuggestions {
+				suggestions = append(suggestions, s)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	_ = json.NewEncoder(w).Encode([]any{q, suggestions})
+}
+
+// llmPrincipal returns the caller that generated suggestions are charged to.
+// ok is false unless the request carries a key with the search scope and
+// tokens left today; with authentication disabled anyone may use them.
+func (h *OpenSearchHandler) llmPrincipal(r *http.Request) (p *Principal, ok bool) {
+	key, _ := apiKeyFromRequest(r, false)
+	if key == "" && h.auth.Enabled() {
+		// Браузеры ключ не передают, это не ошибка аутентификации
+		return nil, false
+	}
+	p, appErr := h.auth.Authenticate(key, scopeSearch)
+	if appErr != nil {
+		return nil, false
+	}
+	if p != nil {
+		if quota := h.auth.Quota(p); quota.TokensLimit > 0 && quota.TokensUsed >= quota.TokensLimit {
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+// publicBaseURL returns server.public_url or, when unset, the origin the
+// request was made to. Forwarded headers are only trusted from proxies listed
+// in rate_limit.trusted_proxies.
+func publicBaseURL(r *http.Request, cfg AppConfig) string {
+	if cfg.Server.PublicURL != "" {
+		return strings.TrimRight(cfg.Server.PublicURL, "/")
+	}
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if isTrustedProxy(peer, parseTrustedProxies(cfg.RateLimit.TrustedProxies)) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := r.Header.Get("X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestOpenSearch(t *testing.T, cfg AppConfig) *OpenSearchHandler {
+	t.Helper()
+	logger := NewLogger()
+	return NewOpenSearchHandler(NewConfigStore("", cfg, logger), NewAuthenticator(cfg.Auth), logger)
+}
+
+func TestOpenSearchDescriptionUsesForwardedOrigin(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RateLimit.TrustedProxies = append(cfg.RateLimit.TrustedProxies, "172.18.0.5")
+	h := newTestOpenSearch(t, cfg)
+	req := httptest.NewRequest(http.MethodGet, "/opensearch.xml", nil)
+	req.RemoteAddr = "172.18.0.5:41000"
+	req.Host = "backend:8080"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "search.example.com")
+	rec := httptest.NewRecorder()
+	h.handleDescription(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `template="https://search.example.com/search?q={searchTerms}"`) ||
+		!strings.Contains(body, `template="https://search.example.com/api/suggest?q={searchTerms}"`) {
+		t.Fatalf("unexpected description:\n%s", body)
+	}
+
+	// Заголовки от недоверенного клиента игнорируются
+	req.RemoteAddr = "203.0.113.7:41000"
+	rec = httptest.NewRecorder()
+	h.handleDescription(rec, req)
+	if !strings.Contains(rec.Body.String(), `template="http://backend:8080/search?q={searchTerms}"`) {
+		t.Fatalf("expected forwarded headers from untrusted peers to be ignored:\n%s", rec.Body.String())
+	}
+}
+
+func TestOpenSearchRedirectsToFrontend(t *testing.T) {
+	h := newTestOpenSearch(t, defaultConfig())
+	rec := httptest.NewRecorder()
+	h.handleSearch(rec, httptest.NewRequest(http.MethodGet, "/search?q=go+generics%3F", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/?q=go+generics%3F" {
+		t.Fatalf("unexpected redirect %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestSuggestProxiesSearxAutocompleter(t *testing.T) {
+	searx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/autocompleter" || r.URL.Query().Get("autocomplete") != "duckduckgo" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`["` + r.URL.Query().Get("q") + `", ["golang", "golang generics", "google"]]`))
+	}))
+	defer searx.Close()
+	cfg := defaultConfig()
+	cfg.Searx.URL = searx.URL
+	cfg.Suggest.MaxSuggestions = 2
+	cfg.Suggest.Burst = 1
+	h := newTestOpenSearch(t, cfg)
+
+	rec := httptest.NewRecorder()
+	h.handleSuggest(rec, httptest.NewRequest(http.MethodGet, "/api/suggest?q=go", nil))
+	var got []any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []any{"go", []any{"golang", "golang generics"}}
+	if !reflect.DeepEqual(got, want) || rec.Header().Get("Content-Type") != "application/x-suggestions+json" {
+		t.Fatalf("unexpected suggestions %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.handleSuggest(rec, httptest.NewRequest(http.MethodGet, "/api/suggest?q=go", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the per-IP limit to apply, got %d", rec.Code)
+	}
+}
+
+func TestParseSearxSuggestionsPlainList(t *testing.T) {
+	got, err := parseSearxSuggestions([]byte(`["golang", "google"]`))
+	if err != nil || !reflect.DeepEqual(got, []string{"golang", "google"}) {
+		t.Fatalf("unexpected %v, %v", got, err)
+	}
+	if _, err := parseSearxSuggestions([]byte(`{"error": "x"}`)); err == nil {
+		t.Fatal("expected an error for an object")
+	}
+}
+
+func TestSuggestLLMNeedsAKey(t *testing.T) {
+	searx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`["go", ["golang"]]`))
+	}))
+	defer searx.Close()
+	openRouter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"go generics tutorial"}}],"usage":{"prompt_tokens":20,"completion_tokens":4,"total_tokens":24}}`))
+	}))
+	defer openRouter.Close()
+	cfg := defaultConfig()
+	cfg.Searx.URL = searx.URL
+	cfg.OpenRouter.APIKey = "test"
+	cfg.OpenRouter.Endpoint = openRouter.URL
+	cfg.Suggest.Provider = suggestProviderLLM
+	cfg.Auth = testAuthConfig()
+	h := newTestOpenSearch(t, cfg)
+
+	suggest := func(key string) []any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/suggest?q=go", nil)
+		if key != "" {
+			req.Header.Set(apiKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.handleSuggest(rec, req)
+		var got []any
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// Без ключа токены OpenRouter не тратятся, подсказки дает SearxNG
+	if got := suggest(""); !reflect.DeepEqual(got, []any{"go", []any{"golang"}}) {
+		t.Fatalf("expected searx suggestions without a key, got %v", got)
+	}
+	if got := suggest("ops-key-0123456789"); !reflect.DeepEqual(got, []any{"go", []any{"golang"}}) {
+		t.Fatalf("expected searx suggestions for a key without the search scope, got %v", got)
+	}
+	if got := suggest("web-key-0123456789"); !reflect.DeepEqual(got, []any{"go", []any{"go generics tutorial"}}) {
+		t.Fatalf("expected generated suggestions for a search key, got %v", got)
+	}
+	p, _ := h.auth.Principal("web")
+	if quota := h.auth.Quota(p); quota.TokensUsed != 24 || quota.SearchesUsed != 0 {
+		t.Fatalf("expected the tokens to be charged to the key, got %+v", quota)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// originPattern is one allowed-origins entry. Accepted forms:
+//   - "*" allows any origin;
+//   - "https://search.example.com" must match scheme, host and port exactly;
+//   - "https://*.example.com" allows any subdomain of example.com, not the apex;
+//   - "localhost" allows this host over any scheme and port.
+//
+// A port of "*" matches any port.
+type originPattern struct {
+	scheme   string // empty: any scheme
+	host     string
+	wildcard bool // host is a suffix, the origin must be a subdomain of it
+	port     string
+	anyPort  bool
+}
+
+func parseOriginPattern(s string) (originPattern, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return originPattern{}, fmt.Errorf("empty origin")
+	}
+	if s == "*" {
+		return originPattern{host: "", wildcard: true, anyPort: true}, nil
+	}
+
+	var p originPattern
+	hostport := s
+	if scheme, rest, ok := strings.Cut(s, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return originPattern{}, fmt.Errorf("origin %q: scheme must be http or https", s)
+		}
+		p.scheme = scheme
+		hostport = rest
+	} else {
+		p.anyPort = true
+	}
+	if strings.ContainsAny(hostport, "/?#@") {
+		return originPattern{}, fmt.Errorf("origin %q must not contain a path, query or credentials", s)
+	}
+
+	host, port := hostport, ""
+	if h, pt, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, pt
+		p.anyPort = pt == "*"
+	} else if p.scheme != "" {
+		port = defaultPort(p.scheme)
+	}
+	if p.scheme == "" && port != "" {
+		return originPattern{}, fmt.Errorf("origin %q: a port needs a scheme", s)
+	}
+
+	if strings.HasPrefix(host, "*.") {
+		p.wildcard = true
+		host = host[1:] // оставляем ведущую точку: ".example.com"
+	}
+	if host == "" || strings.Contains(host, "*") {
+		return originPattern{}, fmt.Errorf("origin %q: wildcards are only allowed as a leading \"*.\"", s)
+	}
+	p.host = host
+	p.port = port
+	return p, nil
+}
+
+func (p originPattern) matches(scheme, host, port string) bool {
+	if p.scheme != "" && p.scheme != scheme {
+		return false
+	}
+	if !p.anyPort && p.port != port {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(host, p.host)
+	}
+	return host == p.host
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// OriginPolicy decides which browser origins may use the API. The same
+// policy backs the WebSocket upgrade check and the CORS handler.
+type OriginPolicy struct {
+	enabled  bool
+	patterns []originPattern
+}
+
+// NewOriginPolicy builds the policy from the websocket config. Invalid
+// entries are skipped here; validateConfig reports them at load time.
+func NewOriginPolicy(cfg WebSocketConfig) OriginPolicy {
+	policy := OriginPolicy{enabled: cfg.EnableOriginCheck}
+	for _, entry := range cfg.AllowedOrigins {
+		if p, err := parseOriginPattern(entry); err == nil {
+			policy.patterns = append(policy.patterns, p)
+		}
+	}
+	return policy
+}
+
+// Allowed reports whether origin (the raw Origin header) is permitted.
+func (p OriginPolicy) Allowed(origin string) bool {
+	if !p.enabled {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(u.Scheme)
+	}
+	host := u.Hostname()
+	for _, pattern := range p.patterns {
+		if pattern.matches(u.Scheme, host, port) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	policy := NewOriginPolicy(WebSocketConfig{
+		EnableOriginCheck: true,
+		AllowedOrigins:    []string{"localhost", "https://search.example.com", "https://*.example.org", "http://127.0.0.1:*"},
+	})
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"http://localhost:5173", true},
+		{"https://localhost", true},
+		{"https://localhost.evil.com", false},
+		{"https://evil-localhost", false},
+		{"https://search.example.com", true},
+		{"https://search.example.com:443", true},
+		{"https://search.example.com:8443", false},
+		{"http://search.example.com", false},
+		{"https://search.example.com.evil.com", false},
+		{"https://app.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://127.0.0.1:9080", true},
+		{"https://127.0.0.1:9080", false},
+		{"null", false},
+		{"file://localhost", false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !NewOriginPolicy(WebSocketConfig{}).Allowed("https://anything.test") {
+		t.Error("disabled origin check must allow every origin")
+	}
+	if !NewOriginPolicy(WebSocketConfig{EnableOriginCheck: true, AllowedOrigins: []string{"*"}}).Allowed("https://anything.test") {
+		t.Error("\"*\" must allow every origin")
+	}
+}
+
+func TestParseOriginPatternRejectsInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "ftp://example.com", "https://example.com/path", "https://ex*ample.com", "localhost:8080", "https://*"} {
+		if _, err := parseOriginPattern(pattern); err == nil {
+			t.Errorf("expected %q to be rejected", pattern)
+		}
+	}
+}
+
+func TestUpgraderOriginCheck(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.WebSocket.EnableOriginCheck = true
+	cfg.WebSocket.AllowedOrigins = []string{"localhost"}
+	upgrader := createUpgrader(cfg)
+
+	r := httptest.NewRequest("GET", "/api/ws/search", nil)
+	r.Header.Set("Origin", "https://localhost.evil.com")
+	if upgrader.CheckOrigin(r) {
+		t.Fatal("substring match must not be accepted")
+	}
+	r.Header.Del("Origin")
+	if !upgrader.CheckOrigin(r) {
+		t.Fatal("non-browser clients without Origin must be accepted")
+	}
+}
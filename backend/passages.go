@@ -0,0 +1,220 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters; the usual defaults work well for short web passages.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// passage is a window of consecutive words taken from extracted page text.
+type passage struct {
+	Index int
+	Text  string
+	Score float64
+	terms []string
+}
+
+// splitPassages breaks flattened page text into overlapping windows of
+// size words. Consecutive windows share half their words so that a relevant
+// sentence is never cut in two without also appearing whole in a neighbour.
+func splitPassages(text string, size int) []passage {
+	words := strings.Fields(text)
+	if len(words) == 0 || size <= 0 {
+		return nil
+	}
+	if len(words) <= size {
+		return []passage{{Index: 0, Text: strings.Join(words, " ")}}
+	}
+
+	stride := size / 2
+	if stride == 0 {
+		stride = 1
+	}
+
+	var out []passage
+	for start := 0; start < len(words); start += stride {
+		end := start + size
+		if end > len(words) {
+			end = len(words)
+		}
+		out = append(out, passage{Index: len(out), Text: strings.Join(words[start:end], " ")})
+		if end == len(words) {
+			break
+		}
+	}
+	return out
+}
+
+// tokenize lowercases s and splits it into letter/digit terms, dropping
+// one-character tokens and common stopwords.
+func tokenize(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := fields[:0]
+	for _, f := range fields {
+		if len([]rune(f)) < 2 || stopwords[f] {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "how": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "that": true, "the": true, "this": true, "to": true, "was": true, "what": true,
+	"when": true, "where": true, "which": true, "who": true, "why": true, "with": true,
+	"и": true, "в": true, "во": true, "на": true, "не": true, "что": true, "как": true, "с": true,
+	"по": true, "для": true, "из": true, "от": true, "до": true, "это": true, "или": true, "но": true,
+}
+
+// scorePassagesBM25 scores each passage against the query terms using BM25,
+// treating the passages of a single page as the corpus.
+func scorePassagesBM25(passages []passage, queryTerms []string) {
+	if len(passages) == 0 || len(queryTerms) == 0 {
+		return
+	}
+
+	docFreq := make(map[string]int)
+	totalLen := 0
+	for i := range passages {
+		passages[i].terms = tokenize(passages[i].Text)
+		totalLen += len(passages[i].terms)
+		seen := make(map[string]bool)
+		for _, t := range passages[i].terms {
+			if !seen[t] {
+				seen[t] = true
+				docFreq[t]++
+			}
+		}
+	}
+	avgLen := float64(totalLen) / float64(len(passages))
+	if avgLen == 0 {
+		return
+	}
+
+	uniq := make(map[string]bool, len(queryTerms))
+	for _, t := range queryTerms {
+		uniq[t] = true
+	}
+
+	n := float64(len(passages))
+	for i := range passages {
+		tf := make(map[string]int)
+		for _, t := range passages[i].terms {
+			if uniq[t] {
+				tf[t]++
+			}
+		}
+		dl := float64(len(passages[i].terms))
+		score := 0.0
+		for t, f := range tf {
+			df := float64(docFreq[t])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			freq := float64(f)
+			score += idf * freq * (bm25K1 + 1) / (freq + bm25K1*(1-bm25B+bm25B*dl/avgLen))
+		}
+		passages[i].Score = score
+	}
+}
+
+// selectPassages returns up to limit passages of text that best match the
+// prompt and the generated queries, ordered by their position on the page.
+// When nothing matches, the leading passages are returned so the judge still
+// sees the start of the page.
+func selectPassages(text, prompt string, queries []string, size, limit int) []passage {
+	passages := splitPassages(text, size)
+	if len(passages) == 0 || limit <= 0 {
+		return nil
+	}
+
+	queryTerms := tokenize(prompt)
+	for _, q := range queries {
+		queryTerms = append(queryTerms, tokenize(q)...)
+	}
+	scorePassagesBM25(passages, queryTerms)
+
+	ranked := make([]passage, len(passages))
+	copy(ranked, passages)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].Index < ranked[j].Index
+	})
+	return ranked
+}
+
+// bestPassage returns the highest-scoring passage, or false if none scored.
+func bestPassage(passages []passage) (passage, bool) {
+	best := -1
+	for i, p := range passages {
+		if p.Score > 0 && (best == -1 || p.Score > passages[best].Score) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return passage{}, false
+	}
+	return passages[best], true
+}
+
+// passageSeparator joins passages in the excerpt sent to the LLM judge.
+const passageSeparator = "\n…\n"
+
+// fitPassages keeps the highest-scoring passages whose joined text fits in
+// budget characters, returned in page order. The best passage is always
+// kept, cut to the budget if it is longer on its own.
+func fitPassages(passages []passage, budget int) []passage {
+	ranked := make([]passage, len(passages))
+	copy(ranked, passages)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	var kept []passage
+	used := 0
+	for _, p := range ranked {
+		n := utf8.RuneCountInString(p.Text)
+		if len(kept) > 0 {
+			n += utf8.RuneCountInString(passageSeparator)
+		}
+		if used+n <= budget {
+			kept = append(kept, p)
+			used += n
+			continue
+		}
+		if len(kept) == 0 && budget > 0 {
+			// Считаем символы, а не байты, чтобы не разрезать руну
+			p.Text = string([]rune(p.Text)[:budget])
+			kept = append(kept, p)
+			used = budget
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].Index < kept[j].Index
+	})
+	return kept
+}
+
+// joinPassages concatenates passages into a single excerpt for the LLM judge.
+func joinPassages(passages []passage) string {
+	parts := make([]string, 0, len(passages))
+	for _, p := range passages {
+		parts = append(parts, p.Text)
+	}
+	return strings.Join(parts, passageSeparator)
+}
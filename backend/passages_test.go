@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitPassagesOverlap(t *testing.T) {
+	text := strings.Repeat("word ", 25)
+	passages := splitPassages(text, 10)
+	if len(passages) != 4 {
+		t.Fatalf("expected 4 overlapping passages, got %d", len(passages))
+	}
+	for i, p := range passages {
+		if p.Index != i {
+			t.Fatalf("passage %d has index %d", i, p.Index)
+		}
+	}
+	if n := len(strings.Fields(passages[3].Text)); n != 10 {
+		t.Fatalf("expected last passage to reach end of text with 10 words, got %d", n)
+	}
+}
+
+func TestSelectPassagesFindsDeepMatch(t *testing.T) {
+	filler := strings.Repeat("lorem ipsum dolor sit amet consectetur ", 200)
+	text := filler + "the goroutine scheduler preempts long running goroutines " + filler
+
+	passages := selectPassages(text, "how does the Go scheduler preempt goroutines", []string{"goroutine preemption"}, 50, 2)
+	if len(passages) != 2 {
+		t.Fatalf("expected 2 passages, got %d", len(passages))
+	}
+	best, ok := bestPassage(passages)
+	if !ok {
+		t.Fatalf("expected a scored passage")
+	}
+	if !strings.Contains(best.Text, "scheduler preempts") {
+		t.Fatalf("best passage does not contain the match: %q", best.Text)
+	}
+	if passages[0].Index > passages[1].Index {
+		t.Fatalf("expected passages in page order")
+	}
+}
+
+func TestSelectPassagesNoMatch(t *testing.T) {
+	passages := selectPassages("alpha beta gamma delta", "unrelated", nil, 2, 1)
+	if len(passages) != 1 || passages[0].Index != 0 {
+		t.Fatalf("expected leading passage when nothing matches, got %+v", passages)
+	}
+	if _, ok := bestPassage(passages); ok {
+		t.Fatalf("expected no best passage without matches")
+	}
+}
+
+func TestFitPassagesKeepsDeepCyrillicMatch(t *testing.T) {
+	filler := strings.Repeat("описание интерфейса пользователя приложения ", 150)
+	text := filler + "планировщик горутин вытесняет долго работающие горутины"
+
+	cfg := defaultConfig().Content
+	passages := selectPassages(text, "как планировщик вытесняет горутины", nil, cfg.PassageWords, cfg.MaxPassages)
+	if len(passages) < 2 {
+		t.Fatalf("expected several passages, got %d", len(passages))
+	}
+	best, _ := bestPassage(passages)
+	if best.Index != passages[len(passages)-1].Index {
+		t.Fatalf("expected the best passage to be the last one on the page")
+	}
+	budget := cfg.TruncationLength
+	if joined := joinPassages(passages); utf8.RuneCountInString(joined) <= budget {
+		t.Fatalf("expected the passages to exceed the budget, got %d characters", utf8.RuneCountInString(joined))
+	}
+
+	fitted := fitPassages(passages, budget)
+	excerpt := joinPassages(fitted)
+	if n := utf8.RuneCountInString(excerpt); n > budget || !utf8.ValidString(excerpt) {
+		t.Fatalf("expected a valid excerpt of at most %d characters, got %d", budget, n)
+	}
+	if !strings.Contains(excerpt, "вытесняет долго работающие горутины") {
+		t.Fatalf("expected the deepest, best passage to be kept:\n%s", excerpt)
+	}
+	for i := 1; i < len(fitted); i++ {
+		if fitted[i-1].Index > fitted[i].Index {
+			t.Fatal("expected fitted passages in page order")
+		}
+	}
+}
+
+func TestFitPassagesCutsLongPassageByRunes(t *testing.T) {
+	fitted := fitPassages([]passage{{Text: strings.Repeat("ё", 50), Score: 1}}, 10)
+	if len(fitted) != 1 || fitted[0].Text != strings.Repeat("ё", 10) {
+		t.Fatalf("expected the passage cut to 10 runes, got %+v", fitted)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var ErrRateLimited = &AppError{
+	Code:    "RATE_LIMITED",
+	Message: "Too many searches, slow down",
+	Status:  http.StatusTooManyRequests,
+}
+
+// rateLimitedError builds a RATE_LIMITED error that tells the client when
+// to retry.
+func rateLimitedError(details string, retryAfter time.Duration) *AppError {
+	err := NewAppError(ErrRateLimited.Code, ErrRateLimited.Message, details, ErrRateLimited.Status)
+	err.RetryAfter = retryAfter
+	return err
+}
+
+// idleLimiterTTL is how long an unused bucket is kept before it is dropped.
+const idleLimiterTTL = 10 * time.Minute
+
+type clientLimiter struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter keeps one token bucket per client. Buckets pick up new rates
+// from the config on their next use, so reloads apply without a restart.
+type RateLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{clients: make(map[string]*clientLimiter), now: time.Now}
+}
+
+// Allow takes one token from the client's bucket. When the bucket is empty
+// it returns false and how long until a token is available.
+func (l *RateLimiter) Allow(client string, cfg RateLimitConfig) (bool, time.Duration) {
+	if !cfg.Enabled {
+		return true, 0
+	}
+	limit := rate.Limit(cfg.SearchesPerMinute / 60)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{lim: rate.NewLimiter(limit, cfg.Burst)}
+		l.clients[client] = c
+	} else if c.lim.Limit() != limit || c.lim.Burst() != cfg.Burst {
+		c.lim.SetLimitAt(now, limit)
+		c.lim.SetBurstAt(now, cfg.Burst)
+	}
+	c.lastSeen = now
+
+	r := c.lim.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Minute
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweepLocked drops buckets that have not been used for idleLimiterTTL.
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < idleLimiterTTL {
+		return
+	}
+	l.lastSweep = now
+	for k, c := range l.clients {
+		if now.Sub(c.lastSeen) > idleLimiterTTL {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// rateLimitKey identifies the client a search is charged to: the API key
+// name when authentication is on, the client IP otherwise.
+func rateLimitKey(p *Principal, r *http.Request, cfg RateLimitConfig) string {
+	if p != nil {
+		return "key:" + p.Name
+	}
+	return "ip:" + clientIP(r, cfg.TrustedProxies)
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only
+// honoured when the direct peer is a trusted proxy; the list is walked from
+// the right and the first address outside the trusted ranges wins.
+func clientIP(r *http.Request, trusted []string) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	prefixes := parseTrustedProxies(trusted)
+	if !isTrustedProxy(host, prefixes) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop, prefixes) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func parseTrustedProxies(cidrs []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if p, err := parseCIDROrIP(cidr); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// parseCIDROrIP accepts either a CIDR range or a single address.
+func parseCIDROrIP(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func isTrustedProxy(ip string, prefixes []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfterSeconds rounds d up to whole seconds for the Retry-After header.
+func retryAfterSeconds(d time.Duration) string {
+	return fmt.Sprintf("%d", int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	cfg := RateLimitConfig{Enabled: true, SearchesPerMinute: 60, Burst: 2}
+	l := NewRateLimiter()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("ip:1.2.3.4", cfg); !ok {
+			t.Fatalf("burst request %d rejected", i+1)
+		}
+	}
+	ok, retry := l.Allow("ip:1.2.3.4", cfg)
+	if ok || retry <= 0 || retry > time.Second {
+		t.Fatalf("expected rejection with retry within 1s, got %v %s", ok, retry)
+	}
+	if ok, _ := l.Allow("ip:5.6.7.8", cfg); !ok {
+		t.Fatal("other clients must have their own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("ip:1.2.3.4", cfg); !ok {
+		t.Fatal("expected a token to be refilled after one second")
+	}
+
+	cfg.Enabled = false
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow("ip:1.2.3.4", cfg); !ok {
+			t.Fatal("disabled limiter must allow everything")
+		}
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "127.0.0.1"}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.5:4321"
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 203.0.113.7, 10.0.0.9")
+	if ip := clientIP(r, trusted); ip != "203.0.113.7" {
+		t.Fatalf("expected first untrusted hop from the right, got %s", ip)
+	}
+
+	r.RemoteAddr = "198.51.100.1:4321"
+	if ip := clientIP(r, trusted); ip != "198.51.100.1" {
+		t.Fatalf("X-Forwarded-For from an untrusted peer must be ignored, got %s", ip)
+	}
+
+	if key := rateLimitKey(&Principal{Name: "web"}, r, RateLimitConfig{}); key != "key:web" {
+		t.Fatalf("expected API key name to identify the client, got %s", key)
+	}
+}
+
+func TestAdmitSearchConcurrencyCap(t *testing.T) {
+	cfg := RateLimitConfig{Enabled: true, SearchesPerMinute: 600, Burst: 10, MaxConcurrentPerConnection: 2}
+	l := NewRateLimiter()
+	var active atomic.Int32
+
+	for i := 0; i < 2; i++ {
+		if err := admitSearch(&active, l, "ip:1.2.3.4", cfg); err != nil {
+			t.Fatalf("search %d rejected: %v", i+1, err)
+		}
+	}
+	err := admitSearch(&active, l, "ip:1.2.3.4", cfg)
+	if err == nil || err.Code != ErrRateLimited.Code || err.RetryAfter <= 0 {
+		t.Fatalf("expected RATE_LIMITED with retry-after, got %v", err)
+	}
+
+	active.Add(-1)
+	if err := admitSearch(&active, l, "ip:1.2.3.4", cfg); err != nil {
+		t.Fatalf("expected a freed slot to be reusable, got %v", err)
+	}
+}
+
+func TestClientIPIgnoresPrivatePeersByDefault(t *testing.T) {
+	// Контейнер из той же сети не является доверенным прокси
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "172.28.0.7:4321"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if ip := clientIP(r, defaultConfig().RateLimit.TrustedProxies); ip != "172.28.0.7" {
+		t.Fatalf("X-Forwarded-For from an untrusted private peer must be ignored, got %s", ip)
+	}
+
+	r.RemoteAddr = "127.0.0.1:4321"
+	if ip := clientIP(r, defaultConfig().RateLimit.TrustedProxies); ip != "203.0.113.7" {
+		t.Fatalf("expected X-Forwarded-For from loopback to be honoured, got %s", ip)
+	}
+}
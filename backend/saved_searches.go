@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Виды изменений в результатах сохраненного поиска
+const (
+	changeNew     = "new"
+	changeUpdated = "changed"
+)
+
+// Исходы запуска сохраненного поиска
+const (
+	savedRunCompleted = "completed"
+	savedRunFailed    = "failed"
+)
+
+const (
+	// savedSearchTick is how often the scheduler looks for due searches
+	savedSearchTick     = time.Minute
+	savedSearchIDHeader = "X-Saved-Search-ID"
+	atomXMLNS           = "http://www.w3.org/2005/Atom"
+)
+
+var (
+	ErrSavedSearchNotFound = &AppError{
+		Code:    "SAVED_SEARCH_NOT_FOUND",
+		Message: "Saved search was not found",
+		Status:  http.StatusNotFound,
+	}
+	ErrSavedSearchesDisabled = &AppError{
+		Code:    "SAVED_SEARCHES_DISABLED",
+		Message: "Saved searches are disabled on this server",
+		Status:  http.StatusNotFound,
+	}
+	ErrTooManySavedSearches = &AppError{
+		Code:    "TOO_MANY_SAVED_SEARCHES",
+		Message: "This key has reached its saved search limit",
+		Status:  http.StatusConflict,
+	}
+	ErrSavedSearchStorage = &AppError{
+		Code:    "SAVED_SEARCH_STORAGE_FAILED",
+		Message: "Saved search storage failed",
+		Status:  http.StatusInternalServerError,
+	}
+	ErrInvalidSchedule = &AppError{
+		Code:    "INVALID_SCHEDULE",
+		Message: "Schedule interval is not acceptable",
+		Status:  http.StatusBadRequest,
+	}
+)
+
+// SavedSearchRequest is the body of POST /api/saved-searches.
+type SavedSearchRequest struct {
+	Name     string   `json:"name,omitempty"`
+	Prompt   string   `json:"prompt"`
+	Settings Settings `json:"settings"`
+	Locale   string   `json:"locale,omitempty"`
+	// Interval is a duration such as "6h" between runs
+	Interval string `json:"interval"`
+	// WebhookURL receives new and changed results as a signed POST
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// SavedSearchChange is a result that is new or whose title or snippet
+// changed since the previous run.
+type SavedSearchChange struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Rank int    `json:"rank"`
+	// PreviousRank is only set for changed results
+	PreviousRank int          `json:"previous_rank,omitempty"`
+	Result       SearchResult `json:"result"`
+	DetectedAt   time.Time    `json:"detected_at"`
+}
+
+// SavedSearchRun summarizes the latest run of a saved search.
+type SavedSearchRun struct {
+	StartedAt     time.Time `json:"started_at"`
+	Outcome       string    `json:"outcome"`
+	Error         *WSError  `json:"error,omitempty"`
+	Results       int       `json:"results"`
+	New           int       `json:"new"`
+	Changed       int       `json:"changed"`
+	WebhookStatus string    `json:"webhook_status,omitempty"`
+}
+
+// SavedSearchView is the API representation of a saved search. Results, the
+// ranked list of the last successful run, is only returned for a single
+// saved search.
+type SavedSearchView struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name,omitempty"`
+	Prompt     string          `json:"prompt"`
+	Settings   Settings        `json:"settings"`
+	Locale     string          `json:"locale,omitempty"`
+	Interval   string          `json:"interval"`
+	WebhookURL string          `json:"webhook_url,omitempty"`
+	FeedURL    string          `json:"feed_url,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	NextRunAt  time.Time       `json:"next_run_at"`
+	LastRun    *SavedSearchRun `json:"last_run,omitempty"`
+	Results    []SearchResult  `json:"results,omitempty"`
+}
+
+// SavedSearchAlert is the webhook body sent when a run finds changes.
+type SavedSearchAlert struct {
+	SavedSearch SavedSearchView     `json:"saved_search"`
+	Run         SavedSearchRun      `json:"run"`
+	Changes     []SavedSearchChange `json:"changes"`
+}
+
+// savedSearch is what the store persists for one saved search.
+type savedSearch struct {
+	ID         string        `json:"id"`
+	Owner      string        `json:"owner"`
+	Name       string        `json:"name,omitempty"`
+	Prompt     string        `json:"prompt"`
+	Settings   Settings      `json:"settings"`
+	Locale     string        `json:"locale,omitempty"`
+	Interval   time.Duration `json:"interval"`
+	WebhookURL string        `json:"webhook_url,omitempty"`
+	// FeedToken authorizes the Atom feed, which readers fetch without a key
+	FeedToken string          `json:"feed_token"`
+	CreatedAt time.Time       `json:"created_at"`
+	NextRunAt time.Time       `json:"next_run_at"`
+	LastRun   *SavedSearchRun `json:"last_run,omitempty"`
+	// HasBaseline is set after the first successful run; Results are what
+	// the next run is compared with
+	HasBaseline bool           `json:"has_baseline"`
+	Results     []SearchResult `json:"results,omitempty"`
+	// Changes lists detected changes, newest first
+	Changes []SavedSearchChange `json:"changes,omitempty"`
+}
+
+// view renders s for the API. The feed URL is omitted when baseURL is empty,
+// as in webhook alerts while server.public_url is unset.
+func (s *savedSearch) view(baseURL string) SavedSearchView {
+	v := SavedSearchView{
+		ID:         s.ID,
+		Name:       s.Name,
+		Prompt:     s.Prompt,
+		Settings:   s.Settings,
+		Locale:     s.Locale,
+		Interval:   s.Interval.String(),
+		WebhookURL: s.WebhookURL,
+		CreatedAt:  s.CreatedAt,
+		NextRunAt:  s.NextRunAt,
+		LastRun:    s.LastRun,
+	}
+	if baseURL != "" {
+		v.FeedURL = baseURL + "/api/feeds/" + s.FeedToken
+	}
+	return v
+}
+
+// diffResults returns the results of next that are missing from prev or
+// whose title or snippet differs, matching URLs by canonicalURL.
+func diffResults(prev, next []SearchResult, now time.Time) []SavedSearchChange {
+	type previous struct {
+		rank   int
+		result SearchResult
+	}
+	old := make(map[string]previous, len(prev))
+	for i, r := range prev {
+		if key := canonicalURL(r.URL); old[key].rank == 0 {
+			old[key] = previous{rank: i + 1, result: r}
+		}
+	}
+
+	var changes []SavedSearchChange
+	seen := make(map[string]bool, len(next))
+	for i, r := range next {
+		key := canonicalURL(r.URL)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		change := SavedSearchChange{ID: randomHex(8), Rank: i + 1, Result: r, DetectedAt: now}
+		p, ok := old[key]
+		switch {
+		case !ok:
+			change.Kind = changeNew
+		case p.result.Title != r.Title || p.result.Snippet != r.Snippet:
+			change.Kind, change.PreviousRank = changeUpdated, p.rank
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// SavedSearchStore persists saved searches, scoped to an owner like
+// HistoryStore.
+type SavedSearchStore interface {
+	Put(s *savedSearch) error
+	// Get returns nil when owner has no saved search with id
+	Get(owner, id string) (*savedSearch, error)
+	List(owner string) ([]*savedSearch, error)
+	Delete(owner, id string) (bool, error)
+	// All returns every saved search for the scheduler
+	All() ([]*savedSearch, error)
+	// ByFeedToken returns nil when no saved search has the token
+	ByFeedToken(token string) (*savedSearch, error)
+	Close() error
+}
+
+var (
+	savedSearchBucket = []byte("saved_searches")
+	// savedFeedBucket maps feed tokens to saved search keys
+	savedFeedBucket = []byte("feeds")
+)
+
+// boltSavedSearchStore keeps saved searches as JSON under
+// "<owner>\x00<id>" keys, like boltHistoryStore.
+type boltSavedSearchStore struct {
+	db *bbolt.DB
+}
+
+func openBoltSavedSearchStore(path string) (*boltSavedSearchStore, error) {
+	db, err := openBolt(path, savedSearchBucket, savedFeedBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &boltSavedSearchStore{db: db}, nil
+}
+
+func (s *boltSavedSearchStore) Put(saved *savedSearch) error {
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	key := historyKey(saved.Owner, saved.ID)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(savedSearchBucket).Put(key, data); err != nil {
+			return err
+		}
+		return tx.Bucket(savedFeedBucket).Put([]byte(saved.FeedToken), key)
+	})
+}
+
+func (s *boltSavedSearchStore) Get(owner, id string) (*savedSearch, error) {
+	var saved *savedSearch
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		saved, err = decodeSavedSearch(tx.Bucket(savedSearchBucket).Get(historyKey(owner, id)))
+		return err
+	})
+	return saved, err
+}
+
+func (s *boltSavedSearchStore) List(owner string) ([]*savedSearch, error) {
+	prefix := historyKey(owner, "")
+	var list []*savedSearch
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(savedSearchBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			saved, err := decodeSavedSearch(v)
+			if err != nil {
+				return err
+			}
+			list = append(list, saved)
+		}
+		return nil
+	})
+	return list, err
+}
+
+func (s *boltSavedSearchStore) Delete(owner, id string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(savedSearchBucket)
+		key := historyKey(owner, id)
+		saved, err := decodeSavedSearch(b.Get(key))
+		if err != nil || saved == nil {
+			return err
+		}
+		found = true
+		if err := tx.Bucket(savedFeedBucket).Delete([]byte(saved.FeedToken)); err != nil {
+			return err
+		}
+		return b.Delete(key)
+	})
+	return found, err
+}
+
+func (s *boltSavedSearchStore) All() ([]*savedSearch, error) {
+	var list []*savedSearch
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(savedSearchBucket).ForEach(func(_, v []byte) error {
+			saved, err := decodeSavedSearch(v)
+			if err != nil {
+				return err
+			}
+			list = append(list, saved)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *boltSavedSearchStore) ByFeedToken(token string) (*savedSearch, error) {
+	var saved *savedSearch
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		key := tx.Bucket(savedFeedBucket).Get([]byte(token))
+		if key == nil {
+			return nil
+		}
+		var err error
+		saved, err = decodeSavedSearch(tx.Bucket(savedSearchBucket).Get(key))
+		return err
+	})
+	return saved, err
+}
+
+func (s *boltSavedSearchStore) Close() error {
+	return s.db.Close()
+}
+
+func decodeSavedSearch(data []byte) (*savedSearch, error) {
+	if data == nil {
+		return nil, nil
+	}
+	saved := &savedSearch{}
+	return saved, json.Unmarshal(data, saved)
+}
+
+// SavedSearchManager runs saved searches on their schedule, diffs each run
+// against the previous one and delivers the changes by webhook and Atom
+// feed. A nil *SavedSearchManager runs nothing and its endpoints report that
+// saved searches are disabled.
+type SavedSearchManager struct {
+	store   *ConfigStore
+	auth    *Authenticator
+	conns   *ConnManager
+	slots   *SearchSlots
+	history *SearchHistory
+	db      SavedSearchStore
+	logger  *Logger
+	now     func() time.Time
+
+	mu      sync.Mutex
+	running map[string]bool
+	// runs tracks the goroutines started by startDue
+	runs sync.WaitGroup
+}
+
+func NewSavedSearchManager(store *ConfigStore, auth *Authenticator, conns *ConnManager, slots *SearchSlots, history *SearchHistory, db SavedSearchStore, logger *Logger) *SavedSearchManager {
+	return &SavedSearchManager{
+		store:   store,
+		auth:    auth,
+		conns:   conns,
+		slots:   slots,
+		history: history,
+		db:      db,
+		logger:  logger,
+		now:     time.Now,
+		running: make(map[string]bool),
+	}
+}
+
+// OpenSavedSearches opens the database at saved_searches.path, or returns
+// nil when saved searches are disabled.
+func OpenSavedSearches(store *ConfigStore, auth *Authenticator, conns *ConnManager, slots *SearchSlots, history *SearchHistory, logger *Logger) (*SavedSearchManager, error) {
+	cfg := store.Load().Saved
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	db, err := openBoltSavedSearchStore(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSavedSearchManager(store, auth, conns, slots, history, db, logger), nil
+}
+
+func (m *SavedSearchManager) Close() error {
+	if m == nil {
+		return nil
+	}
+	return m.db.Close()
+}
+
+// Run starts due saved searches every minute until ctx is done. Runs are
+// searches for graceful shutdown, so draining waits for them. Run returns
+// only after the runs it started have finished, so the database may be
+// closed once it has returned.
+func (m *SavedSearchManager) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	defer m.runs.Wait()
+	ticker := time.NewTicker(savedSearchTick)
+	defer ticker.Stop()
+	for {
+		m.startDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startDue launches every saved search whose next run has come. The next run
+// is scheduled before the search starts, so a restart does not repeat it.
+func (m *SavedSearchManager) startDue(ctx context.Context) {
+	all, err := m.db.All()
+	if err != nil {
+		m.logger.Error("failed to load saved searches", "error", err)
+		return
+	}
+	now := m.now()
+	for _, saved := range all {
+		if saved.NextRunAt.After(now) || !m.begin(saved.ID) {
+			continue
+		}
+		saved.NextRunAt = now.Add(saved.Interval)
+		if err := m.db.Put(saved); err != nil {
+			m.logger.Error("failed to schedule saved search", "saved_search_id", saved.ID, "error", err)
+			m.end(saved.ID)
+			continue
+		}
+		m.runs.Add(1)
+		go func() {
+			defer m.runs.Done()
+			defer m.end(saved.ID)
+			m.run(ctx, saved)
+		}()
+	}
+}
+
+// begin marks id as running unless a previous run is still going.
+func (m *SavedSearchManager) begin(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running[id] {
+		return false
+	}
+	m.running[id] = true
+	return true
+}
+
+func (m *SavedSearchManager) end(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, id)
+}
+
+// run executes one scheduled run: it searches, diffs the ranked results
+// against the previous successful run, delivers the changes and stores the
+// new baseline.
+func (m *SavedSearchManager) run(ctx context.Context, saved *savedSearch) {
+	cfg := m.store.Load()
+	logger := &Logger{Logger: m.logger.With("saved_search_id", saved.ID)}
+	run := SavedSearchRun{StartedAt: m.now(), Outcome: savedRunCompleted}
+
+	result, wsErr := m.search(ctx, saved, cfg, logger)
+	if wsErr != nil && wsErr.Code == ErrShuttingDown.Code {
+		m.reschedule(saved, run.StartedAt, logger)
+		return
+	}
+	var changes []SavedSearchChange
+	if wsErr != nil {
+		run.Outcome, run.Error = savedRunFailed, wsErr
+	} else {
+		run.Results = len(result.Results)
+		// Первый успешный запуск только задает базу для сравнения
+		if saved.HasBaseline {
+			changes = diffResults(saved.Results, result.Results, m.now())
+		}
+		for _, c := range changes {
+			if c.Kind == changeNew {
+				run.New++
+			} else {
+				run.Changed++
+			}
+		}
+	}
+	savedSearchRunsTotal.WithLabelValues(run.Outcome).Inc()
+
+	if len(changes) > 0 && saved.WebhookURL != "" {
+		run.WebhookStatus = m.deliverAlert(ctx, saved, run, changes, cfg, logger)
+	}
+
+	// Поиск мог быть удален или изменен, пока шел запуск
+	current, err := m.db.Get(saved.Owner, saved.ID)
+	if err != nil || current == nil {
+		if err != nil {
+			logger.Error("failed to load saved search", "error", err)
+		}
+		return
+	}
+	current.LastRun = &run
+	if wsErr == nil {
+		current.HasBaseline = true
+		current.Results = result.Results
+		current.Changes = append(changes, current.Changes...)
+		if len(current.Changes) > cfg.Saved.FeedItems {
+			current.Changes = current.Changes[:cfg.Saved.FeedItems]
+		}
+	}
+	if err := m.db.Put(current); err != nil {
+		logger.Error("failed to store saved search run", "error", err)
+		return
+	}
+	logger.Info("saved search ran", "outcome", run.Outcome, "results", run.Results, "new", run.New, "changed", run.Changed)
+}
+
+// reschedule sets the next run of a search interrupted by shutdown back to
+// at, so that it runs again after the restart.
+func (m *SavedSearchManager) reschedule(saved *savedSearch, at time.Time, logger *Logger) {
+	current, err := m.db.Get(saved.Owner, saved.ID)
+	if err != nil || current == nil {
+		return
+	}
+	current.NextRunAt = at
+	if err := m.db.Put(current); err != nil {
+		logger.Error("failed to reschedule saved search", "error", err)
+		return
+	}
+	logger.Info("saved search run interrupted by shutdown, rescheduled")
+}
+
+// search runs the pipeline on behalf of the key that saved the search and
+// charges it to that key's quota.
+func (m *SavedSearchManager) search(ctx context.Context, saved *savedSearch, cfg AppConfig, logger *Logger) (*WSSearchResult, *WSError) {
+	principal, ok := m.auth.Principal(saved.Owner)
+	if !ok || (principal != nil && !principal.HasScope(scopeSearch)) {
+		return nil, &WSError{Code: ErrForbidden.Code, Message: ErrForbidden.Message,
+			Details: fmt.Sprintf("key %q no longer exists or lacks scope %q", saved.Owner, scopeSearch)}
+	}
+	searchReq := SearchRequest{Prompt: saved.Prompt, Settings: saved.Settings}
+	// Лимиты могли измениться после сохранения
+	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
+		return nil, &WSError{Code: "VALIDATION_FAILED", Message: "Request validation failed", Details: validationErrors.Error()}
+	}
+	if !m.conns.BeginSearch() {
+		return nil, &WSError{Code: ErrShuttingDown.Code, Message: ErrShuttingDown.Message}
+	}
+	defer m.conns.EndSearch()
+	if appErr := m.auth.BeginSearch(principal); appErr != nil {
+		return nil, &WSError{Code: appErr.Code, Message: appErr.Message, Details: appErr.Details}
+	}
+
+	ctx = contextWithPrincipal(ctx, principal)
+	if locale := supportedLocale(saved.Locale); locale != "" {
+		ctx = contextWithLocale(ctx, locale)
+	}
+	searchCtx, cancel := m.conns.SearchContext(ctx, cfg.WebSocket.SearchTimeout)
+	defer cancel()
+
+	var out resultCollector
+	executeSearch(searchCtx, &out, m.auth, m.slots, m.history, searchSourceSaved, searchReq, cfg, logger, attribute.String("saved_search.id", saved.ID))
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	switch {
+	case out.result != nil:
+		return out.result, nil
+	case out.err != nil:
+		return nil, out.err
+	case errors.Is(searchCtx.Err(), context.DeadlineExceeded):
+		return nil, &WSError{Code: "TIMEOUT", Message: "Search did not finish in time"}
+	default:
+		return nil, &WSError{Code: ErrShuttingDown.Code, Message: ErrShuttingDown.Message}
+	}
+}
+
+// deliverAlert POSTs the changes to the webhook, signed like job callbacks.
+func (m *SavedSearchManager) deliverAlert(ctx context.Context, saved *savedSearch, run SavedSearchRun, changes []SavedSearchChange, cfg AppConfig, logger *Logger) string {
+	body, err := json.Marshal(SavedSearchAlert{
+		SavedSearch: saved.view(strings.TrimRight(cfg.Server.PublicURL, "/")),
+		Run:         run,
+		Changes:     changes,
+	})
+	if err != nil {
+		logger.Error("failed to encode saved search alert", "error", err)
+		return callbackFailed
+	}
+	status := callbackFailed
+	if deliverSigned(ctx, saved.WebhookURL, http.Header{savedSearchIDHeader: {saved.ID}}, body, cfg, m.now, logger) {
+		status = callbackDelivered
+	}
+	savedSearchWebhooksTotal.WithLabelValues(status).Inc()
+	return status
+}
+
+// Routes mounts the saved search endpoints. The feed is mounted separately
+// because feed readers cannot send an API key.
+func (m *SavedSearchManager) Routes(r chi.Router) {
+	r.Post("/", m.handleCreate)
+	r.Get("/", m.handleList)
+	r.Get("/{id}", m.handleGet)
+	r.Delete("/{id}", m.handleDelete)
+}
+
+func (m *SavedSearchManager) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		ErrorResponse(w, ErrSavedSearchesDisabled)
+		return
+	}
+	cfg := m.store.Load()
+	owner := principalName(principalFromContext(r.Context()))
+
+	var req SavedSearchRequest
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.WebSocket.MaxMessageSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ErrorResponse(w, WrapError(ErrInvalidRequest, err))
+		return
+	}
+	searchReq := SearchRequest{Prompt: req.Prompt, Settings: req.Settings}
+	SanitizeSearchRequest(&searchReq)
+	if searchReq.Settings.Queries == 0 {
+		searchReq.Settings.Queries = cfg.Search.DefaultQueryCount
+	}
+	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
+		ErrorResponse(w, NewAppError("VALIDATION_FAILED", "Request validation failed", validationErrors.Error(), http.StatusBadRequest))
+		return
+	}
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil || interval < cfg.Saved.MinInterval {
+		ErrorResponse(w, NewAppError(ErrInvalidSchedule.Code, ErrInvalidSchedule.Message,
+			fmt.Sprintf("interval must be a duration of at least %s, got %q", cfg.Saved.MinInterval, req.Interval), ErrInvalidSchedule.Status))
+		return
+	}
+	if req.WebhookURL != "" {
+		if appErr := validateCallbackURL(req.WebhookURL, cfg); appErr != nil {
+			ErrorResponse(w, appErr)
+			return
+		}
+	}
+
+	existing, err := m.db.List(owner)
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrSavedSearchStorage, err))
+		return
+	}
+	if len(existing) >= cfg.Saved.MaxPerKey {
+		ErrorResponse(w, ErrTooManySavedSearches)
+		return
+	}
+
+	now := m.now()
+	saved := &savedSearch{
+		ID:         randomHex(12),
+		Owner:      owner,
+		Name:       strings.TrimSpace(req.Name),
+		Prompt:     searchReq.Prompt,
+		Settings:   searchReq.Settings,
+		Locale:     supportedLocale(req.Locale),
+		Interval:   interval,
+		WebhookURL: req.WebhookURL,
+		FeedToken:  randomHex(16),
+		CreatedAt:  now,
+		// Первый запуск на ближайшем тике планировщика задает базу сравнения
+		NextRunAt: now,
+	}
+	if err := m.db.Put(saved); err != nil {
+		ErrorResponse(w, WrapError(ErrSavedSearchStorage, err))
+		return
+	}
+
+	m.logger.Info("saved search created", "saved_search_id", saved.ID, "api_key", owner, "interval", interval.String())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/saved-searches/"+saved.ID)
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(saved.view(publicBaseURL(r, cfg)))
+}
+
+func (m *SavedSearchManager) handleList(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		ErrorResponse(w, ErrSavedSearchesDisabled)
+		return
+	}
+	list, err := m.db.List(principalName(principalFromContext(r.Context())))
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrSavedSearchStorage, err))
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	baseURL := publicBaseURL(r, m.store.Load())
+	views := make([]SavedSearchView, 0, len(list))
+	for _, saved := range list {
+		views = append(views, saved.view(baseURL))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(views)
+}
+
+func (m *SavedSearchManager) handleGet(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		ErrorResponse(w, ErrSavedSearchesDisabled)
+		return
+	}
+	saved, err := m.db.Get(principalName(principalFromContext(r.Context())), chi.URLParam(r, "id"))
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrSavedSearchStorage, err))
+		return
+	}
+	if saved == nil {
+		ErrorResponse(w, ErrSavedSearchNotFound)
+		return
+	}
+	view := saved.view(publicBaseURL(r, m.store.Load()))
+	view.Results = saved.Results
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+func (m *SavedSearchManager) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		ErrorResponse(w, ErrSavedSearchesDisabled)
+		return
+	}
+	found, err := m.db.Delete(principalName(principalFromContext(r.Context())), chi.URLParam(r, "id"))
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrSavedSearchStorage, err))
+		return
+	}
+	if !found {
+		ErrorResponse(w, ErrSavedSearchNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID       string        `xml:"id"`
+	Title    string        `xml:"title"`
+	Updated  string        `xml:"updated"`
+	Link     atomLink      `xml:"link"`
+	Summary  string        `xml:"summary,omitempty"`
+	Category *atomCategory `xml:"category,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// handleFeed serves the changes of a saved search as an Atom feed. The
+// unguessable token in the URL stands in for the API key.
+func (m *SavedSearchManager) handleFeed(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		ErrorResponse(w, ErrSavedSearchesDisabled)
+		return
+	}
+	saved, err := m.db.ByFeedToken(chi.URLParam(r, "token"))
+	if err != nil {
+		ErrorResponse(w, WrapError(ErrSavedSearchStorage, err))
+		return
+	}
+	if saved == nil {
+		ErrorResponse(w, ErrSavedSearchNotFound)
+		return
+	}
+
+	view := saved.view(publicBaseURL(r, m.store.Load()))
+	title := saved.Name
+	if title == "" {
+		title = saved.Prompt
+	}
+	updated := saved.CreatedAt
+	if len(saved.Changes) > 0 {
+		updated = saved.Changes[0].DetectedAt
+	}
+	feed := atomFeed{
+		XMLNS:   atomXMLNS,
+		ID:      view.FeedURL,
+		Title:   title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: openSearchShortName},
+		Link:    atomLink{Href: view.FeedURL, Rel: "self"},
+	}
+	for _, c := range saved.Changes {
+		entryTitle := c.Result.Title
+		if entryTitle == "" {
+			entryTitle = c.Result.URL
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:       "urn:ai-search-aggregator:change:" + c.ID,
+			Title:    entryTitle,
+			Updated:  c.DetectedAt.UTC().Format(time.RFC3339),
+			Link:     atomLink{Href: c.Result.URL},
+			Summary:  c.Result.Snippet,
+			Category: &atomCategory{Term: c.Kind},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	_ = enc.Encode(feed)
+}
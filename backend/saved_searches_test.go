@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDiffResultsByCanonicalURL(t *testing.T) {
+	now := time.Now()
+	prev := []SearchResult{
+		{Title: "A", URL: "https://a.com/post", Snippet: "old"},
+		{Title: "B", URL: "https://b.com/", Snippet: "same"},
+	}
+	next := []SearchResult{
+		{Title: "B", URL: "https://www.b.com/?utm_source=feed", Snippet: "same"},
+		{Title: "C", URL: "https://c.com", Snippet: "fresh"},
+		{Title: "A", URL: "http://a.com/post/#comments", Snippet: "updated"},
+	}
+	changes := diffResults(prev, next, now)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if c := changes[0]; c.Kind != changeNew || c.Result.Title != "C" || c.Rank != 2 {
+		t.Fatalf("unexpected new result %+v", c)
+	}
+	if c := changes[1]; c.Kind != changeUpdated || c.Result.Title != "A" || c.Rank != 3 || c.PreviousRank != 1 {
+		t.Fatalf("unexpected changed result %+v", c)
+	}
+}
+
+// savedSearchFixture runs saved searches against a fake SearxNG whose results
+// the test swaps between runs and a fake OpenRouter that keeps every result.
+type savedSearchFixture struct {
+	manager *SavedSearchManager
+	server  *httptest.Server
+
+	mu      sync.Mutex
+	results []searxResultItem
+	now     time.Time
+	// hold, when set, keeps SearxNG from answering until it is closed
+	hold chan struct{}
+}
+
+func newSavedSearchFixture(t *testing.T) *savedSearchFixture {
+	t.Helper()
+	f := &savedSearchFixture{now: time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)}
+	searx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		hold := f.hold
+		f.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(fakeSearxResp{Results: f.results})
+	}))
+	t.Cleanup(searx.Close)
+	openRouter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "web-search queries") {
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"go release notes"}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"1"}}]}`))
+	}))
+	t.Cleanup(openRouter.Close)
+
+	cfg := defaultConfig()
+	cfg.Searx.URL = searx.URL
+	cfg.OpenRouter.APIKey = "test"
+	cfg.OpenRouter.Endpoint = openRouter.URL
+	cfg.Debug.LogRequests = false
+	cfg.Auth = testAuthConfig()
+	cfg.Jobs.CallbackSecret = testCallbackSecret
+	cfg.Outbound.AllowPrivateNetworks = true
+	logger := NewLogger()
+	db, err := openBoltSavedSearchStore(filepath.Join(t.TempDir(), "saved.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	auth := NewAuthenticator(cfg.Auth)
+	f.manager = NewSavedSearchManager(NewConfigStore("", cfg, logger), auth, NewConnManager(), NewSearchSlots(), nil, db, logger)
+	f.manager.now = func() time.Time {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.now
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/feeds/{token}", f.manager.handleFeed)
+	r.With(AuthMiddleware(auth, ""), RequireScope(scopeSearch)).Route("/api/saved-searches", f.manager.Routes)
+	f.server = httptest.NewServer(r)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *savedSearchFixture) setResults(results ...searxResultItem) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = results
+}
+
+func (f *savedSearchFixture) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// runDue starts the due searches and waits until they have finished.
+func (f *savedSearchFixture) runDue(t *testing.T) {
+	t.Helper()
+	f.manager.startDue(context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.manager.mu.Lock()
+		running := len(f.manager.running)
+		f.manager.mu.Unlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("saved search runs did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSavedSearchAlertsOnChanges(t *testing.T) {
+	f := newSavedSearchFixture(t)
+
+	alerts := make(chan *http.Request, 1)
+	alertBodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		alerts <- r
+		alertBodies <- body
+	}))
+	defer receiver.Close()
+
+	create := `{"name":"Go releases","prompt":"new Go releases","interval":"6h","webhook_url":"` + receiver.URL + `"}`
+	req, _ := http.NewRequest(http.MethodPost, f.server.URL+"/api/saved-searches", strings.NewReader(create))
+	req.Header.Set(apiKeyHeader, "web-key-0123456789")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var view SavedSearchView
+	_ = json.NewDecoder(resp.Body).Decode(&view)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || view.Interval != "6h0m0s" || !strings.Contains(view.FeedURL, "/api/feeds/") {
+		t.Fatalf("unexpected create response %d %+v", resp.StatusCode, view)
+	}
+
+	// Первый запуск задает базу и не шлет уведомлений
+	f.setResults(searxResultItem{Title: "Go 1.24", URL: "https://go.dev/doc/go1.24", Content: "Release notes", Score: 1})
+	f.runDue(t)
+	saved, _ := f.manager.db.Get("web", view.ID)
+	if saved.LastRun == nil || saved.LastRun.Outcome != savedRunCompleted || !saved.HasBaseline || len(saved.Changes) != 0 {
+		t.Fatalf("unexpected first run %+v", saved.LastRun)
+	}
+	if !saved.NextRunAt.Equal(f.manager.now().Add(6 * time.Hour)) {
+		t.Fatalf("expected the next run in 6h, got %s", saved.NextRunAt)
+	}
+	select {
+	case <-alerts:
+		t.Fatal("expected no alert for the baseline run")
+	default:
+	}
+
+	// Не наступило время следующего запуска
+	f.runDue(t)
+	if again, _ := f.manager.db.Get("web", view.ID); !again.LastRun.StartedAt.Equal(saved.LastRun.StartedAt) {
+		t.Fatal("expected the search not to run before its interval")
+	}
+
+	f.setResults(
+		searxResultItem{Title: "Go 1.25", URL: "https://go.dev/doc/go1.25", Content: "Release notes", Score: 2},
+		searxResultItem{Title: "Go 1.24", URL: "https://go.dev/doc/go1.24?utm_source=x", Content: "Updated release notes", Score: 1},
+	)
+	f.advance(6 * time.Hour)
+	f.runDue(t)
+
+	var alert SavedSearchAlert
+	select {
+	case r := <-alerts:
+		body := <-alertBodies
+		if r.Header.Get(savedSearchIDHeader) != view.ID ||
+			r.Header.Get(jobSignatureHeader) != signCallback(testCallbackSecret, r.Header.Get(jobTimestampHeader), body) {
+			t.Fatalf("unexpected alert headers %v", r.Header)
+		}
+		if err := json.Unmarshal(body, &alert); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("alert was not delivered")
+	}
+	if len(alert.Changes) != 2 || alert.Changes[0].Kind != changeNew || alert.Changes[1].Kind != changeUpdated || alert.Run.New != 1 || alert.Run.Changed != 1 {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+	if alert.SavedSearch.FeedURL != "" {
+		t.Fatalf("expected no feed URL without server.public_url, got %q", alert.SavedSearch.FeedURL)
+	}
+	saved, _ = f.manager.db.Get("web", view.ID)
+	if saved.LastRun.WebhookStatus != callbackDelivered || len(saved.Changes) != 2 {
+		t.Fatalf("unexpected second run %+v", saved.LastRun)
+	}
+
+	feedResp, err := http.Get(view.FeedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feedResp.Body.Close()
+	var feed atomFeed
+	if err := xml.NewDecoder(feedResp.Body).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Go releases" || len(feed.Entries) != 2 || feed.Entries[0].Link.Href != "https://go.dev/doc/go1.25" || feed.Entries[0].Category.Term != changeNew {
+		t.Fatalf("unexpected feed %+v", feed)
+	}
+}
+
+func TestSavedSearchAPIValidatesAndScopes(t *testing.T) {
+	f := newSavedSearchFixture(t)
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, f.server.URL+path, strings.NewReader(body))
+		req.Header.Set(apiKeyHeader, "web-key-0123456789")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	var appErr struct {
+		Error AppError `json:"error"`
+	}
+	resp := do(http.MethodPost, "/api/saved-searches", `{"prompt":"go releases","interval":"1m"}`)
+	_ = json.NewDecoder(resp.Body).Decode(&appErr)
+	if resp.StatusCode != http.StatusBadRequest || appErr.Error.Code != ErrInvalidSchedule.Code {
+		t.Fatalf("expected INVALID_SCHEDULE, got %d %+v", resp.StatusCode, appErr)
+	}
+
+	resp = do(http.MethodPost, "/api/saved-searches", `{"prompt":"go releases","interval":"1h"}`)
+	var view SavedSearchView
+	_ = json.NewDecoder(resp.Body).Decode(&view)
+	if resp.StatusCode != http.StatusCreated || view.Settings.Queries != defaultConfig().Search.DefaultQueryCount {
+		t.Fatalf("unexpected create %d %+v", resp.StatusCode, view)
+	}
+	if other, _ := f.manager.db.Get("ops", view.ID); other != nil {
+		t.Fatal("expected saved searches to be scoped to their key")
+	}
+	if resp := do(http.MethodDelete, "/api/saved-searches/"+view.ID, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodGet, "/api/feeds/"+strings.TrimPrefix(view.FeedURL, f.server.URL+"/api/feeds/"), ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the feed to go away with the search, got %d", resp.StatusCode)
+	}
+}
+
+func TestSavedSearchRunWaitsForRunningSearches(t *testing.T) {
+	f := newSavedSearchFixture(t)
+	req, _ := http.NewRequest(http.MethodPost, f.server.URL+"/api/saved-searches", strings.NewReader(`{"prompt":"go releases","interval":"1h"}`))
+	req.Header.Set(apiKeyHeader, "web-key-0123456789")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	hold := make(chan struct{})
+	f.mu.Lock()
+	f.hold = hold
+	f.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		f.manager.Run(ctx)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		f.manager.mu.Lock()
+		running := len(f.manager.running)
+		f.manager.mu.Unlock()
+		if running > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("saved search did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Поиск не зависит от ctx, Run должен дождаться его, прежде чем вернуться
+	cancel()
+	select {
+	case <-stopped:
+		t.Fatal("expected Run to wait for the running search")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(hold)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the search finished")
+	}
+}
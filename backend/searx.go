@@ -8,19 +8,55 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type searxResultItem struct {
-	Title   string  `json:"title"`
-	URL     string  `json:"url"`
-	Content string  `json:"content"`
-	Score   float64 `json:"score"`
+	Title         string   `json:"title"`
+	URL           string   `json:"url"`
+	Content       string   `json:"content"`
+	Score         float64  `json:"score"`
+	Engine        string   `json:"engine,omitempty"`
+	Engines       []string `json:"engines,omitempty"`
+	Positions     []int    `json:"positions,omitempty"`
+	Category      string   `json:"category,omitempty"`
+	PublishedDate *string  `json:"publishedDate,omitempty"`
+}
+
+// searxDateLayouts lists the formats SearxNG engines use for publishedDate.
+var searxDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseSearxDate(s *string) *time.Time {
+	if s == nil || *s == "" {
+		return nil
+	}
+	for _, layout := range searxDateLayouts {
+		if t, err := time.Parse(layout, *s); err == nil {
+			return &t
+		}
+	}
+	return nil
 }
 
 type searxResponse struct {
 	Results []searxResultItem `json:"results"`
+	SearchExtras
+}
+
+// searxPage is the decoded outcome of one or more SearxNG requests.
+type searxPage struct {
+	Results []SearchResult
+	Extras  SearchExtras
 }
 
 // logToFile записывает данные в файл логов
@@ -42,13 +78,116 @@ func logToFile(cfg AppConfig, data string) error {
 	return err
 }
 
-func searchSearx(ctx context.Context, cfg AppConfig, query string, engines []string) ([]SearchResult, error) {
-	base := strings.TrimRight(cfg.Searx.URL, "/")
-	endpoint := fmt.Sprintf("%s/search?q=%s&format=json&language=%s&locale=%s", base, url.QueryEscape(query), cfg.Searx.Language, cfg.Searx.Locale)
-	if len(engines) > 0 {
+// searxParams holds the per-request SearxNG parameters derived from Settings.
+type searxParams struct {
+	Engines    []string
+	Categories []string
+	TimeRange  string
+	SafeSearch *int
+	PageNo     int
+	// Offset is the number of results already returned by earlier pages,
+	// used by the position-based score fallback.
+	Offset int
+}
+
+func newSearxParams(s Settings) searxParams {
+	return searxParams{
+		Engines:    s.Engines,
+		Categories: s.Categories,
+		TimeRange:  s.TimeRange,
+		SafeSearch: s.SafeSearch,
+		PageNo:     1,
+	}
+}
+
+// values encodes the parameters as a SearxNG /search query string.
+func (p searxParams) values(cfg AppConfig, query string) url.Values {
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("format", "json")
+	v.Set("language", cfg.Searx.Language)
+	v.Set("locale", cfg.Searx.Locale)
+	if len(p.Engines) > 0 {
 		// SearxNG accepts engines as comma-separated list
-		endpoint += "&engines=" + url.QueryEscape(strings.Join(engines, ","))
+		v.Set("engines", strings.Join(p.Engines, ","))
+	}
+	if len(p.Categories) > 0 {
+		v.Set("categories", strings.Join(p.Categories, ","))
+	}
+	if p.TimeRange != "" {
+		v.Set("time_range", p.TimeRange)
+	}
+	if p.SafeSearch != nil {
+		v.Set("safesearch", strconv.Itoa(*p.SafeSearch))
+	}
+	if p.PageNo > 1 {
+		v.Set("pageno", strconv.Itoa(p.PageNo))
+	}
+	return v
+}
+
+// searchSearxPages fetches the first pages result pages for query and
+// concatenates them, stopping early once a page comes back empty. A failure
+// after the first page is logged and the pages already fetched are returned.
+func searchSearxPages(ctx context.Context, cfg AppConfig, query string, params searxParams, pages int, logger *Logger) (searxPage, error) {
+	if pages < 1 {
+		pages = 1
+	}
+	var out searxPage
+	for page := 1; page <= pages; page++ {
+		params.PageNo = page
+		params.Offset = len(out.Results)
+		res, err := searchSearx(ctx, cfg, query, params)
+		if err != nil {
+			if page == 1 {
+				searxErrorsTotal.WithLabelValues("first").Inc()
+				return searxPage{}, err
+			}
+			// Первые страницы уже получены, не теряем их из-за последующих
+			searxErrorsTotal.WithLabelValues("later").Inc()
+			logger.Warn("searx page failed, keeping earlier pages", "error", err, "query", query, "page", page, "results", len(out.Results))
+			return out, nil
+		}
+		out.Extras.Merge(res.Extras)
+		if len(res.Results) == 0 {
+			break
+		}
+		out.Results = append(out.Results, res.Results...)
 	}
+	return out, nil
+}
+
+// searchSearx runs query against the configured Searx instances, picking one
+// from the pool and retrying on another instance if it fails.
+func searchSearx(ctx context.Context, cfg AppConfig, query string, params searxParams) (searxPage, error) {
+	pool := searxPoolFor(cfg)
+	var page searxPage
+	err := pool.Do(ctx, func(attemptCtx context.Context, base string) error {
+		var err error
+		page, err = searchSearxInstance(attemptCtx, cfg, base, query, params)
+		return err
+	})
+	return page, err
+}
+
+// searchSearxInstance performs a single /search request against one instance.
+func searchSearxInstance(ctx context.Context, cfg AppConfig, baseURL, query string, params searxParams) (_ searxPage, err error) {
+	base := strings.TrimRight(baseURL, "/")
+	ctx, span := tracer.Start(ctx, "searx.search", trace.WithAttributes(
+		attribute.String("searx.query", query),
+		attribute.String("searx.instance", base),
+		attribute.Int("searx.pageno", max(params.PageNo, 1)),
+	))
+
+	start := time.Now()
+	status := 0
+	defer func() {
+		searxRequestDuration.WithLabelValues(base, statusLabel(status)).Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		endSpan(span, err)
+	}()
+
+	endpoint := base + "/search?" + params.values(cfg, query).Encode()
 
 	// Логируем запрос в JSON формате
 	requestData := map[string]interface{}{
@@ -56,7 +195,7 @@ func searchSearx(ctx context.Context, cfg AppConfig, query string, engines []str
 		"method":    "GET",
 		"url":       endpoint,
 		"query":     query,
-		"engines":   engines,
+		"engines":   params.Engines,
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
 	if requestJSON, err := json.MarshalIndent(requestData, "", "  "); err == nil {
@@ -65,20 +204,21 @@ func searchSearx(ctx context.Context, cfg AppConfig, query string, engines []str
 
 	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
-		return nil, err
+		return searxPage{}, err
 	}
 
-	client := &http.Client{Timeout: cfg.Timeouts.SearxRequest}
+	client := newHTTPClient(cfg.Timeouts.SearxRequest)
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return searxPage{}, err
 	}
+	status = resp.StatusCode
 	defer resp.Body.Close()
 
 	// Читаем тело ответа для логирования
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return searxPage{}, err
 	}
 
 	// Логируем ответ в JSON формате
@@ -96,9 +236,13 @@ func searchSearx(ctx context.Context, cfg AppConfig, query string, engines []str
 		logToFile(cfg, string(respJSON))
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return searxPage{}, fmt.Errorf("searx status %d: %s", resp.StatusCode, truncateStr(string(body), 200))
+	}
+
 	var sr searxResponse
 	if err := json.Unmarshal(body, &sr); err != nil {
-		return nil, err
+		return searxPage{}, err
 	}
 
 	var out []SearchResult
@@ -106,14 +250,79 @@ func searchSearx(ctx context.Context, cfg AppConfig, query string, engines []str
 		score := r.Score
 		if score == 0 {
 			// simple heuristic based on position
-			score = 1.0 / float64(i+1)
+			score = 1.0 / float64(params.Offset+i+1)
+		}
+		engines := r.Engines
+		if len(engines) == 0 && r.Engine != "" {
+			engines = []string{r.Engine}
 		}
 		out = append(out, SearchResult{
-			Title:   r.Title,
-			URL:     r.URL,
-			Snippet: r.Content,
-			Score:   score,
+			Title:         r.Title,
+			URL:           r.URL,
+			Snippet:       r.Content,
+			Score:         score,
+			Engines:       engines,
+			Positions:     r.Positions,
+			Category:      r.Category,
+			PublishedDate: parseSearxDate(r.PublishedDate),
+			Queries:       []string{query},
 		})
 	}
-	return out, nil
+	return searxPage{Results: out, Extras: sr.SearchExtras}, nil
+}
+
+// fetchSearxSuggestions asks the SearxNG autocompleter for completions of q
+// using the given autocomplete backend.
+func fetchSearxSuggestions(ctx context.Context, cfg AppConfig, q, backend string) ([]string, error) {
+	var suggestions []string
+	err := searxPoolFor(cfg).Do(ctx, func(attemptCtx context.Context, base string) error {
+		ctx, span := tracer.Start(attemptCtx, "searx.autocomplete", trace.WithAttributes(attribute.String("searx.instance", base)))
+		defer span.End()
+
+		values := url.Values{"q": {q}, "autocomplete": {backend}}
+		if cfg.Searx.Language != "" {
+			values.Set("language", cfg.Searx.Language)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", base+"/autocompleter?"+values.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := newHTTPClient(cfg.Timeouts.SearxRequest).Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("searx autocompleter status %d: %s", resp.StatusCode, truncateStr(string(body), 200))
+		}
+		suggestions, err = parseSearxSuggestions(body)
+		return err
+	})
+	return suggestions, err
+}
+
+// parseSearxSuggestions accepts the OpenSearch form ["q", ["s1", ...]] that
+// SearxNG returns to browsers and the plain list it returns to XHR clients.
+func parseSearxSuggestions(body []byte) ([]string, error) {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(body, &parts); err != nil {
+		return nil, err
+	}
+	var list []string
+	if len(parts) == 2 && json.Unmarshal(parts[1], &list) == nil {
+		return list, nil
+	}
+	list = list[:0]
+	for _, p := range parts {
+		var s string
+		if err := json.Unmarshal(p, &s); err != nil {
+			return nil, fmt.Errorf("unexpected autocompleter response: %s", truncateStr(string(body), 200))
+		}
+		list = append(list, s)
+	}
+	return list, nil
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// SearchExtras collects the non-result parts of SearxNG responses: instant
+// answers, infoboxes, query suggestions, spelling corrections and the engines
+// that failed to respond.
+type SearchExtras struct {
+	Answers             []SearxAnswer        `json:"answers,omitempty"`
+	Infoboxes           []SearxInfobox       `json:"infoboxes,omitempty"`
+	Suggestions         []string             `json:"suggestions,omitempty"`
+	Corrections         []string             `json:"corrections,omitempty"`
+	UnresponsiveEngines []UnresponsiveEngine `json:"unresponsive_engines,omitempty"`
+}
+
+// SearxAnswer is an instant answer. Older SearxNG versions return answers as
+// plain strings, newer ones as objects; both decode into this type.
+type SearxAnswer struct {
+	Answer string `json:"answer"`
+	URL    string `json:"url,omitempty"`
+	Engine string `json:"engine,omitempty"`
+}
+
+func (a *SearxAnswer) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		a.Answer = text
+		return nil
+	}
+	type plain SearxAnswer
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+type SearxInfobox struct {
+	Infobox    string           `json:"infobox"`
+	ID         string           `json:"id,omitempty"`
+	Content    string           `json:"content,omitempty"`
+	ImgSrc     string           `json:"img_src,omitempty"`
+	URLs       []SearxLink      `json:"urls,omitempty"`
+	Attributes []SearxAttribute `json:"attributes,omitempty"`
+	Engine     string           `json:"engine,omitempty"`
+}
+
+type SearxLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// SearxAttribute is an infobox attribute. SearxNG engines may return the
+// value as a number, boolean or object; it is always kept as text.
+type SearxAttribute struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+func (a *SearxAttribute) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Label string          `json:"label"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	a.Label = raw.Label
+	a.Value = ""
+	if len(raw.Value) == 0 || string(raw.Value) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Value, &a.Value); err == nil {
+		return nil
+	}
+	// Числа и булевы значения берем как есть, объекты и массивы в компактном JSON
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw.Value); err != nil {
+		return err
+	}
+	a.Value = compact.String()
+	return nil
+}
+
+// UnresponsiveEngine is decoded from SearxNG's [engine, reason] pairs.
+type UnresponsiveEngine struct {
+	Engine string `json:"engine"`
+	Reason string `json:"reason"`
+}
+
+func (u *UnresponsiveEngine) UnmarshalJSON(data []byte) error {
+	var pair []string
+	if err := json.Unmarshal(data, &pair); err == nil {
+		if len(pair) > 0 {
+			u.Engine = pair[0]
+		}
+		if len(pair) > 1 {
+			u.Reason = pair[1]
+		}
+		return nil
+	}
+	type plain UnresponsiveEngine
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+// Merge appends the extras from other, skipping entries already present.
+func (e *SearchExtras) Merge(other SearchExtras) {
+	for _, a := range other.Answers {
+		if !containsAnswer(e.Answers, a) {
+			e.Answers = append(e.Answers, a)
+		}
+	}
+	for _, ib := range other.Infoboxes {
+		if !containsInfobox(e.Infoboxes, ib) {
+			e.Infoboxes = append(e.Infoboxes, ib)
+		}
+	}
+	e.Suggestions = appendUnique(e.Suggestions, other.Suggestions)
+	e.Corrections = appendUnique(e.Corrections, other.Corrections)
+	for _, u := range other.UnresponsiveEngines {
+		if !containsEngine(e.UnresponsiveEngines, u.Engine) {
+			e.UnresponsiveEngines = append(e.UnresponsiveEngines, u)
+		}
+	}
+}
+
+func containsAnswer(list []SearxAnswer, a SearxAnswer) bool {
+	for _, existing := range list {
+		if strings.EqualFold(strings.TrimSpace(existing.Answer), strings.TrimSpace(a.Answer)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInfobox(list []SearxInfobox, ib SearxInfobox) bool {
+	for _, existing := range list {
+		if ib.ID != "" && existing.ID == ib.ID {
+			return true
+		}
+		if ib.ID == "" && strings.EqualFold(existing.Infobox, ib.Infobox) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEngine(list []UnresponsiveEngine, engine string) bool {
+	for _, existing := range list {
+		if existing.Engine == engine {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique appends the items of add that are not yet in dst, comparing
+// case-insensitively and preserving first-seen order.
+func appendUnique(dst, add []string) []string {
+	seen := make(map[string]bool, len(dst))
+	for _, s := range dst {
+		seen[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+	for _, s := range add {
+		key := strings.ToLower(strings.TrimSpace(s))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		dst = append(dst, s)
+	}
+	return dst
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Стратегии выбора инстанса Searx
+const (
+	searxStrategyRoundRobin   = "round_robin"
+	searxStrategyLeastLatency = "least_latency"
+)
+
+// SearxInstance is one configured SearxNG endpoint.
+type SearxInstance struct {
+	URL    string `yaml:"url" toml:"url"`
+	Weight int    `yaml:"weight" toml:"weight"`
+}
+
+// searxEndpoint tracks the runtime health of a single instance.
+type searxEndpoint struct {
+	url           string
+	weight        int
+	currentWeight int
+	failures      int
+	ejectedUntil  time.Time
+	latency       time.Duration
+}
+
+func (e *searxEndpoint) ejected(now time.Time) bool {
+	return now.Before(e.ejectedUntil)
+}
+
+// SearxPool balances requests across Searx instances. Instances are ejected
+// passively after MaxFailures consecutive errors and brought back either when
+// the ejection expires or when an active health probe succeeds.
+type SearxPool struct {
+	mu             sync.Mutex
+	endpoints      []*searxEndpoint
+	strategy       string
+	maxFailures    int
+	ejectFor       time.Duration
+	maxAttempts    int
+	attemptTimeout time.Duration
+	probeOnce      sync.Once
+	stopProbes     context.CancelFunc
+}
+
+var (
+	searxPoolsMu sync.Mutex
+	searxPools   = make(map[string]*SearxPool)
+)
+
+// searxPoolFor returns the shared pool for the instances in cfg, creating it
+// on first use so that health state survives across searches.
+func searxPoolFor(cfg AppConfig) *SearxPool {
+	key := searxPoolKey(cfg)
+	searxPoolsMu.Lock()
+	defer searxPoolsMu.Unlock()
+	if pool, ok := searxPools[key]; ok {
+		return pool
+	}
+	pool := NewSearxPool(cfg)
+	searxPools[key] = pool
+	return pool
+}
+
+// searxPoolKey identifies the pool for cfg's instances and pool settings.
+func searxPoolKey(cfg AppConfig) string {
+	instances := searxInstances(cfg)
+	parts := make([]string, 0, len(instances)+1)
+	// Пул пересоздается при изменении любых его параметров (например, после reload)
+	// HealthInterval входит в ключ: пробы пула запускаются один раз
+	parts = append(parts, fmt.Sprintf("%s/%d/%s/%d/%s/%s", cfg.Searx.Strategy, cfg.Searx.MaxFailures,
+		cfg.Searx.EjectDuration, cfg.Searx.MaxAttempts, cfg.Timeouts.SearxRequest, cfg.Searx.HealthInterval))
+	for _, inst := range instances {
+		parts = append(parts, fmt.Sprintf("%s=%d", inst.URL, inst.Weight))
+	}
+	return strings.Join(parts, "|")
+}
+
+// activateSearxPool starts health checks on the pool for cfg and retires every
+// other cached pool: its probes stop and it is dropped from the cache.
+// Searches still running with an older config recreate their pool on the next
+// request, without probes, and the following reload retires it again.
+func activateSearxPool(ctx context.Context, cfg AppConfig, logger *Logger) {
+	pool := searxPoolFor(cfg)
+	pool.StartHealthChecks(ctx, cfg.Searx.HealthInterval, logger)
+
+	var stale []*SearxPool
+	searxPoolsMu.Lock()
+	for key, p := range searxPools {
+		if p != pool {
+			stale = append(stale, p)
+			delete(searxPools, key)
+		}
+	}
+	searxPoolsMu.Unlock()
+	for _, p := range stale {
+		p.StopHealthChecks()
+	}
+}
+
+// searxInstances returns the configured instance list, falling back to the
+// single SEARX_URL when no list is set.
+func searxInstances(cfg AppConfig) []SearxInstance {
+	if len(cfg.Searx.Instances) > 0 {
+		return cfg.Searx.Instances
+	}
+	return []SearxInstance{{URL: cfg.Searx.URL, Weight: 1}}
+}
+
+func NewSearxPool(cfg AppConfig) *SearxPool {
+	instances := searxInstances(cfg)
+	endpoints := make([]*searxEndpoint, 0, len(instances))
+	for _, inst := range instances {
+		weight := inst.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		endpoints = append(endpoints, &searxEndpoint{url: strings.TrimRight(inst.URL, "/"), weight: weight})
+	}
+
+	maxFailures := cfg.Searx.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 3
+	}
+	maxAttempts := cfg.Searx.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	return &SearxPool{
+		endpoints:      endpoints,
+		strategy:       cfg.Searx.Strategy,
+		maxFailures:    maxFailures,
+		ejectFor:       cfg.Searx.EjectDuration,
+		maxAttempts:    maxAttempts,
+		attemptTimeout: cfg.Timeouts.SearxRequest,
+	}
+}
+
+// Do calls fn with the base URL of a selected instance, retrying on other
+// instances until it succeeds, the attempts are exhausted or ctx is done.
+func (p *SearxPool) Do(ctx context.Context, fn func(ctx context.Context, baseURL string) error) error {
+	tried := make(map[*searxEndpoint]bool)
+	var lastErr error
+	for attempt := 0; attempt < p.maxAttempts; attempt++ {
+		ep := p.pick(tried)
+		if ep == nil {
+			break
+		}
+		tried[ep] = true
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.attemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, p.attemptTimeout)
+		}
+		start := time.Now()
+		err := fn(attemptCtx, ep.url)
+		cancel()
+
+		if err == nil {
+			p.report(ep, time.Since(start), nil)
+			return nil
+		}
+		if ctx.Err() != nil {
+			// Отмена всего поиска не является ошибкой инстанса
+			return err
+		}
+		p.report(ep, time.Since(start), err)
+		lastErr = fmt.Errorf("searx instance %s: %w", ep.url, err)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no searx instances available")
+	}
+	return lastErr
+}
+
+// pick selects the next instance, skipping those already tried. Ejected
+// instances are only used when every remaining instance is ejected.
+func (p *SearxPool) pick(tried map[*searxEndpoint]bool) *searxEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var healthy, ejected []*searxEndpoint
+	for _, ep := range p.endpoints {
+		if tried[ep] {
+			continue
+		}
+		if ep.ejected(now) {
+			ejected = append(ejected, ep)
+		} else {
+			healthy = append(healthy, ep)
+		}
+	}
+
+	if len(healthy) == 0 {
+		// Все инстансы исключены: берем тот, чье исключение истекает раньше
+		var best *searxEndpoint
+		for _, ep := range ejected {
+			if best == nil || ep.ejectedUntil.Before(best.ejectedUntil) {
+				best = ep
+			}
+		}
+		return best
+	}
+
+	if p.strategy == searxStrategyLeastLatency {
+		return pickLeastLatency(healthy)
+	}
+	return pickWeightedRoundRobin(healthy)
+}
+
+// pickWeightedRoundRobin implements smooth weighted round-robin.
+func pickWeightedRoundRobin(candidates []*searxEndpoint) *searxEndpoint {
+	total := 0
+	var best *searxEndpoint
+	for _, ep := range candidates {
+		ep.currentWeight += ep.weight
+		total += ep.weight
+		if best == nil || ep.currentWeight > best.currentWeight {
+			best = ep
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+// pickLeastLatency prefers instances that were never measured, then the one
+// with the lowest latency scaled down by its weight.
+func pickLeastLatency(candidates []*searxEndpoint) *searxEndpoint {
+	var best *searxEndpoint
+	var bestCost float64
+	for _, ep := range candidates {
+		cost := float64(ep.latency) / float64(ep.weight)
+		if best == nil || cost < bestCost {
+			best, bestCost = ep, cost
+		}
+	}
+	return best
+}
+
+// report records the outcome of a request to ep.
+func (p *SearxPool) report(ep *searxEndpoint, latency time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		ep.failures = 0
+		ep.ejectedUntil = time.Time{}
+		if ep.latency == 0 {
+			ep.latency = latency
+		} else {
+			// EWMA с коэффициентом 0.3
+			ep.latency = time.Duration(0.7*float64(ep.latency) + 0.3*float64(latency))
+		}
+		return
+	}
+
+	ep.failures++
+	if ep.failures >= p.maxFailures {
+		ep.ejectedUntil = time.Now().Add(p.ejectFor)
+	}
+}
+
+// StartHealthChecks probes every instance's /healthz endpoint at the given
+// interval until ctx is cancelled or StopHealthChecks is called. It is safe
+// to call more than once.
+func (p *SearxPool) StartHealthChecks(ctx context.Context, interval time.Duration, logger *Logger) {
+	if interval <= 0 {
+		return
+	}
+	p.probeOnce.Do(func() {
+		ctx, cancel := context.WithCancel(ctx)
+		p.mu.Lock()
+		p.stopProbes = cancel
+		p.mu.Unlock()
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					p.probe(ctx, logger)
+				}
+			}
+		}()
+	})
+}
+
+// StopHealthChecks stops the probes started by StartHealthChecks.
+func (p *SearxPool) StopHealthChecks() {
+	p.mu.Lock()
+	stop := p.stopProbes
+	p.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+func (p *SearxPool) probe(ctx context.Context, logger *Logger) {
+	p.mu.Lock()
+	endpoints := make([]*searxEndpoint, len(p.endpoints))
+	copy(endpoints, p.endpoints)
+	p.mu.Unlock()
+
+	client := &http.Client{Timeout: p.attemptTimeout}
+	for _, ep := range endpoints {
+		req, err := http.NewRequestWithContext(ctx, "GET", ep.url+"/healthz", nil)
+		if err != nil {
+			continue
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("health check status %d", resp.StatusCode)
+			}
+		}
+
+		p.mu.Lock()
+		wasEjected := ep.ejected(time.Now())
+		if err == nil {
+			ep.failures = 0
+			ep.ejectedUntil = time.Time{}
+		} else {
+			ep.failures = p.maxFailures
+			ep.ejectedUntil = time.Now().Add(p.ejectFor)
+		}
+		p.mu.Unlock()
+
+		if err != nil && !wasEjected {
+			logger.Warn("searx instance unhealthy", "instance", ep.url, "error", err)
+		} else if err == nil && wasEjected {
+			logger.Info("searx instance recovered", "instance", ep.url)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSearxPoolFailover(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"results": [{"title": "A", "url": "https://a.com"}]}`))
+	}))
+	defer healthy.Close()
+
+	cfg := AppConfig{Searx: SearxConfig{
+		Instances:     []SearxInstance{{URL: failing.URL, Weight: 1}, {URL: healthy.URL, Weight: 1}},
+		MaxFailures:   1,
+		EjectDuration: time.Minute,
+		MaxAttempts:   2,
+	}}
+
+	for i := 0; i < 3; i++ {
+		page, err := searchSearx(context.Background(), cfg, "test", searxParams{})
+		if err != nil {
+			t.Fatalf("search %d: unexpected error: %v", i, err)
+		}
+		if len(page.Results) != 1 {
+			t.Fatalf("search %d: expected 1 result, got %d", i, len(page.Results))
+		}
+	}
+
+	pool := searxPoolFor(cfg)
+	if !pool.endpoints[0].ejected(time.Now()) {
+		t.Fatalf("expected failing instance to be ejected")
+	}
+}
+
+func TestPickWeightedRoundRobin(t *testing.T) {
+	a := &searxEndpoint{url: "a", weight: 3}
+	b := &searxEndpoint{url: "b", weight: 1}
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[pickWeightedRoundRobin([]*searxEndpoint{a, b}).url]++
+	}
+	if counts["a"] != 6 || counts["b"] != 2 {
+		t.Fatalf("unexpected distribution: %v", counts)
+	}
+}
+
+func TestPickLeastLatency(t *testing.T) {
+	slow := &searxEndpoint{url: "slow", weight: 1, latency: 300 * time.Millisecond}
+	fast := &searxEndpoint{url: "fast", weight: 1, latency: 100 * time.Millisecond}
+	if got := pickLeastLatency([]*searxEndpoint{slow, fast}); got != fast {
+		t.Fatalf("expected fast instance, got %s", got.url)
+	}
+	unmeasured := &searxEndpoint{url: "new", weight: 1}
+	if got := pickLeastLatency([]*searxEndpoint{slow, fast, unmeasured}); got != unmeasured {
+		t.Fatalf("expected unmeasured instance to be tried first, got %s", got.url)
+	}
+}
+
+func TestActivateSearxPoolRetiresPreviousPool(t *testing.T) {
+	var probes atomic.Int32
+	searx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+	}))
+	defer searx.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prev := AppConfig{Searx: SearxConfig{URL: searx.URL, MaxFailures: 1, HealthInterval: 5 * time.Millisecond}}
+	activateSearxPool(ctx, prev, NewLogger())
+	old := searxPoolFor(prev)
+	for probes.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// После reload с другими параметрами старый пул больше не проверяется
+	next := prev
+	next.Searx.MaxFailures = 2
+	next.Searx.HealthInterval = 0
+	activateSearxPool(ctx, next, NewLogger())
+	searxPoolsMu.Lock()
+	_, stale := searxPools[searxPoolKey(prev)]
+	cached := len(searxPools)
+	searxPoolsMu.Unlock()
+	if stale || cached != 1 || searxPoolFor(next) == old {
+		t.Fatalf("expected only the new pool to be cached, got %d pools", cached)
+	}
+	time.Sleep(20 * time.Millisecond)
+	seen := probes.Load()
+	time.Sleep(30 * time.Millisecond)
+	if probes.Load() != seen {
+		t.Fatal("expected the previous pool's health checks to stop")
+	}
+}
+
+func TestActivateSearxPoolFollowsHealthInterval(t *testing.T) {
+	var probes atomic.Int32
+	searx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+	}))
+	defer searx.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := AppConfig{Searx: SearxConfig{URL: searx.URL, MaxFailures: 1, HealthInterval: time.Hour}}
+	activateSearxPool(ctx, cfg, NewLogger())
+
+	// Меняется только интервал: пул должен начать проверки с новым
+	cfg.Searx.HealthInterval = 5 * time.Millisecond
+	activateSearxPool(ctx, cfg, NewLogger())
+	for deadline := time.Now().Add(5 * time.Second); probes.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected probes at the new health interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Нулевой интервал отключает проверки
+	cfg.Searx.HealthInterval = 0
+	activateSearxPool(ctx, cfg, NewLogger())
+	time.Sleep(20 * time.Millisecond)
+	seen := probes.Load()
+	time.Sleep(30 * time.Millisecond)
+	if probes.Load() != seen {
+		t.Fatal("expected health checks to stop when the interval is set to 0")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -27,14 +29,144 @@ func TestSearchSearx(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	results, err := searchSearx(context.Background(), ts.URL, "test", nil)
+	cfg := AppConfig{Searx: SearxConfig{URL: ts.URL, Language: "en", Locale: "en-US"}}
+	page, err := searchSearx(context.Background(), cfg, "test", searxParams{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	results := page.Results
 	if len(results) != 2 {
 		t.Fatalf("expected 2 results, got %d", len(results))
 	}
 	if results[0].URL != "https://a.com" {
 		t.Fatalf("unexpected first URL: %s", results[0].URL)
 	}
+	if len(results[0].Queries) != 1 || results[0].Queries[0] != "test" {
+		t.Fatalf("expected result to record its query, got %v", results[0].Queries)
+	}
+}
+
+func TestSearchSearxProvenance(t *testing.T) {
+	body := `{"results": [
+		{"title": "A", "url": "https://a.com", "engine": "bing", "engines": ["bing", "google"],
+		 "positions": [1, 3], "category": "news", "publishedDate": "2024-05-06T07:08:09"},
+		{"title": "B", "url": "https://b.com", "engine": "wikipedia", "publishedDate": null}
+	]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	cfg := AppConfig{Searx: SearxConfig{URL: ts.URL}}
+	page, err := searchSearx(context.Background(), cfg, "go news", searxParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, b := page.Results[0], page.Results[1]
+	if len(a.Engines) != 2 || len(a.Positions) != 2 || a.Category != "news" {
+		t.Fatalf("provenance not preserved: %+v", a)
+	}
+	if a.PublishedDate == nil || a.PublishedDate.Day() != 6 {
+		t.Fatalf("unexpected published date: %v", a.PublishedDate)
+	}
+	if len(b.Engines) != 1 || b.Engines[0] != "wikipedia" || b.PublishedDate != nil {
+		t.Fatalf("expected single engine fallback and no date: %+v", b)
+	}
+}
+
+func TestSearchSearxForwardsParams(t *testing.T) {
+	var pagesSeen []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("categories") != "news,social media" {
+			t.Errorf("unexpected categories: %q", q.Get("categories"))
+		}
+		if q.Get("time_range") != "week" {
+			t.Errorf("unexpected time_range: %q", q.Get("time_range"))
+		}
+		if q.Get("safesearch") != "2" {
+			t.Errorf("unexpected safesearch: %q", q.Get("safesearch"))
+		}
+		pagesSeen = append(pagesSeen, q.Get("pageno"))
+
+		page, _ := strconv.Atoi(q.Get("pageno"))
+		var resp fakeSearxResp
+		if page < 2 {
+			resp.Results = []searxResultItem{{Title: "A", URL: "https://a.com"}, {Title: "B", URL: "https://b.com"}}
+		} else if page == 2 {
+			resp.Results = []searxResultItem{{Title: "C", URL: "https://c.com"}}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+
+	safe := 2
+	cfg := AppConfig{Searx: SearxConfig{URL: ts.URL}}
+	params := newSearxParams(Settings{Categories: []string{"news", "social media"}, TimeRange: "week", SafeSearch: &safe})
+
+	page, err := searchSearxPages(context.Background(), cfg, "test", params, 5, NewLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results := page.Results
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results across pages, got %d", len(results))
+	}
+	if len(pagesSeen) != 3 || pagesSeen[0] != "" || pagesSeen[1] != "2" || pagesSeen[2] != "3" {
+		t.Fatalf("expected to stop after the first empty page, saw %v", pagesSeen)
+	}
+	if results[2].Score != 1.0/3 {
+		t.Fatalf("expected position score to continue across pages, got %v", results[2].Score)
+	}
+}
+
+func TestSearchSearxExtras(t *testing.T) {
+	body := `{
+		"results": [],
+		"answers": ["42", {"answer": "forty-two", "url": "https://example.com"}],
+		"infoboxes": [{"infobox": "Go", "id": "https://go.dev", "content": "Programming language",
+			"urls": [{"title": "Website", "url": "https://go.dev"}], "attributes": [{"label": "Designed by", "value": "Robert Griesemer"}, {"label": "Stable release", "value": 1.24},
+				{"label": "Logo", "value": {"url": "https://go.dev/logo.svg"}}, {"label": "Typing", "value": null}]}],
+		"suggestions": ["golang tutorial"],
+		"corrections": ["golang"],
+		"unresponsive_engines": [["google", "timeout"]]
+	}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	cfg := AppConfig{Searx: SearxConfig{URL: ts.URL}}
+	page, err := searchSearx(context.Background(), cfg, "test", searxParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	extras := page.Extras
+	if len(extras.Answers) != 2 || extras.Answers[0].Answer != "42" || extras.Answers[1].URL != "https://example.com" {
+		t.Fatalf("unexpected answers: %+v", extras.Answers)
+	}
+	if len(extras.Infoboxes) != 1 || extras.Infoboxes[0].Attributes[0].Value != "Robert Griesemer" {
+		t.Fatalf("unexpected infoboxes: %+v", extras.Infoboxes)
+	}
+	// Нестроковые значения атрибутов не ломают разбор ответа
+	want := []SearxAttribute{
+		{Label: "Designed by", Value: "Robert Griesemer"},
+		{Label: "Stable release", Value: "1.24"},
+		{Label: "Logo", Value: `{"url":"https://go.dev/logo.svg"}`},
+		{Label: "Typing"},
+	}
+	if !reflect.DeepEqual(extras.Infoboxes[0].Attributes, want) {
+		t.Fatalf("unexpected attributes: %+v", extras.Infoboxes[0].Attributes)
+	}
+	if len(extras.UnresponsiveEngines) != 1 || extras.UnresponsiveEngines[0] != (UnresponsiveEngine{Engine: "google", Reason: "timeout"}) {
+		t.Fatalf("unexpected unresponsive engines: %+v", extras.UnresponsiveEngines)
+	}
+
+	// Merging the same response again must not duplicate anything
+	merged := extras
+	merged.Merge(extras)
+	if len(merged.Answers) != 2 || len(merged.Infoboxes) != 1 || len(merged.Suggestions) != 1 ||
+		len(merged.Corrections) != 1 || len(merged.UnresponsiveEngines) != 1 {
+		t.Fatalf("merge produced duplicates: %+v", merged)
+	}
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sessionSweepInterval is how often expired sessions are dropped.
+const sessionSweepInterval = time.Minute
+
+var ErrResumeNotFound = &AppError{
+	Code:    "RESUME_NOT_FOUND",
+	Message: "Search to resume was not found or has expired",
+	Status:  http.StatusNotFound,
+}
+
+// messageSink delivers the messages of a search: either straight to a
+// connection or through a SearchSession that also records them for replay.
+type messageSink interface {
+	Send(msgType string, data interface{}) error
+}
+
+func (sws *SafeWebSocketConn) Send(msgType string, data interface{}) error {
+	return sws.WriteJSON(WSMessage{Type: msgType, Data: data})
+}
+
+// writeEncoded writes a message that is already encoded as JSON.
+func (sws *SafeWebSocketConn) writeEncoded(data []byte) error {
+	sws.mu.Lock()
+	defer sws.mu.Unlock()
+	_ = sws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return sws.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// WSSearchStarted is the first event of every search. The resume token lets
+// a reconnected client pick the search up again.
+type WSSearchStarted struct {
+	SearchID    string `json:"search_id"`
+	ResumeToken string `json:"resume_token"`
+	RetentionMs int64  `json:"retention_ms"`
+}
+
+// WSResumeRequest asks to replay the events of a search after LastSeq and
+// to keep streaming live ones to this connection.
+type WSResumeRequest struct {
+	ResumeToken string `json:"resume_token"`
+	LastSeq     int64  `json:"last_seq"`
+}
+
+// sessionEvent is a recorded message, kept encoded for replay.
+type sessionEvent struct {
+	seq  int64
+	data []byte
+}
+
+// SearchSession numbers and records every message of one search so that a
+// client which lost its connection can replay what it missed. Only the
+// newest events up to maxBuffered bytes are kept. A search left without a
+// connection for orphanAfter is cancelled.
+type SearchSession struct {
+	id          string
+	token       string
+	principal   string
+	cancel      context.CancelFunc
+	orphanAfter time.Duration
+	maxBuffered int64
+
+	mu        sync.Mutex
+	conn      *SafeWebSocketConn
+	events    []sessionEvent
+	buffered  int64
+	orphaned  *time.Timer
+	seq       int64
+	done      bool
+	expiresAt time.Time
+}
+
+// Send stamps the message with the search ID and the next sequence number,
+// records it and forwards it to the attached connection, if any.
+func (s *SearchSession) Send(msgType string, data interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	encoded, err := json.Marshal(WSMessage{Type: msgType, Data: data, Seq: s.seq, SearchID: s.id})
+	if err != nil {
+		return err
+	}
+	s.record(sessionEvent{seq: s.seq, data: encoded})
+	if s.conn == nil {
+		return nil
+	}
+	// Запись под блокировкой сохраняет порядок событий при переключении соединения
+	if err := s.conn.writeEncoded(encoded); err != nil {
+		s.detachLocked()
+		return err
+	}
+	return nil
+}
+
+// record appends ev and drops the oldest events beyond maxBuffered bytes.
+// The newest event is always kept, so search_complete stays replayable.
+func (s *SearchSession) record(ev sessionEvent) {
+	s.events = append(s.events, ev)
+	s.buffered += int64(len(ev.data))
+	drop := 0
+	for s.maxBuffered > 0 && s.buffered > s.maxBuffered && drop < len(s.events)-1 {
+		s.buffered -= int64(len(s.events[drop].data))
+		drop++
+	}
+	if drop > 0 {
+		// Копируем, чтобы не удерживать вытесненные события в памяти
+		s.events = append([]sessionEvent(nil), s.events[drop:]...)
+	}
+}
+
+// attach replays the events after lastSeq to conn and makes it the live
+// receiver. It returns the number of replayed events.
+func (s *SearchSession) attach(conn *SafeWebSocketConn, lastSeq int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replayed := 0
+	for _, ev := range s.events {
+		if ev.seq <= lastSeq {
+			continue
+		}
+		if err := conn.writeEncoded(ev.data); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	if !s.done {
+		s.conn = conn
+		if s.orphaned != nil {
+			s.orphaned.Stop()
+			s.orphaned = nil
+		}
+	}
+	return replayed, nil
+}
+
+// detachLocked drops the live connection and cancels the search unless a
+// client resumes it within orphanAfter.
+func (s *SearchSession) detachLocked() {
+	s.conn = nil
+	if s.done || s.orphaned != nil || s.cancel == nil {
+		return
+	}
+	s.orphaned = time.AfterFunc(s.orphanAfter, s.cancel)
+}
+
+// SearchSessions indexes running and recently finished searches by resume
+// token.
+type SearchSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*SearchSession
+	now      func() time.Time
+}
+
+func NewSearchSessions() *SearchSessions {
+	return &SearchSessions{sessions: make(map[string]*SearchSession), now: time.Now}
+}
+
+// Start registers a search streaming to conn. principal is the API key
+// name (empty without auth); only the same key may resume it. cancel stops
+// the search once it has had no connection for cfg.ResumeRetention.
+func (ss *SearchSessions) Start(conn *SafeWebSocketConn, principal string, cancel context.CancelFunc, cfg WebSocketConfig) *SearchSession {
+	s := &SearchSession{
+		id:          randomHex(8),
+		token:       randomHex(16),
+		principal:   principal,
+		cancel:      cancel,
+		orphanAfter: cfg.ResumeRetention,
+		maxBuffered: cfg.ResumeBufferSize,
+		conn:        conn,
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sweepLocked()
+	ss.sessions[s.token] = s
+	return s
+}
+
+// Finish marks the search as complete; its events stay available for
+// retention.
+func (ss *SearchSessions) Finish(s *SearchSession, retention time.Duration) {
+	s.mu.Lock()
+	s.done = true
+	s.conn = nil
+	if s.orphaned != nil {
+		s.orphaned.Stop()
+		s.orphaned = nil
+	}
+	s.expiresAt = ss.now().Add(retention)
+	s.mu.Unlock()
+}
+
+// Detach is called when conn closes. The searches streaming to it keep
+// running for their resume retention, waiting for a client to resume them.
+func (ss *SearchSessions) Detach(conn *SafeWebSocketConn) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, s := range ss.sessions {
+		s.mu.Lock()
+		if s.conn == conn {
+			s.detachLocked()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Sweep drops expired sessions every sessionSweepInterval until ctx is
+// cancelled.
+func (ss *SearchSessions) Sweep(ctx context.Context) {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ss.mu.Lock()
+			ss.sweepLocked()
+			ss.mu.Unlock()
+		}
+	}
+}
+
+// Resume attaches conn to the search identified by token and replays the
+// events after lastSeq.
+func (ss *SearchSessions) Resume(token, principal string, conn *SafeWebSocketConn, lastSeq int64) (*SearchSession, int, *AppError) {
+	ss.mu.Lock()
+	ss.sweepLocked()
+	s, ok := ss.sessions[token]
+	ss.mu.Unlock()
+	if !ok || s.principal != principal {
+		return nil, 0, ErrResumeNotFound
+	}
+	replayed, err := s.attach(conn, lastSeq)
+	if err != nil {
+		return nil, replayed, WrapError(ErrResumeNotFound, err)
+	}
+	return s, replayed, nil
+}
+
+// sweepLocked drops finished sessions whose retention has passed.
+func (ss *SearchSessions) sweepLocked() {
+	now := ss.now()
+	for token, s := range ss.sessions {
+		s.mu.Lock()
+		expired := s.done && now.After(s.expiresAt)
+		s.mu.Unlock()
+		if expired {
+			delete(ss.sessions, token)
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
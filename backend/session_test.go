@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readSessionMessage(t *testing.T, msgs <-chan string) WSMessage {
+	t.Helper()
+	select {
+	case raw, ok := <-msgs:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		var msg WSMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatalf("decode %q: %v", raw, err)
+		}
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return WSMessage{}
+}
+
+func TestSearchSessionResumeReplaysMissedEvents(t *testing.T) {
+	sessions := NewSearchSessions()
+	first, firstMsgs := newRecordingConn(t)
+	s := sessions.Start(first, "team", nil, defaultConfig().WebSocket)
+
+	_ = s.Send("status", "one")
+	if msg := readSessionMessage(t, firstMsgs); msg.Seq != 1 || msg.SearchID != s.id {
+		t.Fatalf("expected seq 1 of search %s, got %+v", s.id, msg)
+	}
+
+	// Первое соединение обрывается, события продолжают копиться
+	first.conn.Close()
+	_ = s.Send("status", "two")
+	_ = s.Send("status", "three")
+
+	second, secondMsgs := newRecordingConn(t)
+	if _, _, appErr := sessions.Resume(s.token, "other", second, 1); appErr != ErrResumeNotFound {
+		t.Fatalf("another key must not resume the search, got %v", appErr)
+	}
+	_, replayed, appErr := sessions.Resume(s.token, "team", second, 1)
+	if appErr != nil {
+		t.Fatalf("resume: %v", appErr)
+	}
+	if replayed != 2 {
+		t.Fatalf("expected 2 replayed events, got %d", replayed)
+	}
+
+	_ = s.Send("search_complete", "four")
+	for _, want := range []int64{2, 3, 4} {
+		if msg := readSessionMessage(t, secondMsgs); msg.Seq != want {
+			t.Fatalf("expected seq %d, got %+v", want, msg)
+		}
+	}
+}
+
+func TestSearchSessionExpiresAfterRetention(t *testing.T) {
+	sessions := NewSearchSessions()
+	now := time.Now()
+	sessions.now = func() time.Time { return now }
+
+	conn, _ := newRecordingConn(t)
+	s := sessions.Start(conn, "", nil, defaultConfig().WebSocket)
+	_ = s.Send("search_complete", nil)
+	sessions.Finish(s, time.Minute)
+
+	replayConn, msgs := newRecordingConn(t)
+	if _, replayed, appErr := sessions.Resume(s.token, "", replayConn, 0); appErr != nil || replayed != 1 {
+		t.Fatalf("finished search must be replayable within retention, got %d, %v", replayed, appErr)
+	}
+	readSessionMessage(t, msgs)
+
+	now = now.Add(2 * time.Minute)
+	if _, _, appErr := sessions.Resume(s.token, "", replayConn, 0); appErr != ErrResumeNotFound {
+		t.Fatalf("expected expired search to be gone, got %v", appErr)
+	}
+}
+
+func TestSearchSessionCancelledWhenNotResumed(t *testing.T) {
+	sessions := NewSearchSessions()
+	cfg := defaultConfig().WebSocket
+	cfg.ResumeRetention = 30 * time.Millisecond
+
+	abandoned := make(chan struct{})
+	conn, _ := newRecordingConn(t)
+	sessions.Start(conn, "", func() { close(abandoned) }, cfg)
+	sessions.Detach(conn)
+	select {
+	case <-abandoned:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the unresumed search to be cancelled")
+	}
+
+	// Возобновленный вовремя поиск продолжается
+	resumed := make(chan struct{})
+	conn, _ = newRecordingConn(t)
+	s := sessions.Start(conn, "", func() { close(resumed) }, cfg)
+	sessions.Detach(conn)
+	next, _ := newRecordingConn(t)
+	if _, _, appErr := sessions.Resume(s.token, "", next, 0); appErr != nil {
+		t.Fatalf("resume: %v", appErr)
+	}
+	select {
+	case <-resumed:
+		t.Fatal("a resumed search must not be cancelled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSearchSessionCapsReplayBuffer(t *testing.T) {
+	sessions := NewSearchSessions()
+	cfg := defaultConfig().WebSocket
+	cfg.ResumeBufferSize = 400
+
+	conn, _ := newRecordingConn(t)
+	s := sessions.Start(conn, "", nil, cfg)
+	sessions.Detach(conn)
+	for i := 0; i < 20; i++ {
+		_ = s.Send("status", "progress update")
+	}
+	_ = s.Send("search_complete", strings.Repeat("x", 1000))
+
+	replayConn, msgs := newRecordingConn(t)
+	_, replayed, appErr := sessions.Resume(s.token, "", replayConn, 0)
+	if appErr != nil {
+		t.Fatalf("resume: %v", appErr)
+	}
+	// Событие больше лимита вытесняет все старые, но само сохраняется
+	if replayed != 1 {
+		t.Fatalf("expected only the newest event to be kept, got %d", replayed)
+	}
+	if msg := readSessionMessage(t, msgs); msg.Seq != 21 || msg.Type != "search_complete" {
+		t.Fatalf("expected search_complete to be replayed, got %+v", msg)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// SearchSlots caps how many searches run the pipeline at once across
+// WebSocket clients, jobs and batches. Waiters are served in FIFO order, so a
+// large batch cannot starve interactive searches queued after it. The limit
+// is passed on every call to follow config reloads.
+type SearchSlots struct {
+	mu      sync.Mutex
+	running int
+	limit   int
+	waiters []chan struct{}
+}
+
+func NewSearchSlots() *SearchSlots {
+	return &SearchSlots{}
+}
+
+// TryAcquire takes a slot if one is free and nobody is queued.
+func (s *SearchSlots) TryAcquire(limit int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+	if len(s.waiters) == 0 && s.running < limit {
+		s.running++
+		searchSlotsInUse.Set(float64(s.running))
+		return true
+	}
+	return false
+}
+
+// Acquire waits for a slot until ctx is done. Release must be called once
+// the search finishes.
+func (s *SearchSlots) Acquire(ctx context.Context, limit int) error {
+	if s.TryAcquire(limit) {
+		return nil
+	}
+	s.mu.Lock()
+	ready := make(chan struct{})
+	s.waiters = append(s.waiters, ready)
+	searchesQueued.Set(float64(len(s.waiters)))
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, w := range s.waiters {
+			if w == ready {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				searchesQueued.Set(float64(len(s.waiters)))
+				return ctx.Err()
+			}
+		}
+		// Слот уже выдан одновременно с отменой, возвращаем его
+		s.running--
+		s.grantLocked()
+		return ctx.Err()
+	}
+}
+
+func (s *SearchSlots) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	s.grantLocked()
+}
+
+// grantLocked hands free slots to the oldest waiters. s.mu must be held.
+func (s *SearchSlots) grantLocked() {
+	for len(s.waiters) > 0 && s.running < s.limit {
+		close(s.waiters[0])
+		s.waiters = s.waiters[1:]
+		s.running++
+	}
+	searchSlotsInUse.Set(float64(s.running))
+	searchesQueued.Set(float64(len(s.waiters)))
+}
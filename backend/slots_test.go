@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSearchSlotsQueueInOrder(t *testing.T) {
+	slots := NewSearchSlots()
+	if !slots.TryAcquire(1) {
+		t.Fatal("expected a free slot")
+	}
+	if slots.TryAcquire(1) {
+		t.Fatal("expected the only slot to be taken")
+	}
+
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func() {
+			if err := slots.Acquire(context.Background(), 1); err == nil {
+				order <- i
+			}
+		}()
+		// Дожидаемся, пока ожидающий встанет в очередь, чтобы порядок был определен
+		waitForWaiters(t, slots, i)
+	}
+
+	slots.Release()
+	if first := <-order; first != 1 {
+		t.Fatalf("expected the oldest waiter to get the slot, got %d", first)
+	}
+	slots.Release()
+	if second := <-order; second != 2 {
+		t.Fatalf("expected the second waiter next, got %d", second)
+	}
+}
+
+func TestSearchSlotsAcquireCanceled(t *testing.T) {
+	slots := NewSearchSlots()
+	slots.TryAcquire(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := slots.Acquire(ctx, 1); err == nil {
+		t.Fatal("expected acquire to fail once the context is done")
+	}
+	slots.Release()
+	if !slots.TryAcquire(1) {
+		t.Fatal("a canceled waiter must not keep the slot")
+	}
+}
+
+func waitForWaiters(t *testing.T, slots *SearchSlots, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		slots.mu.Lock()
+		waiting := len(slots.waiters)
+		slots.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiters, got %d", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
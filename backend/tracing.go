@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "ai-search-aggregator"
+
+// tracer is resolved through the global provider, so it stays a no-op until
+// initTracing installs an exporter.
+var tracer = otel.Tracer(tracerName)
+
+// initTracing installs the global tracer provider and the W3C trace-context
+// propagator. With the "none" exporter no provider is installed, so the
+// global no-op tracer is used: spans are not recorded and no trace IDs are
+// generated, only an incoming traceparent is passed on. The returned function
+// flushes and stops the exporter.
+func initTracing(ctx context.Context, cfg AppConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	switch cfg.Tracing.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Tracing.Exporter)
+	}
+
+	opts := []otlptracehttp.Option{}
+	if cfg.Tracing.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Tracing.Endpoint))
+	}
+	if cfg.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.Tracing.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("build tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newHTTPClient returns a client whose transport creates a span for each
+// request and injects the W3C traceparent header.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+}
+
+// startSearchStage opens a span for a pipeline stage and returns a function
+// that ends it and records the stage duration metric.
+func startSearchStage(ctx context.Context, stage string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "search."+stage)
+	return ctx, func() {
+		span.End()
+		observeSearchStage(stage, start)
+	}
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// testSpanRecorder installs a recording provider once per test binary: the
+// global tracer delegates to the first provider set and never switches, so
+// restoring or replacing it would break repeated runs (-count).
+var testSpanRecorder = sync.OnceValue(func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+})
+
+func TestSearchSearxPropagatesTraceContext(t *testing.T) {
+	recorder := testSpanRecorder()
+
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte(`{"results": []}`))
+	}))
+	defer ts.Close()
+
+	ctx, root := tracer.Start(context.Background(), "test")
+	cfg := AppConfig{Searx: SearxConfig{URL: ts.URL}}
+	if _, err := searchSearx(ctx, cfg, "test", searxParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	root.End()
+
+	if traceparent == "" {
+		t.Fatalf("expected traceparent header on the Searx request")
+	}
+	traceID := root.SpanContext().TraceID().String()
+	if traceparent[3:35] != traceID {
+		t.Fatalf("traceparent %q does not belong to trace %s", traceparent, traceID)
+	}
+
+	var found bool
+	for _, span := range recorder.Ended() {
+		if span.Name() == "searx.search" && span.Parent().TraceID() == root.SpanContext().TraceID() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected a searx.search child span")
+	}
+}
@@ -72,6 +72,53 @@ func ValidateSearchRequest(req *SearchRequest, cfg AppConfig) ValidationErrors {
 		}
 	}
 
+	// Валидация categories
+	if len(req.Settings.Categories) > 0 {
+		validCategories := make(map[string]bool)
+		for _, category := range cfg.Validation.SupportedCategories {
+			validCategories[category] = true
+		}
+
+		for _, category := range req.Settings.Categories {
+			if !validCategories[category] {
+				errors = append(errors, ValidationError{
+					Field:   "settings.categories",
+					Message: fmt.Sprintf("invalid category: %s", category),
+				})
+			}
+		}
+
+		if len(req.Settings.Categories) > cfg.Validation.MaxCategoryCount {
+			errors = append(errors, ValidationError{
+				Field:   "settings.categories",
+				Message: fmt.Sprintf("cannot specify more than %d categories", cfg.Validation.MaxCategoryCount),
+			})
+		}
+	}
+
+	switch req.Settings.TimeRange {
+	case "", "day", "week", "month", "year":
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "settings.time_range",
+			Message: "time_range must be one of day, week, month, year",
+		})
+	}
+
+	if req.Settings.SafeSearch != nil && (*req.Settings.SafeSearch < 0 || *req.Settings.SafeSearch > 2) {
+		errors = append(errors, ValidationError{
+			Field:   "settings.safesearch",
+			Message: "safesearch must be 0, 1 or 2",
+		})
+	}
+
+	if req.Settings.Pages < 0 || req.Settings.Pages > cfg.Validation.MaxPages {
+		errors = append(errors, ValidationError{
+			Field:   "settings.pages",
+			Message: fmt.Sprintf("pages must be between 1 and %d", cfg.Validation.MaxPages),
+		})
+	}
+
 	return errors
 }
 
@@ -93,4 +140,20 @@ func SanitizeSearchRequest(req *SearchRequest) {
 		}
 		req.Settings.Engines = uniqueEngines
 	}
+
+	// Удаление дубликатов из categories
+	if len(req.Settings.Categories) > 0 {
+		categorySet := make(map[string]bool)
+		var uniqueCategories []string
+		for _, category := range req.Settings.Categories {
+			category = strings.TrimSpace(strings.ToLower(category))
+			if category != "" && !categorySet[category] {
+				categorySet[category] = true
+				uniqueCategories = append(uniqueCategories, category)
+			}
+		}
+		req.Settings.Categories = uniqueCategories
+	}
+
+	req.Settings.TimeRange = strings.TrimSpace(strings.ToLower(req.Settings.TimeRange))
 }
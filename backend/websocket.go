@@ -3,13 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -28,6 +35,7 @@ func NewSafeWebSocketConn(conn *websocket.Conn) *SafeWebSocketConn {
 func (sws *SafeWebSocketConn) WriteJSON(v interface{}) error {
 	sws.mu.Lock()
 	defer sws.mu.Unlock()
+	_ = sws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
 	return sws.conn.WriteJSON(v)
 }
 
@@ -41,13 +49,25 @@ type Settings struct {
 	Queries     int      `json:"queries"`
 	ContentMode bool     `json:"content_mode"`
 	Engines     []string `json:"engines"`
+	Categories  []string `json:"categories,omitempty"`
+	TimeRange   string   `json:"time_range,omitempty"`
+	SafeSearch  *int     `json:"safesearch,omitempty"`
+	Pages       int      `json:"pages,omitempty"`
 }
 
 type SearchResult struct {
-	Title   string  `json:"title"`
-	URL     string  `json:"url"`
-	Snippet string  `json:"snippet"`
-	Score   float64 `json:"score"`
+	Title         string        `json:"title"`
+	URL           string        `json:"url"`
+	Snippet       string        `json:"snippet"`
+	Score         float64       `json:"score"`
+	Highlight     string        `json:"highlight,omitempty"`
+	Page          *PageDocument `json:"page,omitempty"`
+	Engines       []string      `json:"engines,omitempty"`
+	Positions     []int         `json:"positions,omitempty"`
+	Category      string        `json:"category,omitempty"`
+	PublishedDate *time.Time    `json:"published_date,omitempty"`
+	// Queries lists the generated queries that returned this result
+	Queries []string `json:"queries,omitempty"`
 }
 
 type SearchResponse struct {
@@ -56,21 +76,15 @@ type SearchResponse struct {
 }
 
 func createUpgrader(cfg AppConfig) websocket.Upgrader {
+	policy := NewOriginPolicy(cfg.WebSocket)
 	return websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
-			if !cfg.WebSocket.EnableOriginCheck {
-				return true
-			}
 			origin := r.Header.Get("Origin")
 			if origin == "" {
-				return false
-			}
-			for _, allowed := range cfg.WebSocket.AllowedOrigins {
-				if strings.Contains(origin, allowed) {
-					return true
-				}
+				// Браузеры всегда передают Origin, его нет только у серверных клиентов
+				return true
 			}
-			return false
+			return policy.Allowed(origin)
 		},
 	}
 }
@@ -79,83 +93,263 @@ func createUpgrader(cfg AppConfig) websocket.Upgrader {
 type WSMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+	// Seq and SearchID are set on messages that belong to a search session
+	Seq      int64  `json:"seq,omitempty"`
+	SearchID string `json:"search_id,omitempty"`
 }
 
 type WSSearchRequest struct {
 	Prompt   string   `json:"prompt"`
 	Settings Settings `json:"settings"`
+	// Locale overrides the connection's Accept-Language for status messages
+	Locale string `json:"locale,omitempty"`
 }
 
 type WSSearchStatus struct {
-	Stage     string `json:"stage"`
-	Progress  int    `json:"progress"`
-	Total     int    `json:"total"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
+	Stage     string         `json:"stage"`
+	Code      string         `json:"code"`
+	Params    map[string]any `json:"params,omitempty"`
+	Progress  int            `json:"progress"`
+	Total     int            `json:"total"`
+	Message   string         `json:"message"`
+	Locale    string         `json:"locale"`
+	Timestamp int64          `json:"timestamp"`
 }
 
 type WSSearchResult struct {
 	Queries []string       `json:"queries"`
 	Results []SearchResult `json:"results"`
 	Elapsed int64          `json:"elapsed_ms"`
+	SearchExtras
+}
+
+// WSPartialResults streams the results a finished query added.
+type WSPartialResults struct {
+	Query   string          `json:"query"`
+	Results []PartialResult `json:"results"`
+	Total   int             `json:"total"`
+}
+
+// Вердикты и причины для result_update
+const (
+	verdictKept    = "kept"
+	verdictDropped = "dropped"
+
+	reasonRelevant    = "relevant"
+	reasonIrrelevant  = "irrelevant"
+	reasonFetchFailed = "fetch_failed"
+	reasonJudgeFailed = "judge_failed"
+)
+
+// WSResultUpdate reports the relevance verdict for one result as soon as it
+// is judged. Highlight and Page are only set in content mode.
+type WSResultUpdate struct {
+	URL       string        `json:"url"`
+	Verdict   string        `json:"verdict"`
+	Reason    string        `json:"reason"`
+	Highlight string        `json:"highlight,omitempty"`
+	Page      *PageDocument `json:"page,omitempty"`
+	Completed int           `json:"completed"`
+	Total     int           `json:"total"`
 }
 
 type WSError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
-	Details string `json:"details"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Details    string `json:"details"`
+	RetryAfter int64  `json:"retry_after_ms,omitempty"`
 }
 
-func handleWebSocketSearch(w http.ResponseWriter, r *http.Request, cfg AppConfig, logger *Logger) {
-	upgrader := createUpgrader(cfg)
-	conn, err := upgrader.Upgrade(w, r, nil)
+// WSHandler serves /api/ws/search and holds the state shared by all
+// connections.
+type WSHandler struct {
+	store    *ConfigStore
+	auth     *Authenticator
+	limiter  *RateLimiter
+	conns    *ConnManager
+	sessions *SearchSessions
+	slots    *SearchSlots
+	history  *SearchHistory
+	logger   *Logger
+}
+
+func (h *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	store, auth, limiter, conns, logger := h.store, h.auth, h.limiter, h.conns, h.logger
+
+	// Ключ проверяем до upgrade, чтобы ответить обычной HTTP ошибкой
+	key, subprotocol := apiKeyFromRequest(r, true)
+	principal, appErr := auth.Authenticate(key, scopeSearch)
+	if appErr != nil {
+		logger.Warn("websocket connection rejected", "code", appErr.Code, "remote_addr", r.RemoteAddr)
+		ErrorResponse(w, appErr)
+		return
+	}
+	var respHeader http.Header
+	if subprotocol != "" {
+		// Браузер требует, чтобы сервер подтвердил один из предложенных подпротоколов
+		respHeader = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+
+	wsCfg := store.Load().WebSocket
+	upgrader := createUpgrader(store.Load())
+	conn, err := upgrader.Upgrade(w, r, respHeader)
 	if err != nil {
 		logger.Error("failed to upgrade connection", "error", err)
 		return
 	}
+
+	// Все поиски соединения пишут через один мьютекс
+	safeConn := NewSafeWebSocketConn(conn)
+	// Лимит проверяем после upgrade: браузер не видит HTTP статус, а код закрытия видит
+	if !conns.Register(safeConn, wsCfg.MaxConnections) {
+		if conns.Draining() {
+			_ = safeConn.Close(websocket.CloseServiceRestart, "server shutting down")
+			return
+		}
+		logger.Warn("websocket connection rejected", "reason", "max_connections", "limit", wsCfg.MaxConnections)
+		_ = safeConn.Close(websocket.CloseTryAgainLater, "too many connections, retry later")
+		return
+	}
+	defer conns.Unregister(safeConn)
 	defer conn.Close()
+	defer h.sessions.Detach(safeConn)
+
+	configureHeartbeat(conn, wsCfg.MaxMessageSize, wsCfg.MessageTimeout)
+	done := make(chan struct{})
+	defer close(done)
+	go safeConn.keepAlive(done, wsCfg.PingInterval)
 
 	reqLogger := logger.WithRequestID(generateRequestID())
-	reqLogger.Info("websocket connection established")
+	if principal != nil {
+		reqLogger.Info("websocket connection established", "api_key", principal.Name)
+	} else {
+		reqLogger.Info("websocket connection established")
+	}
+
+	// Подхватываем traceparent из запроса на upgrade, если он есть
+	connCtx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	connCtx = contextWithPrincipal(connCtx, principal)
+	connCtx = contextWithLocale(connCtx, negotiateLocale(r.Header.Get("Accept-Language")))
+
+	rateKey := rateLimitKey(principal, r, store.Load().RateLimit)
+	var active atomic.Int32
 
 	// Обработка входящих сообщений
 	for {
 		var msg WSMessage
 		err := conn.ReadJSON(&msg)
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				reqLogger.Info("websocket peer timed out", "timeout", wsCfg.MessageTimeout)
+			case errors.Is(err, websocket.ErrReadLimit):
+				reqLogger.Info("websocket message too large", "limit", wsCfg.MaxMessageSize)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				reqLogger.Error("websocket read error", err)
 			}
 			break
 		}
+		_ = conn.SetReadDeadline(time.Now().Add(wsCfg.MessageTimeout))
+
+		if msg.Type == "resume" {
+			h.handleResume(safeConn, msg, principalName(principal), reqLogger)
+			continue
+		}
 
 		if msg.Type == "search" {
+			// Каждый поиск получает актуальный снимок конфигурации
+			cfg := store.Load()
+			if appErr := admitSearch(&active, limiter, rateKey, cfg.RateLimit); appErr != nil {
+				searchesTotal.WithLabelValues("rate_limited").Inc()
+				reqLogger.Info("search rejected", "code", appErr.Code, "client", rateKey, "details", appErr.Details)
+				sendSafeAppError(safeConn, appErr)
+				continue
+			}
+			if !conns.BeginSearch() {
+				searchesTotal.WithLabelValues("shutting_down").Inc()
+				active.Add(-1)
+				sendSafeAppError(safeConn, ErrShuttingDown)
+				continue
+			}
 			go func() {
-				ctx, cancel := context.WithTimeout(r.Context(), cfg.WebSocket.SearchTimeout)
+				defer active.Add(-1)
+				defer conns.EndSearch()
+				// Поиск переживает обрыв соединения, чтобы клиент мог его возобновить
+				ctx, cancel := conns.SearchContext(connCtx, cfg.WebSocket.SearchTimeout)
 				defer cancel()
-				handleSearchMessage(ctx, conn, msg, cfg, reqLogger.logger)
+				h.handleSearchMessage(ctx, safeConn, msg, cfg, reqLogger.logger)
 			}()
 		}
 	}
 }
 
-func handleSearchMessage(ctx context.Context, conn *websocket.Conn, msg WSMessage, cfg AppConfig, logger *Logger) {
-	startTime := time.Now()
-	safeConn := NewSafeWebSocketConn(conn)
+// admitSearch reserves a slot for a new search on a connection, enforcing
+// the per-connection concurrency cap and the client's token bucket. On
+// success the caller must release the slot by decrementing active.
+func admitSearch(active *atomic.Int32, limiter *RateLimiter, client string, cfg RateLimitConfig) *AppError {
+	if cfg.Enabled && cfg.MaxConcurrentPerConnection > 0 && int(active.Load()) >= cfg.MaxConcurrentPerConnection {
+		return rateLimitedError(fmt.Sprintf("at most %d concurrent searches per connection", cfg.MaxConcurrentPerConnection), time.Second)
+	}
+	if ok, retryAfter := limiter.Allow(client, cfg); !ok {
+		return rateLimitedError(fmt.Sprintf("search rate limit of %g per minute exceeded", cfg.SearchesPerMinute), retryAfter)
+	}
+	active.Add(1)
+	return nil
+}
+
+// handleResume replays a search's missed events to safeConn and attaches it
+// for the live ones.
+func (h *WSHandler) handleResume(safeConn *SafeWebSocketConn, msg WSMessage, principal string, logger *RequestLogger) {
+	var req WSResumeRequest
+	if err := decodeMessageData(msg, &req); err != nil {
+		sendSafeError(safeConn, "INVALID_REQUEST", "Failed to decode resume request", err.Error())
+		return
+	}
+	session, replayed, appErr := h.sessions.Resume(req.ResumeToken, principal, safeConn, req.LastSeq)
+	if appErr != nil {
+		logger.Info("search resume failed", "code", appErr.Code)
+		sendSafeAppError(safeConn, appErr)
+		return
+	}
+	logger.Info("search resumed", "search_id", session.id, "last_seq", req.LastSeq, "replayed", replayed)
+}
+
+// decodeMessageData converts the generic data of msg into dst.
+func decodeMessageData(msg WSMessage, dst interface{}) error {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func (h *WSHandler) handleSearchMessage(ctx context.Context, conn *SafeWebSocketConn, msg WSMessage, cfg AppConfig, logger *Logger) {
+	auth := h.auth
+
+	ctx, span := tracer.Start(ctx, "search")
+	outcome := "error"
+	defer func() { finishSearchSpan(ctx, span, outcome) }()
 
 	// Парсим поисковый запрос
 	reqData, err := json.Marshal(msg.Data)
 	if err != nil {
-		sendSafeError(safeConn, "INVALID_REQUEST", "Failed to parse request", err.Error())
+		outcome = "invalid_request"
+		sendSafeError(conn, "INVALID_REQUEST", "Failed to parse request", err.Error())
 		return
 	}
 
 	var req WSSearchRequest
 	if err := json.Unmarshal(reqData, &req); err != nil {
-		sendSafeError(safeConn, "INVALID_REQUEST", "Failed to decode request", err.Error())
+		outcome = "invalid_request"
+		sendSafeError(conn, "INVALID_REQUEST", "Failed to decode request", err.Error())
 		return
 	}
 
+	if locale := supportedLocale(req.Locale); locale != "" {
+		ctx = contextWithLocale(ctx, locale)
+	}
+
 	// Санитизация и валидация
 	searchReq := SearchRequest{
 		Prompt:   req.Prompt,
@@ -164,87 +358,182 @@ func handleSearchMessage(ctx context.Context, conn *websocket.Conn, msg WSMessag
 	SanitizeSearchRequest(&searchReq)
 
 	if validationErrors := ValidateSearchRequest(&searchReq, cfg); len(validationErrors) > 0 {
-		sendSafeError(safeConn, "VALIDATION_FAILED", "Request validation failed", validationErrors.Error())
+		outcome = "validation_failed"
+		sendSafeError(conn, "VALIDATION_FAILED", "Request validation failed", validationErrors.Error())
 		return
 	}
 
+	// Квоту списываем только за корректные запросы
+	principal := principalFromContext(ctx)
+	if appErr := auth.BeginSearch(principal); appErr != nil {
+		outcome = "quota_exceeded"
+		sendSafeAppError(conn, appErr)
+		return
+	}
+	usage := &tokenUsage{}
+	ctx = contextWithTokenUsage(ctx, usage)
+	defer func() { auth.AddTokens(principal, usage.total.Load()) }()
+
+	// Все дальнейшие сообщения нумеруются и сохраняются для возобновления.
+	// Поиск, который никто не возобновил за время хранения, отменяется
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out := h.sessions.Start(conn, principalName(principal), func() {
+		logger.Info("search abandoned, no client resumed it", "retention", cfg.WebSocket.ResumeRetention)
+		cancel()
+	}, cfg.WebSocket)
+	defer h.sessions.Finish(out, cfg.WebSocket.ResumeRetention)
+	sendSafeMessage(out, "search_started", WSSearchStarted{
+		SearchID:    out.id,
+		ResumeToken: out.token,
+		RetentionMs: cfg.WebSocket.ResumeRetention.Milliseconds(),
+	})
+
+	sink, record := h.history.track(ctx, out, searchSourceWebSocket, searchReq)
+	outcome = runSearch(ctx, sink, h.slots, searchReq, cfg, logger)
+	record(outcome)
+}
+
+// finishSearchSpan counts a finished search by outcome and ends its root
+// span.
+func finishSearchSpan(ctx context.Context, span trace.Span, outcome string) {
+	outcome = finalOutcome(ctx, outcome)
+	searchesTotal.WithLabelValues(outcome).Inc()
+	span.SetAttributes(attribute.String("search.outcome", outcome))
+	if outcome != "success" {
+		span.SetStatus(codes.Error, outcome)
+	}
+	span.End()
+}
+
+// finalOutcome reports a failed search whose context is done as canceled,
+// whatever stage it failed in.
+func finalOutcome(ctx context.Context, outcome string) string {
+	if outcome != "success" && ctx.Err() != nil {
+		return "canceled"
+	}
+	return outcome
+}
+
+// runSearch executes a validated and charged search, streaming progress,
+// partial results and the final result to out. It returns the outcome
+// recorded in metrics.
+func runSearch(ctx context.Context, out messageSink, slots *SearchSlots, searchReq SearchRequest, cfg AppConfig, logger *Logger) string {
+	startTime := time.Now()
+	span := trace.SpanFromContext(ctx)
+
+	// Ждем свободный слот, общий для всех видов поиска
+	if !slots.TryAcquire(cfg.Search.MaxConcurrentSearches) {
+		sendSafeStatus(ctx, out, "queued", msgQueued, 0, 1, nil)
+		if err := slots.Acquire(ctx, cfg.Search.MaxConcurrentSearches); err != nil {
+			sendSafeError(out, "SEARCH_CANCELED", "Search was canceled while waiting for a free slot", err.Error())
+			return "canceled"
+		}
+	}
+	defer slots.Release()
+
 	if searchReq.Settings.Queries == 0 {
 		searchReq.Settings.Queries = cfg.Search.DefaultQueryCount
 	}
+	if searchReq.Settings.Pages == 0 {
+		searchReq.Settings.Pages = 1
+	}
+
+	span.SetAttributes(
+		attribute.String("search.prompt", truncateStr(searchReq.Prompt, 200)),
+		attribute.Int("search.queries", searchReq.Settings.Queries),
+		attribute.Bool("search.content_mode", searchReq.Settings.ContentMode),
+		attribute.StringSlice("search.engines", searchReq.Settings.Engines),
+	)
 
-	logger.Info("websocket search started",
+	logger.Info("search started",
 		"prompt", truncateStr(searchReq.Prompt, 200),
 		"queries", searchReq.Settings.Queries,
 		"content_mode", searchReq.Settings.ContentMode,
+		"trace_id", span.SpanContext().TraceID().String(),
 	)
 
 	// Отправляем статус начала поиска
-	sendSafeStatus(safeConn, "generating_queries", 0, 1, "Генерация поисковых запросов...")
+	sendSafeStatus(ctx, out, "generating_queries", msgGeneratingQueries, 0, 1, nil)
 
 	// Шаг 1: Генерация запросов
-	queries, err := generateQueriesWithOpenRouter(ctx, searchReq.Prompt, searchReq.Settings.Queries, cfg)
+	stageCtx, endStage := startSearchStage(ctx, "query_generation")
+	queries, err := generateQueriesWithOpenRouter(stageCtx, searchReq.Prompt, searchReq.Settings.Queries, cfg)
+	endStage()
 	if err != nil {
-		sendSafeError(safeConn, "QUERY_GENERATION_FAILED", "Failed to generate queries", err.Error())
-		return
+		sendSafeError(out, "QUERY_GENERATION_FAILED", "Failed to generate queries", err.Error())
+		return "query_generation_failed"
 	}
 
 	logger.Info("queries generated", "count", len(queries))
-	sendSafeStatus(safeConn, "searching", 0, len(queries), "Выполнение поисковых запросов...")
+	sendSafeStatus(ctx, out, "searching", msgSearching, 0, len(queries), nil)
 
 	// Шаг 2: Выполнение поисков
+	stageCtx, endStage = startSearchStage(ctx, "searching")
 	var (
 		eg        errgroup.Group
-		mu        sync.Mutex // для защиты results и completed
-		results   []SearchResult
+		mu        sync.Mutex // для защиты ranker, extras и completed
+		ranker    = newProvisionalRanker()
+		extras    SearchExtras
 		completed int
 	)
 
 	eg.SetLimit(cfg.Search.MaxConcurrentQueries) // Ограничиваем количество одновременных запросов
+	params := newSearxParams(searchReq.Settings)
 
 	for _, query := range queries {
 		query := query
 		eg.Go(func() error {
-			queryCtx, queryCancel := context.WithTimeout(ctx, cfg.Timeouts.SearxRequest)
-			defer queryCancel()
-
-			res, err := searchSearx(queryCtx, cfg, query, searchReq.Settings.Engines)
+			// Таймаут на каждую попытку задается пулом Searx-инстансов
+			res, err := searchSearxPages(stageCtx, cfg, query, params, searchReq.Settings.Pages, logger)
 			if err != nil {
 				logger.Error("searx search failed", "error", err, "query", query)
 				return err
 			}
 
 			mu.Lock()
-			results = append(results, res...)
+			extras.Merge(res.Extras)
+			fresh, found := ranker.add(res.Results)
 			completed++
 			currentCompleted := completed // копируем для использования вне блокировки
 			mu.Unlock()
 
+			if len(fresh) > 0 {
+				sendSafeMessage(out, "partial_results", WSPartialResults{Query: query, Results: fresh, Total: found})
+			}
+
 			// Отправляем обновление прогресса (безопасно)
-			sendSafeStatus(safeConn, "searching", currentCompleted, len(queries),
-				"Выполнено запросов: %d/%d", currentCompleted, len(queries))
+			sendSafeStatus(ctx, out, "searching", msgQueriesCompleted, currentCompleted, len(queries),
+				map[string]any{"completed": currentCompleted, "total": len(queries)})
 
 			return nil
 		})
 	}
 
-	if err := eg.Wait(); err != nil {
+	err = eg.Wait()
+	endStage()
+	if err != nil {
 		logger.Error("searx search group failed", "error", err)
-		sendSafeError(safeConn, "SEARCH_FAILED", "Search failed", err.Error())
-		return
+		sendSafeError(out, "SEARCH_FAILED", "Search failed", err.Error())
+		return "search_failed"
 	}
 
 	// Дедупликация и ранжирование
-	sendSafeStatus(safeConn, "processing", 0, 1, "Обработка результатов...")
-	ranked := deduplicateAndRank(results)
-	logger.Info("deduplication completed", "input_count", len(results), "output_count", len(ranked))
+	sendSafeStatus(ctx, out, "processing", msgProcessing, 0, 1, nil)
+	ranked := deduplicateAndRank(ranker.all)
+	logger.Info("deduplication completed", "input_count", len(ranker.all), "output_count", len(ranked))
 
 	// Фильтрация по релевантности
 	if searchReq.Settings.ContentMode {
-		sendSafeStatus(safeConn, "analyzing_content", 0, len(ranked), "Анализ содержимого страниц...")
-		ranked = analyzeContentWithProgress(ctx, safeConn, searchReq.Prompt, ranked, cfg, logger)
+		stageCtx, endStage = startSearchStage(ctx, "content_analysis")
+		sendSafeStatus(ctx, out, "analyzing_content", msgAnalyzingContent, 0, len(ranked), nil)
+		ranked = analyzeContentWithProgress(stageCtx, out, searchReq.Prompt, queries, ranked, cfg, logger)
+		endStage()
 	} else {
-		sendSafeStatus(safeConn, "ai_filtering", 0, len(ranked), "ИИ-фильтрация результатов...")
-		ranked = filterByAIRelevanceWithProgress(ctx, safeConn, searchReq.Prompt, ranked, cfg, logger)
+		stageCtx, endStage = startSearchStage(ctx, "filtering")
+		sendSafeStatus(ctx, out, "ai_filtering", msgAIFiltering, 0, len(ranked), nil)
+		ranked = filterByAIRelevanceWithProgress(stageCtx, out, searchReq.Prompt, ranked, cfg, logger)
+		endStage()
 		logger.Info("ai filter completed", "output_items", len(ranked))
 	}
 
@@ -252,19 +541,23 @@ func handleSearchMessage(ctx context.Context, conn *websocket.Conn, msg WSMessag
 
 	// Отправляем финальные результаты
 	response := WSSearchResult{
-		Queries: queries,
-		Results: ranked,
-		Elapsed: elapsed,
+		Queries:      queries,
+		Results:      ranked,
+		Elapsed:      elapsed,
+		SearchExtras: extras,
 	}
 
-	sendSafeMessage(safeConn, "search_complete", response)
-	logger.Info("websocket search completed", "results", len(ranked), "elapsed_ms", elapsed)
+	sendSafeMessage(out, "search_complete", response)
+	logger.Info("search completed", "results", len(ranked), "elapsed_ms", elapsed,
+		"answers", len(extras.Answers), "unresponsive_engines", len(extras.UnresponsiveEngines))
+	return "success"
 }
 
-func analyzeContentWithProgress(ctx context.Context, safeConn *SafeWebSocketConn, prompt string, results []SearchResult, cfg AppConfig, logger *Logger) []SearchResult {
+func analyzeContentWithProgress(ctx context.Context, out messageSink, prompt string, queries []string, results []SearchResult, cfg AppConfig, logger *Logger) []SearchResult {
 	type contentEval struct {
 		idx         int
-		content     string
+		page        *PageDocument
+		highlight   string
 		keep        bool
 		fetchFailed bool
 		err         error
@@ -283,25 +576,57 @@ func analyzeContentWithProgress(ctx context.Context, safeConn *SafeWebSocketConn
 			contentCtx, contentCancel := context.WithTimeout(ctx, cfg.Timeouts.ContentFetch)
 			defer contentCancel()
 
-			content, err := fetchPageContent(contentCtx, results[i].URL, cfg)
+			var eval contentEval
+			page, err := fetchPageContent(contentCtx, results[i].URL, cfg)
 			if err != nil {
 				logger.Error("content fetch failed", "error", err, "url", results[i].URL)
-				resultsCh <- contentEval{idx: i, fetchFailed: true, err: err}
+				eval = contentEval{idx: i, fetchFailed: true, err: err}
 			} else {
-				relevant, relErr := isContentRelevantToPrompt(contentCtx, prompt, results[i].Title, results[i].URL, content, cfg)
+				// Судье отправляем наиболее релевантные фрагменты, а не начало страницы
+				passages := selectPassages(page.Text, prompt, queries, cfg.Content.PassageWords, cfg.Content.MaxPassages)
+				passages = fitPassages(passages, cfg.Content.TruncationLength)
+				excerpt := joinPassages(passages)
+				var highlight string
+				if best, ok := bestPassage(passages); ok {
+					highlight = best.Text
+				}
+
+				relevant, relErr := isContentRelevantToPrompt(contentCtx, prompt, results[i].Title, results[i].URL, excerpt, cfg)
 				if relErr != nil {
 					logger.Error("content relevance evaluation failed", "error", relErr, "url", results[i].URL)
 				}
-				resultsCh <- contentEval{idx: i, content: content, keep: relErr == nil && relevant, err: relErr}
+				eval = contentEval{idx: i, page: page, highlight: highlight, keep: relErr == nil && relevant, err: relErr}
 			}
+			resultsCh <- eval
 
 			mu.Lock()
 			completed++
+			currentCompleted := completed
 			mu.Unlock()
 
+			update := WSResultUpdate{
+				URL:       results[i].URL,
+				Verdict:   verdictDropped,
+				Highlight: eval.highlight,
+				Page:      eval.page,
+				Completed: currentCompleted,
+				Total:     len(results),
+			}
+			switch {
+			case eval.fetchFailed:
+				update.Reason = reasonFetchFailed
+			case eval.err != nil:
+				update.Reason = reasonJudgeFailed
+			case eval.keep:
+				update.Verdict, update.Reason = verdictKept, reasonRelevant
+			default:
+				update.Reason = reasonIrrelevant
+			}
+			sendSafeMessage(out, "result_update", update)
+
 			// Отправляем обновление прогресса
-			sendSafeStatus(safeConn, "analyzing_content", completed, len(results),
-				"Проанализировано страниц: %d/%d", completed, len(results))
+			sendSafeStatus(ctx, out, "analyzing_content", msgPagesAnalyzed, currentCompleted, len(results),
+				map[string]any{"completed": currentCompleted, "total": len(results)})
 
 			return nil
 		})
@@ -313,6 +638,10 @@ func analyzeContentWithProgress(ctx context.Context, safeConn *SafeWebSocketConn
 	// Фильтруем результаты
 	keepMap := make(map[int]bool, len(results))
 	for r := range resultsCh {
+		results[r.idx].Page = r.page
+		if r.highlight != "" {
+			results[r.idx].Highlight = r.highlight
+		}
 		if r.fetchFailed {
 			keepMap[r.idx] = false
 		} else if r.err != nil {
@@ -335,7 +664,7 @@ func analyzeContentWithProgress(ctx context.Context, safeConn *SafeWebSocketConn
 	return filtered
 }
 
-func filterByAIRelevanceWithProgress(ctx context.Context, safeConn *SafeWebSocketConn, prompt string, results []SearchResult, cfg AppConfig, logger *Logger) []SearchResult {
+func filterByAIRelevanceWithProgress(ctx context.Context, out messageSink, prompt string, results []SearchResult, cfg AppConfig, logger *Logger) []SearchResult {
 	type relevanceEval struct {
 		idx  int
 		keep bool
@@ -358,15 +687,20 @@ func filterByAIRelevanceWithProgress(ctx context.Context, safeConn *SafeWebSocke
 
 			// Используем существующую функцию isContentRelevantToPrompt для оценки одного элемента
 			// Передаем заголовок и сниппет как "контент"
-			content := results[i].Title + "\n" + results[i].Snippet
+			content := truncateForLLM(results[i].Title+"\n"+results[i].Snippet, cfg.Content.TruncationLength)
 			relevant, err := isContentRelevantToPrompt(relevanceCtx, prompt, results[i].Title, results[i].URL, content, cfg)
 
+			update := WSResultUpdate{URL: results[i].URL, Verdict: verdictDropped, Reason: reasonIrrelevant}
 			if err != nil {
 				logger.Error("ai relevance evaluation failed", "error", err, "url", results[i].URL)
 				// При ошибке включаем результат (чтобы не потерять данные)
 				resultsCh <- relevanceEval{idx: i, keep: true, err: err}
+				update.Verdict, update.Reason = verdictKept, reasonJudgeFailed
 			} else {
 				resultsCh <- relevanceEval{idx: i, keep: relevant, err: nil}
+				if relevant {
+					update.Verdict, update.Reason = verdictKept, reasonRelevant
+				}
 			}
 
 			mu.Lock()
@@ -374,9 +708,12 @@ func filterByAIRelevanceWithProgress(ctx context.Context, safeConn *SafeWebSocke
 			currentCompleted := completed
 			mu.Unlock()
 
+			update.Completed, update.Total = currentCompleted, len(results)
+			sendSafeMessage(out, "result_update", update)
+
 			// Отправляем обновление прогресса
-			sendSafeStatus(safeConn, "ai_filtering", currentCompleted, len(results),
-				"Проанализировано результатов: %d/%d", currentCompleted, len(results))
+			sendSafeStatus(ctx, out, "ai_filtering", msgResultsEvaluated, currentCompleted, len(results),
+				map[string]any{"completed": currentCompleted, "total": len(results)})
 
 			return nil
 		})
@@ -413,12 +750,8 @@ func sendMessage(conn *websocket.Conn, msgType string, data interface{}) error {
 	return conn.WriteJSON(msg)
 }
 
-func sendSafeMessage(safeConn *SafeWebSocketConn, msgType string, data interface{}) error {
-	msg := WSMessage{
-		Type: msgType,
-		Data: data,
-	}
-	return safeConn.WriteJSON(msg)
+func sendSafeMessage(out messageSink, msgType string, data interface{}) error {
+	return out.Send(msgType, data)
 }
 
 func sendStatus(conn *websocket.Conn, stage string, progress, total int, format string, args ...interface{}) {
@@ -439,22 +772,21 @@ func sendStatus(conn *websocket.Conn, stage string, progress, total int, format
 	sendMessage(conn, "status", status)
 }
 
-func sendSafeStatus(safeConn *SafeWebSocketConn, stage string, progress, total int, format string, args ...interface{}) {
-	var message string
-	if len(args) > 0 {
-		message = fmt.Sprintf(format, args...)
-	} else {
-		message = format
-	}
-
+// sendSafeStatus reports progress with a stable code and params, plus the
+// message rendered in the search's locale.
+func sendSafeStatus(ctx context.Context, out messageSink, stage, code string, progress, total int, params map[string]any) {
+	locale := localeFromContext(ctx)
 	status := WSSearchStatus{
 		Stage:     stage,
+		Code:      code,
+		Params:    params,
+		Message:   localize(locale, code, params),
+		Locale:    locale,
 		Progress:  progress,
 		Total:     total,
-		Message:   message,
 		Timestamp: time.Now().UnixMilli(),
 	}
-	sendSafeMessage(safeConn, "status", status)
+	sendSafeMessage(out, "status", status)
 }
 
 func sendError(conn *websocket.Conn, code, message, details string) {
@@ -466,11 +798,21 @@ func sendError(conn *websocket.Conn, code, message, details string) {
 	sendMessage(conn, "error", err)
 }
 
-func sendSafeError(safeConn *SafeWebSocketConn, code, message, details string) {
+func sendSafeError(out messageSink, code, message, details string) {
 	err := WSError{
 		Code:    code,
 		Message: message,
 		Details: details,
 	}
-	sendSafeMessage(safeConn, "error", err)
+	sendSafeMessage(out, "error", err)
+}
+
+func sendSafeAppError(out messageSink, appErr *AppError) {
+	err := WSError{
+		Code:       appErr.Code,
+		Message:    appErr.Message,
+		Details:    appErr.Details,
+		RetryAfter: appErr.RetryAfter.Milliseconds(),
+	}
+	sendSafeMessage(out, "error", err)
 }
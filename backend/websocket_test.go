@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeJudge serves OpenRouter chat completions that answer "1" when the
+// request mentions "good" and fails when it mentions "broken".
+func newFakeJudge(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "broken"):
+			http.Error(w, "upstream error", http.StatusBadGateway)
+		case strings.Contains(string(body), "good"):
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"1"}}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"0"}}]}`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestFilterByAIRelevanceStreamsResultUpdates(t *testing.T) {
+	judge := newFakeJudge(t)
+	cfg := defaultConfig()
+	cfg.OpenRouter.APIKey = "test"
+	cfg.OpenRouter.Endpoint = judge.URL
+	cfg.Search.MaxConcurrentFilter = 1
+
+	conn, msgs := newRecordingConn(t)
+	results := []SearchResult{
+		{URL: "https://a.com", Title: "good page"},
+		{URL: "https://b.com", Title: "off topic"},
+		{URL: "https://c.com", Title: "broken judge"},
+	}
+	kept := filterByAIRelevanceWithProgress(context.Background(), conn, "prompt", results, cfg, NewLogger())
+	if len(kept) != 2 || kept[0].URL != "https://a.com" || kept[1].URL != "https://c.com" {
+		t.Fatalf("unexpected kept results: %+v", kept)
+	}
+
+	want := map[string]WSResultUpdate{
+		"https://a.com": {Verdict: verdictKept, Reason: reasonRelevant},
+		"https://b.com": {Verdict: verdictDropped, Reason: reasonIrrelevant},
+		"https://c.com": {Verdict: verdictKept, Reason: reasonJudgeFailed},
+	}
+	for len(want) > 0 {
+		raw, ok := <-msgs
+		if !ok {
+			t.Fatalf("connection closed, missing updates for %v", want)
+		}
+		var msg struct {
+			Type string         `json:"type"`
+			Data WSResultUpdate `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil || msg.Type != "result_update" {
+			continue
+		}
+		exp, ok := want[msg.Data.URL]
+		if !ok || msg.Data.Verdict != exp.Verdict || msg.Data.Reason != exp.Reason || msg.Data.Total != 3 {
+			t.Fatalf("unexpected update %+v", msg.Data)
+		}
+		delete(want, msg.Data.URL)
+	}
+}
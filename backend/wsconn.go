@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteWait bounds every write so a stalled peer cannot block a search.
+const wsWriteWait = 10 * time.Second
+
+var ErrShuttingDown = &AppError{
+	Code:    "SERVER_SHUTTING_DOWN",
+	Message: "Server is shutting down and does not accept new searches",
+	Status:  http.StatusServiceUnavailable,
+}
+
+// WSServerShutdown is sent to every connection when draining starts.
+type WSServerShutdown struct {
+	Message        string `json:"message"`
+	DrainTimeoutMs int64  `json:"drain_timeout_ms"`
+}
+
+// ConnManager tracks open WebSocket connections and running searches. It
+// enforces the global connection limit and drains both on shutdown.
+type ConnManager struct {
+	mu       sync.Mutex
+	conns    map[*SafeWebSocketConn]struct{}
+	searches int
+	draining bool
+	idle     chan struct{} // closed once draining and no searches remain
+	// abort is cancelled when draining gives up on the remaining searches
+	abort       context.Context
+	cancelAbort context.CancelFunc
+}
+
+func NewConnManager() *ConnManager {
+	abort, cancel := context.WithCancel(context.Background())
+	return &ConnManager{
+		conns:       make(map[*SafeWebSocketConn]struct{}),
+		idle:        make(chan struct{}),
+		abort:       abort,
+		cancelAbort: cancel,
+	}
+}
+
+// SearchContext returns the context for a search. It keeps the values of
+// parent but not its cancellation, so a search outlives its connection; it
+// ends after timeout or when a drain times out.
+func (m *ConnManager) SearchContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), timeout)
+	stop := context.AfterFunc(m.abort, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// AbortContext returns a context that is cancelled when a drain gives up on
+// the remaining searches.
+func (m *ConnManager) AbortContext() context.Context {
+	return m.abort
+}
+
+// Register adds c unless max connections are already open or the server
+// is draining.
+func (m *ConnManager) Register(c *SafeWebSocketConn, max int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.draining || (max > 0 && len(m.conns) >= max) {
+		return false
+	}
+	m.conns[c] = struct{}{}
+	websocketConnections.Inc()
+	return true
+}
+
+func (m *ConnManager) Unregister(c *SafeWebSocketConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.conns[c]; ok {
+		delete(m.conns, c)
+		websocketConnections.Dec()
+	}
+}
+
+// Count returns the number of open connections.
+func (m *ConnManager) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
+
+// Draining reports whether shutdown has started.
+func (m *ConnManager) Draining() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.draining
+}
+
+// BeginSearch records a new search. It returns false once draining has
+// started; otherwise EndSearch must be called when the search finishes.
+func (m *ConnManager) BeginSearch() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.draining {
+		return false
+	}
+	m.searches++
+	return true
+}
+
+func (m *ConnManager) EndSearch() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.searches--
+	if m.draining && m.searches == 0 {
+		close(m.idle)
+	}
+}
+
+// Drain stops new connections and searches, tells every client that the
+// server is going away and waits for running searches until ctx is done.
+// Remaining connections are then closed with a Going Away close frame. It
+// returns the number of searches that were still running when ctx expired.
+func (m *ConnManager) Drain(ctx context.Context, logger *Logger) int {
+	m.mu.Lock()
+	if m.draining {
+		m.mu.Unlock()
+		return 0
+	}
+	m.draining = true
+	if m.searches == 0 {
+		close(m.idle)
+	}
+	conns := m.snapshotLocked()
+	running := m.searches
+	m.mu.Unlock()
+
+	notice := WSServerShutdown{Message: "Server is shutting down, running searches will be allowed to finish"}
+	if deadline, ok := ctx.Deadline(); ok {
+		notice.DrainTimeoutMs = time.Until(deadline).Milliseconds()
+	}
+	for _, c := range conns {
+		_ = sendSafeMessage(c, "server_shutdown", notice)
+	}
+	logger.Info("draining websocket connections", "connections", len(conns), "searches", running)
+
+	var abandoned int
+	select {
+	case <-m.idle:
+	case <-ctx.Done():
+		m.mu.Lock()
+		abandoned = m.searches
+		m.mu.Unlock()
+		logger.Warn("drain timeout expired, cancelling remaining searches", "searches", abandoned)
+		m.cancelAbort()
+	}
+
+	m.mu.Lock()
+	conns = m.snapshotLocked()
+	m.mu.Unlock()
+	for _, c := range conns {
+		_ = c.Close(websocket.CloseGoingAway, "server shutting down")
+	}
+	return abandoned
+}
+
+func (m *ConnManager) snapshotLocked() []*SafeWebSocketConn {
+	conns := make([]*SafeWebSocketConn, 0, len(m.conns))
+	for c := range m.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// configureHeartbeat applies the read limit and idle deadline to conn. Any
+// message or pong from the peer extends the deadline, so a peer that stops
+// answering pings is dropped after timeout.
+func configureHeartbeat(conn *websocket.Conn, maxMessageSize int64, timeout time.Duration) {
+	conn.SetReadLimit(maxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+}
+
+// keepAlive pings the peer every interval until done is closed or a ping
+// fails.
+func (sws *SafeWebSocketConn) keepAlive(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// WriteControl можно вызывать параллельно с остальными записями
+			if err := sws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close sends a close frame with code and reason and closes the connection.
+func (sws *SafeWebSocketConn) Close(code int, reason string) error {
+	_ = sws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+	return sws.conn.Close()
+}
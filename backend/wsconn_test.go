@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestWSServer(t *testing.T, mutate func(*AppConfig)) (*httptest.Server, *ConnManager) {
+	t.Helper()
+	cfg := defaultConfig()
+	mutate(&cfg)
+	logger := NewLogger()
+	store := NewConfigStore("", cfg, logger)
+	auth := NewAuthenticator(cfg.Auth)
+	limiter := NewRateLimiter()
+	conns := NewConnManager()
+	ts := httptest.NewServer(&WSHandler{
+		store:    store,
+		auth:     auth,
+		limiter:  limiter,
+		conns:    conns,
+		sessions: NewSearchSessions(),
+		slots:    NewSearchSlots(),
+		logger:   logger,
+	})
+	t.Cleanup(ts.Close)
+	return ts, conns
+}
+
+func dialTestWS(t *testing.T, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitForConnCount(t *testing.T, conns *ConnManager, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for conns.Count() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d open connections, got %d", n, conns.Count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectCloseCode(t *testing.T, conn *websocket.Conn, code int) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, code) {
+		t.Fatalf("expected close code %d, got %v", code, err)
+	}
+}
+
+func TestWebSocketMaxConnections(t *testing.T) {
+	ts, conns := newTestWSServer(t, func(cfg *AppConfig) { cfg.WebSocket.MaxConnections = 1 })
+
+	dialTestWS(t, ts)
+	waitForConnCount(t, conns, 1)
+
+	expectCloseCode(t, dialTestWS(t, ts), websocket.CloseTryAgainLater)
+	if conns.Count() != 1 {
+		t.Fatalf("rejected connection must not be counted, got %d", conns.Count())
+	}
+}
+
+func TestWebSocketMessageTooLarge(t *testing.T) {
+	ts, _ := newTestWSServer(t, func(cfg *AppConfig) { cfg.WebSocket.MaxMessageSize = 64 })
+
+	conn := dialTestWS(t, ts)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"search","data":"`+strings.Repeat("x", 200)+`"}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expectCloseCode(t, conn, websocket.CloseMessageTooBig)
+}
+
+func TestWebSocketDropsSilentPeer(t *testing.T) {
+	ts, conns := newTestWSServer(t, func(cfg *AppConfig) {
+		cfg.WebSocket.MessageTimeout = 200 * time.Millisecond
+		cfg.WebSocket.PingInterval = 50 * time.Millisecond
+	})
+
+	// Клиент не читает, поэтому понги не отправляются
+	dialTestWS(t, ts)
+	waitForConnCount(t, conns, 1)
+	waitForConnCount(t, conns, 0)
+}
+
+func TestConnManagerDrain(t *testing.T) {
+	ts, conns := newTestWSServer(t, func(*AppConfig) {})
+	conn := dialTestWS(t, ts)
+	waitForConnCount(t, conns, 1)
+
+	// Имитируем поиск, который еще выполняется
+	if !conns.BeginSearch() {
+		t.Fatal("expected search to be admitted before draining")
+	}
+	drained := make(chan int, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- conns.Drain(ctx, NewLogger())
+	}()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg WSMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "server_shutdown" {
+		t.Fatalf("expected server_shutdown notice, got %+v, %v", msg, err)
+	}
+
+	if err := conn.WriteJSON(WSMessage{Type: "search", Data: map[string]any{"prompt": "test"}}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "error" || !strings.Contains(fmt.Sprint(msg.Data), ErrShuttingDown.Code) {
+		t.Fatalf("expected %s error for a new search, got %+v, %v", ErrShuttingDown.Code, msg, err)
+	}
+
+	conns.EndSearch()
+	if abandoned := <-drained; abandoned != 0 {
+		t.Fatalf("expected no abandoned searches, got %d", abandoned)
+	}
+	expectCloseCode(t, conn, websocket.CloseGoingAway)
+
+	// Новые соединения во время остановки закрываются сразу
+	expectCloseCode(t, dialTestWS(t, ts), websocket.CloseServiceRestart)
+}
+
+func TestConnManagerDrainTimeout(t *testing.T) {
+	conns := NewConnManager()
+	conns.BeginSearch()
+	searchCtx, stop := conns.SearchContext(context.Background(), time.Minute)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if abandoned := conns.Drain(ctx, NewLogger()); abandoned != 1 {
+		t.Fatalf("expected one abandoned search, got %d", abandoned)
+	}
+	select {
+	case <-searchCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("abandoned searches must be cancelled")
+	}
+	conns.EndSearch()
+	if conns.BeginSearch() {
+		t.Fatal("searches must be refused after draining")
+	}
+}
+
+// newRecordingConn returns the server side of a live WebSocket connection
+// and a channel with every text message the client receives.
+func newRecordingConn(t *testing.T) (*SafeWebSocketConn, <-chan string) {
+	t.Helper()
+	serverConn := make(chan *SafeWebSocketConn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serverConn <- NewSafeWebSocketConn(conn)
+	}))
+	t.Cleanup(ts.Close)
+
+	client := dialTestWS(t, ts)
+	msgs := make(chan string, 64)
+	go func() {
+		defer close(msgs)
+		for {
+			_, data, err := client.ReadMessage()
+			if err != nil {
+				return
+			}
+			msgs <- string(data)
+		}
+	}()
+
+	conn := <-serverConn
+	t.Cleanup(func() { conn.conn.Close() })
+	return conn, msgs
+}
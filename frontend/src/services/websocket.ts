@@ -1,7 +1,9 @@
-import type { WSMessage, WSSearchStatus, WSSearchResult, AppError, SearchRequestSettings } from '../types'
+import type { WSMessage, WSSearchStarted, WSSearchStatus, WSSearchResult, WSPartialResults, WSResultUpdate, AppError, SearchRequestSettings } from '../types'
 
 export interface WebSocketSearchCallbacks {
   onStatus?: (status: WSSearchStatus) => void
+  onPartialResults?: (partial: WSPartialResults) => void
+  onResultUpdate?: (update: WSResultUpdate) => void
   onResult?: (result: WSSearchResult) => void
   onError?: (error: AppError) => void
   onConnect?: () => void
@@ -14,6 +16,9 @@ export class WebSocketSearchClient {
   private reconnectAttempts = 0
   private maxReconnectAttempts = 5
   private callbacks: WebSocketSearchCallbacks = {}
+  // Токен и номер последнего полученного события текущего поиска для возобновления
+  private resumeToken: string | null = null
+  private lastSeq = 0
 
   constructor(private baseUrl: string = '') {
     // Определяем WebSocket URL на основе текущего location
@@ -30,11 +35,14 @@ export class WebSocketSearchClient {
     return new Promise((resolve, reject) => {
       try {
         const wsUrl = `${this.baseUrl}/api/ws/search`
-        this.ws = new WebSocket(wsUrl)
+        // Браузер не позволяет передать заголовки, поэтому ключ идет подпротоколом
+        const apiKey = import.meta.env.VITE_API_KEY as string | undefined
+        this.ws = apiKey ? new WebSocket(wsUrl, [`apikey.${apiKey}`]) : new WebSocket(wsUrl)
 
         this.ws.onopen = () => {
           console.log('WebSocket connected')
           this.reconnectAttempts = 0
+          this.resume()
           this.callbacks.onConnect?.()
           resolve()
         }
@@ -81,6 +89,16 @@ export class WebSocketSearchClient {
     }
   }
 
+  // Просим сервер дослать пропущенные события поиска, прерванного обрывом связи
+  private resume(): void {
+    if (!this.resumeToken || !this.ws) return
+    const message: WSMessage = {
+      type: 'resume',
+      data: { resume_token: this.resumeToken, last_seq: this.lastSeq }
+    }
+    this.ws.send(JSON.stringify(message))
+  }
+
   search(prompt: string, settings: SearchRequestSettings): void {
     if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
       throw new Error('WebSocket not connected')
@@ -90,26 +108,55 @@ export class WebSocketSearchClient {
       type: 'search',
       data: {
         prompt,
-        settings
+        settings,
+        // Интерфейс на русском, поэтому статусы просим на нем же
+        locale: 'ru'
       }
     }
 
+    this.resumeToken = null
+    this.lastSeq = 0
     this.ws.send(JSON.stringify(message))
   }
 
   private handleMessage(message: WSMessage): void {
+    if (message.seq) {
+      this.lastSeq = message.seq
+    }
     switch (message.type) {
+      case 'search_started':
+        this.resumeToken = (message.data as WSSearchStarted).resume_token
+        break
+
       case 'status':
         this.callbacks.onStatus?.(message.data as WSSearchStatus)
         break
       
+      case 'partial_results':
+        this.callbacks.onPartialResults?.(message.data as WSPartialResults)
+        break
+
+      case 'result_update':
+        this.callbacks.onResultUpdate?.(message.data as WSResultUpdate)
+        break
+
       case 'search_complete':
+        this.resumeToken = null
         this.callbacks.onResult?.(message.data as WSSearchResult)
         break
       
       case 'error':
+        // Ошибка поиска завершает его, ошибку возобновления просто сообщаем
+        if (message.search_id || (message.data as AppError).code === 'RESUME_NOT_FOUND') {
+          this.resumeToken = null
+        }
         this.callbacks.onError?.(message.data as AppError)
         break
+
+      case 'server_shutdown':
+        // Сервер закроет соединение после завершения текущих поисков, затем переподключимся
+        console.warn('Server is shutting down:', message.data)
+        break
       
       default:
         console.warn('Unknown message type:', message.type)
@@ -142,6 +189,11 @@ export class WebSocketSearchClient {
     }, delay)
   }
 
+  // Поиск можно возобновить после переподключения
+  get canResume(): boolean {
+    return this.resumeToken !== null
+  }
+
   get isConnected(): boolean {
     return this.ws?.readyState === WebSocket.OPEN
   }
@@ -78,6 +78,7 @@ export const useSearchStore = defineStore('search', {
       this.searchStartTime = Date.now()
       this.searchElapsed = 0
       this.searchStatus = null
+      this.results = []
       
       try {
         await this.connectAndSearch(prompt, settings)
@@ -98,6 +99,23 @@ export const useSearchStore = defineStore('search', {
           onStatus: (status) => {
             this.searchStatus = status
           },
+          onPartialResults: (partial) => {
+            // Показываем найденное сразу, порядок уточнится после завершения
+            const known = new Set(this.results.map(r => r.url))
+            const fresh = partial.results.filter(r => !known.has(r.url))
+            this.results = [...this.results, ...fresh]
+          },
+          onResultUpdate: (update) => {
+            if (update.verdict === 'dropped') {
+              this.results = this.results.filter(r => r.url !== update.url)
+              return
+            }
+            const item = this.results.find(r => r.url === update.url)
+            if (item) {
+              if (update.highlight) item.highlight = update.highlight
+              if (update.page) item.page = update.page
+            }
+          },
           onResult: (result) => {
             this.results = result.results
             this.queries = result.queries
@@ -112,8 +130,8 @@ export const useSearchStore = defineStore('search', {
             this.searchStatus = null // Очищаем статус при ошибке
           },
           onDisconnect: () => {
-            // При отключении во время поиска считаем это ошибкой
-            if (this.loadingState === 'loading') {
+            // Поиск продолжится после переподключения, ошибкой считаем только невозобновляемый
+            if (this.loadingState === 'loading' && !this.wsClient?.canResume) {
               this.error = {
                 code: 'CONNECTION_LOST',
                 message: 'Соединение потеряно во время поиска',
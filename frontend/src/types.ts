@@ -3,18 +3,42 @@ export interface SearchResult {
   url: string
   snippet: string
   score: number
+  highlight?: string
+  page?: PageDocument
+  engines?: string[]
+  positions?: number[]
+  category?: string
+  published_date?: string
+  queries?: string[]
+}
+
+export interface PageDocument {
+  title?: string
+  author?: string
+  site_name?: string
+  published_at?: string
+  modified_at?: string
+  language?: string
+  word_count: number
+  image?: string
+  excerpt?: string
 }
 
 export interface SearchRequestSettings {
   queries: number
   content_mode: boolean
   engines?: string[]
+  categories?: string[]
+  time_range?: '' | 'day' | 'week' | 'month' | 'year'
+  safesearch?: 0 | 1 | 2
+  pages?: number
 }
 
 export interface AppError {
   code: string
   message: string
   details?: string
+  retry_after_ms?: number
 }
 
 
@@ -25,18 +49,75 @@ export type LoadingState = 'idle' | 'loading' | 'success' | 'error'
 export interface WSMessage {
   type: string
   data: any
+  seq?: number
+  search_id?: string
+}
+
+export interface WSSearchStarted {
+  search_id: string
+  resume_token: string
+  retention_ms: number
 }
 
 export interface WSSearchStatus {
   stage: string
+  code: string
+  params?: Record<string, string | number>
   progress: number
   total: number
   message: string
+  locale: string
   timestamp: number
 }
 
+export interface PartialResult extends SearchResult {
+  rank: number
+}
+
+export interface WSPartialResults {
+  query: string
+  results: PartialResult[]
+  total: number
+}
+
+export interface WSResultUpdate {
+  url: string
+  verdict: 'kept' | 'dropped'
+  reason: 'relevant' | 'irrelevant' | 'fetch_failed' | 'judge_failed'
+  highlight?: string
+  page?: PageDocument
+  completed: number
+  total: number
+}
+
 export interface WSSearchResult {
   queries: string[]
   results: SearchResult[]
   elapsed_ms: number
+  answers?: SearxAnswer[]
+  infoboxes?: SearxInfobox[]
+  suggestions?: string[]
+  corrections?: string[]
+  unresponsive_engines?: UnresponsiveEngine[]
+}
+
+export interface SearxAnswer {
+  answer: string
+  url?: string
+  engine?: string
+}
+
+export interface SearxInfobox {
+  infobox: string
+  id?: string
+  content?: string
+  img_src?: string
+  urls?: { title: string; url: string }[]
+  attributes?: { label: string; value: string }[]
+  engine?: string
+}
+
+export interface UnresponsiveEngine {
+  engine: string
+  reason: string
 }
@@ -9,6 +9,8 @@ export default defineConfig({
         target: 'http://localhost:8080',
         changeOrigin: true,
       },
+      '/opensearch.xml': 'http://localhost:8080',
+      '^/search(\\?|$)': 'http://localhost:8080',
     },
   },
   build: {